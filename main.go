@@ -1,15 +1,24 @@
 package main
 
 import (
+	"context"
 	"time"
+	"errors"
+	"io"
 	"path/filepath"
 	"strconv"
 	"log"
 	"os"
+	"os/signal"
 
+	"github.com/lirancohen/blockparser/pkg/address"
+	"github.com/lirancohen/blockparser/pkg/chain"
 	"github.com/lirancohen/blockparser/pkg/chunker"
+	"github.com/lirancohen/blockparser/pkg/hashes"
+	"github.com/lirancohen/blockparser/pkg/index"
+	"github.com/lirancohen/blockparser/pkg/network"
 	"github.com/lirancohen/blockparser/pkg/parser"
-	"bufio"
+	"github.com/lirancohen/blockparser/pkg/source"
 )
 
 const(
@@ -24,55 +33,94 @@ func main() {
 
 	args := os.Args[1:]
 
+	net, err := getNetwork(args)
+	if err != nil {
+		panic(err)
+	}
+	log.Printf("Network: %v\n", net)
+	blocksDir := getBlocksDir(args)
+	wtxid := getWtxid(args)
+	txIndex := getTxIndex(args) || wtxid
+
 	if ok := getRechunk(args); ok {
 		log.Println("Rechunking...")
-		if err := bootStrap(true); err != nil {
+		if err := bootStrap(true, net, blocksDir, txIndex, wtxid); err != nil {
 			panic(err)
 		}
 		return
 	}
 
 	log.Println("Bootstrapping...")
-	err := bootStrap(false)
+	err = bootStrap(false, net, blocksDir, txIndex, wtxid)
 	if err != nil {
 		panic(err)
 	}
 	log.Println("Starting...")
 
+	if addr := getAddress(args); addr != "" {
+		if err := addressHistory(net, addr); err != nil {
+			panic(err)
+		}
+		log.Printf("Time Elapsed %v\n", time.Since(startTime).String())
+		return
+	}
+
 	chunk := parser.EmptyStream()
-	f, err := os.Open("./data/bootstrap.dat")
-	if err != nil {
-		panic(err)
+	chunk.Network = net
+	if idx, err := index.LoadBlockIndex(index.DefaultBlockIndexPath); err == nil {
+		chunk.Index = idx
+	} else {
+		log.Printf("Block index unavailable: %v\n", err)
+	}
+	if idx, err := index.LoadTxIndex(index.DefaultTxIndexPath); err == nil {
+		defer idx.Close()
+		chunk.TxIndex = idx
+	} else {
+		log.Printf("Transaction index unavailable: %v\n", err)
+	}
+	if idx, err := index.LoadSpendIndex(index.DefaultSpendIndexPath); err == nil {
+		defer idx.Close()
+		chunk.SpendIndex = idx
+	} else {
+		log.Printf("Spend index unavailable: %v\n", err)
 	}
-	chunk.Stream = bufio.NewReader(f)
 
 	seekBlock := time.Now()
+	txid := "e55782cc3b70c3fb2e11de1ac2d04249296735647f6d5ea047ceab894c717211"
+	if chunk.TxIndex != nil {
+		if t, err := chunk.LookupTransaction(txid); err == nil {
+			log.Printf("Found Transaction: %v\n", t.HashString())
+			log.Printf("Found Transaction in: %v\n", time.Since(seekBlock).String())
+			if chunk.SpendIndex != nil {
+				for n, r := range t.SpentOutputs(chunk.SpendIndex) {
+					log.Printf("Output %v Spent By: %v:%v at height %v\n", n, hashes.String(r.Txid[:]), r.Input, r.Height)
+				}
+			}
+			log.Printf("Time Elapsed %v\n", time.Since(startTime).String())
+			return
+		} else {
+			log.Printf("Transaction Lookup Error: %v\n", err)
+		}
+	}
 	//b, err := chunk.SeekBlock(153398)
 	//if err != nil {
 	//	panic(err)
 	//}
 	//
 	//fmt.Println(b.PrintBlockInfo())
-	i := 0
-	for{
-		log.Println(i)
-		//chunk, err = chunk.Next()
-		//if err == nil {
-			t, err := chunk.SeekTransaction("e55782cc3b70c3fb2e11de1ac2d04249296735647f6d5ea047ceab894c717211")
-			if err != nil && err.Error() != parser.ErrNotFound.Error() {
-				panic(err)
-			} else if err.Error() == parser.ErrNotFound.Error() {
-				i++
-				continue
-			}
-			log.Println("Found Transaction: %v\n", t)
-			log.Println("Found Transaction in: %v\n", time.Since(seekBlock).String())
-		//} else {
-		//	log.Printf("Error: %v\n", err.Error())
-		//	break
-		//}
-	}
 
+	//No index to go by, scan the chunks until the transaction shows up or Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	it := parser.NewBlockIterator(net, 0, -1)
+	it.Index = chunk.Index
+	defer it.Close()
+	if t, err := findTransaction(ctx, it, txid); err == nil {
+		log.Printf("Found Transaction: %v\n", t.HashString())
+		log.Printf("Found Transaction in: %v\n", time.Since(seekBlock).String())
+	} else {
+		log.Printf("Transaction Scan Error: %v\n", err)
+	}
 
 	//var offset,length int
 	//
@@ -99,13 +147,65 @@ func main() {
 	log.Printf("Time Elapsed %v\n", time.Since(startTime).String())
 }
 
+//Scans blocks from it until one holds txid, parser.ErrNotFound once the iterator runs out
+func findTransaction(ctx context.Context, it *parser.BlockIterator, txid string) (parser.Transaction, error) {
+	for {
+		b, err := it.Next(ctx)
+		if err == io.EOF {
+			return parser.Transaction{}, parser.ErrNotFound
+		} else if err != nil {
+			return parser.Transaction{}, err
+		}
+		for _, t := range b.Transactions {
+			if t.HashString() == txid {
+				return t, nil
+			}
+		}
+	}
+}
+
 func getRechunk(args []string) bool {
-	if len(args) > 0 && args[0] == "--rechunk" {
-		return true
+	for _, arg := range args {
+		if arg == "--rechunk" {
+			return true
+		}
+	}
+	return false
+}
+
+//--txindex builds the transaction and spend indexes while chunking, they're held in memory until chunking ends
+func getTxIndex(args []string) bool {
+	for _, arg := range args {
+		if arg == "--txindex" {
+			return true
+		}
+	}
+	return false
+}
+
+//--wtxid also indexes witness transaction ids, implies --txindex and only takes effect when chunking
+func getWtxid(args []string) bool {
+	for _, arg := range args {
+		if arg == "--wtxid" {
+			return true
+		}
 	}
 	return false
 }
 
+//--network <mainnet|testnet3|testnet4|signet|regtest>, defaults to mainnet
+func getNetwork(args []string) (*network.Network, error) {
+	for i, arg := range args {
+		if arg == "--network" {
+			if i+1 >= len(args) {
+				return nil, errors.New("--network requires a network name")
+			}
+			return network.ByName(args[i+1])
+		}
+	}
+	return network.MainNet, nil
+}
+
 func getRange(args []string) (int, int, bool) {
 	if(len(args) >= 3 && args[0] == "--range"){
 		i1,err1 := strconv.Atoi(args[1])
@@ -141,7 +241,58 @@ func getLength(args []string) int {
 	return DEFAULT_LENGTH
 }
 
-func bootStrap(rechunk bool) error {
+//--blocks <dir>, a Bitcoin Core blocks directory to read instead of ./data/bootstrap.dat
+func getBlocksDir(args []string) string {
+	for i, arg := range args {
+		if arg == "--blocks" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+//--address <addr>, prints the history and balance of addr
+func getAddress(args []string) string {
+	for i, arg := range args {
+		if arg == "--address" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+//Prints every output paid to addr and its current balance, building the address index from the chunks on first use
+func addressHistory(net *network.Network, addr string) error {
+	script, err := address.ToScript(addr, net)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.LoadAddressIndex(index.DefaultAddressIndexPath)
+	if err != nil {
+		log.Printf("Address index unavailable, building from chunks: %v\n", err)
+		s := parser.EmptyStream()
+		s.Network = net
+		if idx, err = parser.BuildAddressIndex(s); err != nil {
+			return err
+		}
+		if err := idx.Save(index.DefaultAddressIndexPath); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range idx.History(script) {
+		if e.Spent {
+			log.Printf("%v:%v Height: %v Value: %v Spent By: %v:%v at height %v\n", hashes.String(e.Txid[:]), e.Vout, e.Height, e.Value, hashes.String(e.SpentBy.Txid[:]), e.SpentBy.Input, e.SpentBy.Height)
+		} else {
+			log.Printf("%v:%v Height: %v Value: %v Unspent\n", hashes.String(e.Txid[:]), e.Vout, e.Height, e.Value)
+		}
+	}
+	log.Printf("Address: %v Outputs: %v Balance: %v\n", addr, len(idx.History(script)), idx.Balance(script))
+	return nil
+}
+
+func bootStrap(rechunk bool, net *network.Network, blocksDir string, txIndex, wtxid bool) error {
 
 	_, err := os.Stat("./data/chunks")
 	if err != nil{
@@ -171,12 +322,39 @@ func bootStrap(rechunk bool) error {
 	os.RemoveAll("./data/chunks/")
 	os.MkdirAll("./data/chunks", os.ModePerm)
 
-	f, err := os.Open("./data/bootstrap.dat")
-	if err != nil {
-		return err
+	var f io.Reader
+	if blocksDir != "" {
+		//blk*.dat files are out of height order, chunk the most-work chain in height order instead
+		src, err := source.NewBlkFileSource(blocksDir, net)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		ch, err := chain.BuildFromSource(src)
+		if err != nil {
+			return err
+		}
+		log.Printf("Main Chain Height: %v\n", len(ch.Main)-1)
+		for _, n := range ch.Stale {
+			log.Printf("Stale Block: %v at height %v\n", n.HashString(), n.Height)
+		}
+		for _, n := range ch.Orphans {
+			log.Printf("Orphan Block: %v\n", n.HashString())
+		}
+		f = ch.Reader(src)
+	} else {
+		f, err = os.Open("./data/bootstrap.dat")
+		if err != nil {
+			return err
+		}
 	}
 
-	c := chunker.New(f)
+	c := chunker.New(f, net)
+	if txIndex {
+		c.TxIndex = index.NewTxIndex(wtxid)
+		c.SpendIndex = index.NewSpendIndex()
+	}
 	n, err := c.Chunk()
 	if err != nil {
 		return err
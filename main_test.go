@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/network"
+)
+
+func TestGetNetwork(t *testing.T) {
+	cases := []struct {
+		args []string
+		want *network.Network
+	}{
+		{nil, network.MainNet},
+		{[]string{"--bootstrap"}, network.MainNet},
+		{[]string{"--network", "testnet3"}, network.TestNet3},
+		{[]string{"--bootstrap", "--network", "testnet4", "--txindex"}, network.TestNet4},
+		{[]string{"--network", "signet", "--range", "0", "10"}, network.SigNet},
+		{[]string{"--network", "regtest"}, network.RegTest},
+	}
+	for _, c := range cases {
+		if n, err := getNetwork(c.args); err != nil || n != c.want {
+			t.Errorf("%v: %v, %v", c.args, n, err)
+		}
+	}
+	for _, args := range [][]string{{"--network"}, {"--network", "nonet"}} {
+		if n, err := getNetwork(args); err == nil {
+			t.Errorf("%v: %v", args, n)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package address
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lirancohen/blockparser/pkg/network"
+	"github.com/lirancohen/blockparser/pkg/script"
+)
+
+var ErrNoAddress = errors.New("script has no address form")
+
+//Derives the address an output script pays to.
+//Bare pubkey, multisig, OP_RETURN and nonstandard scripts have no address.
+func FromScript(s []byte, net *network.Network) (string, error) {
+	if net == nil {
+		net = network.MainNet
+	}
+	c := script.Classify(s)
+	switch c.Class {
+	case script.PubKeyHash:
+		return EncodeBase58Check(net.PubKeyHashAddrID, c.Data[0]), nil
+	case script.ScriptHash:
+		return EncodeBase58Check(net.ScriptHashAddrID, c.Data[0]), nil
+	case script.WitnessV0PubKeyHash, script.WitnessV0ScriptHash, script.WitnessV1Taproot, script.WitnessUnknown:
+		return EncodeSegwit(net.Bech32HRP, c.WitnessVersion, c.Data[0])
+	}
+	return "", fmt.Errorf("%w: %v", ErrNoAddress, c.Class)
+}
+
+//Builds the output script an address pays to
+func ToScript(addr string, net *network.Network) ([]byte, error) {
+	if net == nil {
+		net = network.MainNet
+	}
+
+	if strings.HasPrefix(strings.ToLower(addr), net.Bech32HRP+"1") {
+		version, program, err := DecodeSegwit(net.Bech32HRP, addr)
+		if err != nil {
+			return nil, err
+		}
+		s := []byte{script.OP_0}
+		if version > 0 {
+			s[0] = byte(script.OP_1 + version - 1)
+		}
+		s = append(s, byte(len(program)))
+		return append(s, program...), nil
+	}
+
+	version, payload, err := DecodeBase58Check(addr)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) != 20 {
+		return nil, fmt.Errorf("invalid address payload length %v", len(payload))
+	}
+	switch version {
+	case net.PubKeyHashAddrID:
+		s := []byte{script.OP_DUP, script.OP_HASH160, 20}
+		s = append(s, payload...)
+		return append(s, script.OP_EQUALVERIFY, script.OP_CHECKSIG), nil
+	case net.ScriptHashAddrID:
+		s := []byte{script.OP_HASH160, 20}
+		s = append(s, payload...)
+		return append(s, script.OP_EQUAL), nil
+	}
+	return nil, fmt.Errorf("address version %#x doesn't belong to %v", version, net)
+}
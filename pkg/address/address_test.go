@@ -0,0 +1,179 @@
+package address
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/network"
+)
+
+//BIP173 and BIP350 valid segwit addresses with the scripts they pay to
+func TestSegwitValid(t *testing.T) {
+	cases := []struct {
+		addr   string
+		net    *network.Network
+		script string
+	}{
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", network.MainNet, "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", network.TestNet3, "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", network.MainNet, "5128751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"BC1SW50QGDZ25J", network.MainNet, "6002751e"},
+		{"bc1zw508d6qejxtdg4y5r3zarvaryvaxxpcs", network.MainNet, "5210751e76e8199196d454941c45d1b3a323"},
+		{"tb1qqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesrxh6hy", network.TestNet3, "0020000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		{"tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c", network.TestNet3, "5120000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", network.MainNet, "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+	}
+	for _, c := range cases {
+		s, err := ToScript(c.addr, c.net)
+		if err != nil || hex.EncodeToString(s) != c.script {
+			t.Errorf("%v: %x, %v", c.addr, s, err)
+			continue
+		}
+		//Addresses are encoded in lower case
+		if back, err := FromScript(s, c.net); err != nil || back != strings.ToLower(c.addr) {
+			t.Errorf("%v: encoded as %v, %v", c.addr, back, err)
+		}
+	}
+}
+
+//BIP173 and BIP350 invalid segwit addresses
+func TestSegwitInvalid(t *testing.T) {
+	cases := []struct {
+		addr   string
+		reason string
+	}{
+		{"tc1qw508d6qejxtdg4y5r3zarvary0c5xw7kg3g4ty", "invalid hrp"},
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5", "invalid checksum"},
+		{"BC13W508D6QEJXTDG4Y5R3ZARVARY0C5XW7KN40WF2", "invalid witness version"},
+		{"bc1rw5uspcuh", "invalid program length"},
+		{"bc10w508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kw5rljs90", "invalid program length"},
+		{"BC1QR508D6QEJXTDG4Y5R3ZARVARYV98GJ9P", "invalid v0 program length"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sL5k7", "mixed case"},
+		{"bc1zw508d6qejxtdg4y5r3zarvaryvqyzf3du", "zero padding of more than 4 bits"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3pjxtptv", "non-zero padding"},
+		{"bc1gmk9yu", "empty data section"},
+		{"tc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq5zuyut", "invalid hrp"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd", "bech32 checksum for v1"},
+		{"tb1z0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqglt7rf", "bech32 checksum for v2"},
+		{"BC1S0XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ54WELL", "bech32 checksum for v16"},
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh", "bech32m checksum for v0"},
+		{"tb1q0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq24jc47", "bech32m checksum for v0"},
+		{"bc1p38j9r5y49hruaue7wxjce0updqjuyyx0kh56v8s25huc6995vvpql3jow4", "invalid character"},
+		{"BC130XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ7ZWS8R", "invalid witness version"},
+		{"bc1pw5dgrnzv", "invalid program length"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v8n0nx0muaewav253zgeav", "invalid program length"},
+		{"tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq47Zagq", "mixed case"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v07qwwzcrf", "zero padding of more than 4 bits"},
+		{"tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vpggkg4j", "non-zero padding"},
+	}
+	for _, c := range cases {
+		hrp := "bc"
+		if strings.HasPrefix(strings.ToLower(c.addr), "tb") {
+			hrp = "tb"
+		}
+		if v, p, err := DecodeSegwit(hrp, c.addr); err == nil {
+			t.Errorf("%v (%v): decoded v%v %x", c.addr, c.reason, v, p)
+		}
+	}
+}
+
+func TestBase58(t *testing.T) {
+	cases := []struct{ hex, enc string }{
+		{"", ""},
+		{"61", "2g"},
+		{"626262", "a3gV"},
+		{"636363", "aPEr"},
+		{"73696d706c792061206c6f6e6720737472696e67", "2cFupjhnEsSn59qHXstmK2ffpLv2"},
+		{"00eb15231dfceb60925886b67d065299925915aeb172c06647", "1NS17iag9jJgTHD1VXjvLCEnZuQ3rJDE9L"},
+		{"516b6fcd0f", "ABnLTmg"},
+		{"bf4f89001e670274dd", "3SEo3LWLoPntC"},
+		{"572e4794", "3EFU7m"},
+		{"ecac89cad93923c02321", "EJDM8drfXA6uyA"},
+		{"10c8511e", "Rt5zm"},
+		{"00000000000000000000", "1111111111"},
+	}
+	for _, c := range cases {
+		b, _ := hex.DecodeString(c.hex)
+		if enc := EncodeBase58(b); enc != c.enc {
+			t.Errorf("encode %v: %v", c.hex, enc)
+		}
+		if dec, err := DecodeBase58(c.enc); err != nil || hex.EncodeToString(dec) != c.hex {
+			t.Errorf("decode %v: %x, %v", c.enc, dec, err)
+		}
+	}
+	for _, s := range []string{"0", "O", "I", "l", "3SEo3LWLoPnt0"} {
+		if _, err := DecodeBase58(s); err != ErrBadBase58 {
+			t.Errorf("%v: %v", s, err)
+		}
+	}
+}
+
+func TestBase58CheckChecksum(t *testing.T) {
+	const addr = "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"
+	version, payload, err := DecodeBase58Check(addr)
+	if err != nil || version != 0 || hex.EncodeToString(payload) != "77bff20c60e522dfaa3350c39b030a5d004e839a" {
+		t.Fatalf("%v %x %v", version, payload, err)
+	}
+
+	//Every single character change breaks the checksum
+	for i := range addr {
+		b := []byte(addr)
+		if b[i] == 'z' {
+			b[i] = 'y'
+		} else {
+			b[i] = 'z'
+		}
+		if _, _, err := DecodeBase58Check(string(b)); err != ErrBadChecksum {
+			t.Errorf("%v: %v", string(b), err)
+		}
+		if _, err := ToScript(string(b), nil); err == nil {
+			t.Errorf("%v: built a script", string(b))
+		}
+	}
+	//Too short to hold a checksum
+	for _, s := range []string{"", "1", "1111"} {
+		if _, _, err := DecodeBase58Check(s); err != ErrBadChecksum {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+}
+
+//Every network's address forms round trip, and are rejected by the other networks
+func TestNetworkRoundTrip(t *testing.T) {
+	hash20 := strings.Repeat("ab", 20)
+	hash32 := strings.Repeat("cd", 32)
+	scripts := []string{
+		"76a914" + hash20 + "88ac",
+		"a914" + hash20 + "87",
+		"0014" + hash20,
+		"0020" + hash32,
+		"5120" + hash32,
+	}
+	prefixes := map[*network.Network][]string{
+		network.MainNet:  {"1", "3", "bc1q", "bc1q", "bc1p"},
+		network.TestNet3: {"m", "2", "tb1q", "tb1q", "tb1p"},
+		network.RegTest:  {"m", "2", "bcrt1q", "bcrt1q", "bcrt1p"},
+	}
+	for net, prefix := range prefixes {
+		for n, h := range scripts {
+			s, _ := hex.DecodeString(h)
+			addr, err := FromScript(s, net)
+			if err != nil || !strings.HasPrefix(addr, prefix[n]) {
+				t.Errorf("%v %v: %v, %v", net.Name, h, addr, err)
+				continue
+			}
+			back, err := ToScript(addr, net)
+			if err != nil || hex.EncodeToString(back) != h {
+				t.Errorf("%v %v: %x, %v", net.Name, addr, back, err)
+			}
+			if net != network.MainNet {
+				if _, err := ToScript(addr, network.MainNet); err == nil {
+					t.Errorf("mainnet accepted %v", addr)
+				}
+			} else if _, err := ToScript(addr, network.TestNet3); err == nil {
+				t.Errorf("testnet accepted %v", addr)
+			}
+		}
+	}
+}
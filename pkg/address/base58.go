@@ -0,0 +1,114 @@
+package address
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/lirancohen/blockparser/pkg/hashes"
+)
+
+var ErrBadChecksum = errors.New("address checksum mismatch")
+var ErrBadBase58 = errors.New("invalid base58 character")
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var base58Index = func() [256]int {
+	var idx [256]int
+	for i := range idx {
+		idx[i] = -1
+	}
+	for i := 0; i < len(base58Alphabet); i++ {
+		idx[base58Alphabet[i]] = i
+	}
+	return idx
+}()
+
+func EncodeBase58(b []byte) string {
+	zeros := 0
+	for zeros < len(b) && b[zeros] == 0 {
+		zeros++
+	}
+
+	//Base 256 to base 58, digits stored little endian
+	digits := make([]byte, 0, len(b)*138/100+1)
+	for _, c := range b[zeros:] {
+		carry := int(c)
+		for i := range digits {
+			carry += int(digits[i]) << 8
+			digits[i] = byte(carry % 58)
+			carry /= 58
+		}
+		for carry > 0 {
+			digits = append(digits, byte(carry%58))
+			carry /= 58
+		}
+	}
+
+	out := make([]byte, zeros, zeros+len(digits))
+	for i := range out {
+		out[i] = base58Alphabet[0]
+	}
+	for i := len(digits) - 1; i >= 0; i-- {
+		out = append(out, base58Alphabet[digits[i]])
+	}
+	return string(out)
+}
+
+func DecodeBase58(s string) ([]byte, error) {
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+
+	//Base 58 to base 256, bytes stored little endian
+	var b []byte
+	for i := zeros; i < len(s); i++ {
+		carry := base58Index[s[i]]
+		if carry < 0 {
+			return nil, ErrBadBase58
+		}
+		for j := range b {
+			carry += int(b[j]) * 58
+			b[j] = byte(carry)
+			carry >>= 8
+		}
+		for carry > 0 {
+			b = append(b, byte(carry))
+			carry >>= 8
+		}
+	}
+
+	out := make([]byte, zeros, zeros+len(b))
+	for i := len(b) - 1; i >= 0; i-- {
+		out = append(out, b[i])
+	}
+	return out, nil
+}
+
+//Version byte, payload and the first four bytes of its double SHA256
+func EncodeBase58Check(version byte, payload []byte) string {
+	b := make([]byte, 0, 1+len(payload)+4)
+	b = append(b, version)
+	b = append(b, payload...)
+	return EncodeBase58(append(b, checksum(b)...))
+}
+
+//Returns the version byte and payload
+func DecodeBase58Check(s string) (byte, []byte, error) {
+	b, err := DecodeBase58(s)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(b) < 5 {
+		return 0, nil, ErrBadChecksum
+	}
+	data, sum := b[:len(b)-4], b[len(b)-4:]
+	if !bytes.Equal(checksum(data), sum) {
+		return 0, nil, ErrBadChecksum
+	}
+	return data[0], data[1:], nil
+}
+
+func checksum(b []byte) []byte {
+	return hashes.Hash256(b)[:4]
+}
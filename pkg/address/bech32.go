@@ -0,0 +1,182 @@
+package address
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrBadBech32 = errors.New("invalid bech32 string")
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+//BIP173 and BIP350 checksum constants
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+func polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func hrpExpand(hrp string) []byte {
+	b := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		b = append(b, hrp[i]>>5)
+	}
+	b = append(b, 0)
+	for i := 0; i < len(hrp); i++ {
+		b = append(b, hrp[i]&31)
+	}
+	return b
+}
+
+func bech32Checksum(hrp string, data []byte, constant uint32) []byte {
+	values := append(hrpExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	mod := polymod(values) ^ constant
+	sum := make([]byte, 6)
+	for i := range sum {
+		sum[i] = byte(mod>>uint(5*(5-i))) & 31
+	}
+	return sum
+}
+
+//Encodes 5-bit data with the bech32 (constant 1) or bech32m checksum
+func bech32Encode(hrp string, data []byte, constant uint32) string {
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, d := range append(data, bech32Checksum(hrp, data, constant)...) {
+		sb.WriteByte(bech32Charset[d])
+	}
+	return sb.String()
+}
+
+//Returns the hrp, the 5-bit data without checksum and the checksum constant it verified with
+func bech32Decode(s string) (string, []byte, uint32, error) {
+	if len(s) > 90 {
+		return "", nil, 0, ErrBadBech32
+	}
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, 0, fmt.Errorf("%w: mixed case", ErrBadBech32)
+	}
+	s = strings.ToLower(s)
+
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, 0, ErrBadBech32
+	}
+	hrp := s[:sep]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, 0, ErrBadBech32
+		}
+	}
+
+	data := make([]byte, 0, len(s)-sep-1)
+	for i := sep + 1; i < len(s); i++ {
+		d := strings.IndexByte(bech32Charset, s[i])
+		if d < 0 {
+			return "", nil, 0, fmt.Errorf("%w: character %q", ErrBadBech32, s[i])
+		}
+		data = append(data, byte(d))
+	}
+
+	constant := polymod(append(hrpExpand(hrp), data...))
+	if constant != bech32Const && constant != bech32mConst {
+		return "", nil, 0, ErrBadChecksum
+	}
+	return hrp, data[:len(data)-6], constant, nil
+}
+
+//Regroups bits, pad is only allowed when converting to the smaller group size
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var out []byte
+	acc := uint32(0)
+	bits := uint(0)
+	maxv := uint32(1)<<to - 1
+	for _, v := range data {
+		if uint32(v)>>from != 0 {
+			return nil, ErrBadBech32
+		}
+		acc = acc<<from | uint32(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, fmt.Errorf("%w: invalid padding", ErrBadBech32)
+	}
+	return out, nil
+}
+
+//Segwit address, bech32 for version 0 and bech32m for later versions
+func EncodeSegwit(hrp string, version int, program []byte) (string, error) {
+	if version < 0 || version > 16 {
+		return "", fmt.Errorf("invalid witness version %v", version)
+	}
+	if len(program) < 2 || len(program) > 40 {
+		return "", fmt.Errorf("invalid witness program length %v", len(program))
+	}
+	data, err := convertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	constant := uint32(bech32mConst)
+	if version == 0 {
+		constant = bech32Const
+	}
+	return bech32Encode(hrp, append([]byte{byte(version)}, data...), constant), nil
+}
+
+//Decodes a segwit address for the given hrp, returning its witness version and program
+func DecodeSegwit(hrp, addr string) (int, []byte, error) {
+	got, data, constant, err := bech32Decode(addr)
+	if err != nil {
+		return 0, nil, err
+	}
+	if got != hrp {
+		return 0, nil, fmt.Errorf("address is for %v, expected %v", got, hrp)
+	}
+	if len(data) < 1 {
+		return 0, nil, ErrBadBech32
+	}
+
+	version := int(data[0])
+	if version > 16 {
+		return 0, nil, fmt.Errorf("invalid witness version %v", version)
+	}
+	if (version == 0) != (constant == bech32Const) {
+		return 0, nil, fmt.Errorf("%w: wrong checksum variant for witness version %v", ErrBadChecksum, version)
+	}
+	program, err := convertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(program) < 2 || len(program) > 40 {
+		return 0, nil, fmt.Errorf("invalid witness program length %v", len(program))
+	}
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return 0, nil, fmt.Errorf("invalid witness v0 program length %v", len(program))
+	}
+	return version, program, nil
+}
@@ -0,0 +1,234 @@
+package chain
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+
+	"github.com/lirancohen/blockparser/pkg/hashes"
+	"github.com/lirancohen/blockparser/pkg/network"
+	"github.com/lirancohen/blockparser/pkg/parser"
+	"github.com/lirancohen/blockparser/pkg/source"
+)
+
+//Header known to the Builder
+type HeaderNode struct {
+	//Block and parent hash in internal (little endian) byte order
+	Hash     [32]byte
+	Prev     [32]byte
+	Bits     uint32
+	Location source.Location
+	//Set once the node is connected to the genesis block, -1 until then
+	Height int
+	//Cumulative work of the chain ending at this header
+	Work   *big.Int
+	parent *HeaderNode
+	seen   int
+}
+
+func (n *HeaderNode) HashString() string {
+	return hashes.String(n.Hash[:])
+}
+
+//Result of Build
+type Chain struct {
+	//Most-work chain, Main[i] is the block at height i
+	Main []*HeaderNode
+	//Blocks connected to genesis that lost to the most-work chain
+	Stale []*HeaderNode
+	//Blocks whose parent was never seen
+	Orphans []*HeaderNode
+	byHash  map[[32]byte]*HeaderNode
+}
+
+//Height of the block on the main chain, false for stale, orphan and unknown blocks
+func (c *Chain) Height(hash [32]byte) (int, bool) {
+	n, ok := c.byHash[hash]
+	if !ok || n.Height < 0 || n.Height >= len(c.Main) || c.Main[n.Height] != n {
+		return 0, false
+	}
+	return n.Height, true
+}
+
+//Tip of the main chain, nil for an empty chain
+func (c *Chain) Tip() *HeaderNode {
+	if len(c.Main) == 0 {
+		return nil
+	}
+	return c.Main[len(c.Main)-1]
+}
+
+//Indexes headers in any order and links them into a tree rooted at the genesis block
+type Builder struct {
+	net   *network.Network
+	nodes map[[32]byte]*HeaderNode
+	//Unconnected headers keyed by the parent hash they're waiting for
+	waiting map[[32]byte][]*HeaderNode
+	seen    int
+}
+
+func NewBuilder(net *network.Network) *Builder {
+	if net == nil {
+		net = network.MainNet
+	}
+	return &Builder{
+		net:     net,
+		nodes:   make(map[[32]byte]*HeaderNode),
+		waiting: make(map[[32]byte][]*HeaderNode),
+	}
+}
+
+//Indexes the header of b, duplicates of an already known block are ignored
+func (bl *Builder) Add(b *parser.Block, loc source.Location) {
+	n := &HeaderNode{
+		Bits:     b.TargetDifficultyVal(),
+		Location: loc,
+		Height:   -1,
+		seen:     bl.seen,
+	}
+	copy(n.Hash[:], b.Hash())
+	copy(n.Prev[:], b.PreviousHash[:])
+	if _, ok := bl.nodes[n.Hash]; ok {
+		return
+	}
+	bl.seen++
+	bl.nodes[n.Hash] = n
+
+	if n.Prev == ([32]byte{}) {
+		if n.HashString() != bl.net.GenesisHash {
+			//A block without a parent that isn't our genesis stays an orphan
+			return
+		}
+		bl.connect(n, nil)
+		return
+	}
+	if parent, ok := bl.nodes[n.Prev]; ok && parent.Height >= 0 {
+		bl.connect(n, parent)
+		return
+	}
+	bl.waiting[n.Prev] = append(bl.waiting[n.Prev], n)
+}
+
+//Connects n under parent and then everything that was waiting for n
+func (bl *Builder) connect(n, parent *HeaderNode) {
+	queue := []*HeaderNode{n}
+	n.parent = parent
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		cur.Work = CalcWork(cur.Bits)
+		cur.Height = 0
+		if cur.parent != nil {
+			cur.Work.Add(cur.Work, cur.parent.Work)
+			cur.Height = cur.parent.Height + 1
+		}
+
+		for _, child := range bl.waiting[cur.Hash] {
+			child.parent = cur
+			queue = append(queue, child)
+		}
+		delete(bl.waiting, cur.Hash)
+	}
+}
+
+//Selects the most-work chain, ties go to the block seen first like Bitcoin Core
+func (bl *Builder) Build() *Chain {
+	c := &Chain{byHash: bl.nodes}
+
+	var tip *HeaderNode
+	for _, n := range bl.nodes {
+		if n.Height < 0 {
+			continue
+		}
+		if tip == nil {
+			tip = n
+			continue
+		}
+		if cmp := n.Work.Cmp(tip.Work); cmp > 0 || (cmp == 0 && n.seen < tip.seen) {
+			tip = n
+		}
+	}
+
+	if tip != nil {
+		c.Main = make([]*HeaderNode, tip.Height+1)
+		for n := tip; n != nil; n = n.parent {
+			c.Main[n.Height] = n
+		}
+	}
+
+	for _, n := range bl.nodes {
+		if n.Height < 0 {
+			c.Orphans = append(c.Orphans, n)
+		} else if n.Height >= len(c.Main) || c.Main[n.Height] != n {
+			//Forks can outgrow the most-work chain on testnets
+			c.Stale = append(c.Stale, n)
+		}
+	}
+	sortBySeen(c.Stale)
+	sortBySeen(c.Orphans)
+	return c
+}
+
+//Indexes every block of src and builds the chain from it
+func BuildFromSource(src *source.BlkFileSource) (*Chain, error) {
+	bl := NewBuilder(src.Network)
+	for {
+		raw, err := src.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(raw.Data) < 88 {
+			return nil, fmt.Errorf("block at %v:%v is too short", raw.File, raw.Offset)
+		}
+
+		//Only the header is decoded, Decode stops once the transactions are missing
+		p := parser.NewBlockParser(bytes.NewReader(raw.Data[:88]), nil, src.Network)
+		b, err := p.Decode(0)
+		if err != nil {
+			return nil, err
+		}
+		bl.Add(b, raw.Location)
+	}
+	return bl.Build(), nil
+}
+
+//Reads the main chain blocks of c back out of src in height order,
+//so a ChainChunker fed from it assigns true heights
+func (c *Chain) Reader(src *source.BlkFileSource) io.Reader {
+	return &chainReader{chain: c, src: src}
+}
+
+type chainReader struct {
+	chain   *Chain
+	src     *source.BlkFileSource
+	height  int
+	pending []byte
+}
+
+func (r *chainReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		if r.height >= len(r.chain.Main) {
+			return 0, io.EOF
+		}
+		raw, err := r.src.ReadAt(r.chain.Main[r.height].Location)
+		if err != nil {
+			return 0, err
+		}
+		r.height++
+		r.pending = raw.Data
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func sortBySeen(nodes []*HeaderNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].seen < nodes[j].seen
+	})
+}
@@ -0,0 +1,65 @@
+package chain
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/parser"
+	"github.com/lirancohen/blockparser/pkg/source"
+)
+
+func genesisBlock(t *testing.T) *parser.Block {
+	t.Helper()
+	h, _ := hex.DecodeString("0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c")
+	b := &parser.Block{}
+	copy(b.VersionNumber[:], h[0:4])
+	copy(b.PreviousHash[:], h[4:36])
+	copy(b.MerkleRoot[:], h[36:68])
+	copy(b.TimeStamp[:], h[68:72])
+	copy(b.TargetDifficulty[:], h[72:76])
+	copy(b.Nonce[:], h[76:80])
+	return b
+}
+
+//Header on top of parent, nonce keeps siblings apart
+func child(parent *parser.Block, bits, nonce uint32) *parser.Block {
+	b := &parser.Block{}
+	binary.LittleEndian.PutUint32(b.VersionNumber[:], 1)
+	copy(b.PreviousHash[:], parent.Hash())
+	binary.LittleEndian.PutUint32(b.TargetDifficulty[:], bits)
+	binary.LittleEndian.PutUint32(b.Nonce[:], nonce)
+	return b
+}
+
+func TestBuildLongerLowerWorkBranch(t *testing.T) {
+	genesis := genesisBlock(t)
+	bl := NewBuilder(nil)
+	bl.Add(genesis, source.Location{})
+
+	//One block at the genesis difficulty outweighs three at minimum difficulty
+	heavy := child(genesis, 0x1d00ffff, 1)
+	bl.Add(heavy, source.Location{})
+	light := genesis
+	var branch []*parser.Block
+	for i := 0; i < 3; i++ {
+		light = child(light, 0x207fffff, uint32(100+i))
+		branch = append(branch, light)
+		bl.Add(light, source.Location{})
+	}
+
+	c := bl.Build()
+	if len(c.Main) != 2 || c.Tip().HashString() != heavy.HashString() {
+		t.Fatalf("main chain has %v blocks, tip %v", len(c.Main), c.Tip().HashString())
+	}
+	if len(c.Stale) != 3 || len(c.Orphans) != 0 {
+		t.Fatalf("%v stale, %v orphans", len(c.Stale), len(c.Orphans))
+	}
+	for _, b := range branch {
+		var hash [32]byte
+		copy(hash[:], b.Hash())
+		if _, ok := c.Height(hash); ok {
+			t.Errorf("stale block %v reported on the main chain", b.HashString())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package chain
+
+import (
+	"math/big"
+
+	"github.com/lirancohen/blockparser/pkg/network"
+	"github.com/lirancohen/blockparser/pkg/parser"
+)
+
+var bigOne = big.NewInt(1)
+
+//Expands the compact nBits representation into the full 256-bit target
+func CompactToBig(bits uint32) *big.Int {
+	mantissa := int64(bits & 0x007fffff)
+	negative := bits&0x00800000 != 0
+	exponent := uint(bits >> 24)
+
+	var n *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		n = big.NewInt(mantissa)
+	} else {
+		n = big.NewInt(mantissa)
+		n.Lsh(n, 8*(exponent-3))
+	}
+	if negative {
+		n.Neg(n)
+	}
+	return n
+}
+
+//Compresses a target back into nBits, rounding the mantissa down like Bitcoin Core
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(new(big.Int).Abs(n).Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		t := new(big.Int).Abs(n)
+		mantissa = uint32(t.Rsh(t, 8*(exponent-3)).Bits()[0])
+	}
+
+	//The sign bit is part of the mantissa, so shift it out of the way
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	bits := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		bits |= 0x00800000
+	}
+	return bits
+}
+
+//Expected number of hashes needed to find a block at the given nBits
+func CalcWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	//2^256 / (target+1)
+	denominator := new(big.Int).Add(target, bigOne)
+	return new(big.Int).Div(new(big.Int).Lsh(bigOne, 256), denominator)
+}
+
+//Block hash as a number, the header hash is stored little endian
+func HashToBig(hash []byte) *big.Int {
+	be := make([]byte, len(hash))
+	for i := range hash {
+		be[len(hash)-1-i] = hash[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+//Checks that nBits is a valid target below the proof of work limit and that the block hash meets it
+func CheckProofOfWork(b *parser.Block, powLimit *big.Int) error {
+	target := CompactToBig(b.TargetDifficultyVal())
+	if target.Sign() <= 0 || target.Cmp(powLimit) > 0 {
+		return ErrBadTarget
+	}
+	if HashToBig(b.Hash()).Cmp(target) > 0 {
+		return ErrBadProofOfWork
+	}
+	return nil
+}
+
+//nBits required for the first block of a new retarget period.
+//first and last are the timestamps of the first and last block of the period that just ended,
+//bits is the nBits the new target is scaled from.
+func CalcNextRequiredBits(net *network.Network, bits, first, last uint32) uint32 {
+	if net.NoRetargeting {
+		return bits
+	}
+
+	timespan := int64(last) - int64(first)
+	if timespan < net.TargetTimespan/4 {
+		timespan = net.TargetTimespan / 4
+	} else if timespan > net.TargetTimespan*4 {
+		timespan = net.TargetTimespan * 4
+	}
+
+	powLimit := CompactToBig(net.PowLimitBits)
+	target := CompactToBig(bits)
+	target.Mul(target, big.NewInt(timespan))
+	target.Div(target, big.NewInt(net.TargetTimespan))
+	if target.Cmp(powLimit) > 0 {
+		target.Set(powLimit)
+	}
+	return BigToCompact(target)
+}
@@ -0,0 +1,95 @@
+package chain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/network"
+)
+
+//Bitcoin Core's arith_uint256 SetCompact/GetCompact cases
+func TestCompactRoundTrip(t *testing.T) {
+	cases := []struct {
+		bits    uint32
+		target  int64
+		compact uint32
+	}{
+		{0x00000000, 0, 0},
+		{0x00123456, 0, 0},
+		{0x01003456, 0, 0},
+		{0x02000056, 0, 0},
+		{0x03000000, 0, 0},
+		{0x04000000, 0, 0},
+		//Sign bit set on a zero mantissa is still zero
+		{0x00923456, 0, 0},
+		{0x01803456, 0, 0},
+		{0x02800056, 0, 0},
+		{0x03800000, 0, 0},
+		{0x04800000, 0, 0},
+		{0x01123456, 0x12, 0x01120000},
+		{0x01fedcba, -0x7e, 0x01fe0000},
+		{0x02123456, 0x1234, 0x02123400},
+		{0x03123456, 0x123456, 0x03123456},
+		{0x04123456, 0x12345600, 0x04123456},
+		{0x04923456, -0x12345600, 0x04923456},
+		//A mantissa with its top bit set moves up a byte so it isn't read as the sign
+		{0x05009234, 0x92340000, 0x05009234},
+	}
+	for _, c := range cases {
+		n := CompactToBig(c.bits)
+		if n.Cmp(big.NewInt(c.target)) != 0 {
+			t.Errorf("CompactToBig(%08x) = %x, want %x", c.bits, n, c.target)
+		}
+		if got := BigToCompact(n); got != c.compact {
+			t.Errorf("BigToCompact(%x) = %08x, want %08x", n, got, c.compact)
+		}
+	}
+
+	big32 := CompactToBig(0x20123456)
+	want, _ := new(big.Int).SetString("1234560000000000000000000000000000000000000000000000000000000000", 16)
+	if big32.Cmp(want) != 0 || BigToCompact(big32) != 0x20123456 {
+		t.Errorf("0x20123456: %x", big32)
+	}
+}
+
+//Targets that overflow 256 bits or go negative are never valid
+func TestCheckProofOfWorkBadTarget(t *testing.T) {
+	powLimit := CompactToBig(network.MainNet.PowLimitBits)
+	for _, bits := range []uint32{0xff123456, 0x21010000, 0x04923456, 0x01003456, 0x1d01ffff} {
+		b := genesisBlock(t)
+		b.TargetDifficulty = [4]byte{byte(bits), byte(bits >> 8), byte(bits >> 16), byte(bits >> 24)}
+		if err := CheckProofOfWork(b, powLimit); err != ErrBadTarget {
+			t.Errorf("%08x: %v", bits, err)
+		}
+	}
+	if err := CheckProofOfWork(genesisBlock(t), powLimit); err != nil {
+		t.Errorf("genesis: %v", err)
+	}
+}
+
+//Mainnet retargets from Bitcoin Core's pow_tests
+func TestCalcNextRequiredBits(t *testing.T) {
+	cases := []struct {
+		name        string
+		bits        uint32
+		first, last uint32
+		want        uint32
+	}{
+		//Block 32256, the first retarget that changed the difficulty
+		{"retarget", 0x1d00ffff, 1261130161, 1262152739, 0x1d00d86a},
+		//Slow period at the limit stays at the limit
+		{"pow limit", 0x1d00ffff, 1231006505, 1233061996, 0x1d00ffff},
+		//Fast period clamped to a quarter of the timespan
+		{"lower limit", 0x1c05a3f4, 1279008237, 1279297671, 0x1c0168fd},
+		//Slow period clamped to four times the timespan
+		{"upper limit", 0x1c387f6f, 1263163443, 1269211443, 0x1d00e1fd},
+	}
+	for _, c := range cases {
+		if got := CalcNextRequiredBits(network.MainNet, c.bits, c.first, c.last); got != c.want {
+			t.Errorf("%v: %08x, want %08x", c.name, got, c.want)
+		}
+	}
+	if got := CalcNextRequiredBits(network.RegTest, 0x207fffff, 0, 1); got != 0x207fffff {
+		t.Errorf("regtest retargeted to %08x", got)
+	}
+}
@@ -0,0 +1,142 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/lirancohen/blockparser/pkg/network"
+	"github.com/lirancohen/blockparser/pkg/parser"
+)
+
+var ErrBadTarget = errors.New("target difficulty out of range")
+var ErrBadProofOfWork = errors.New("block hash above target")
+var ErrBadPrevHash = errors.New("previous hash does not link to the previous block")
+var ErrBadDifficulty = errors.New("unexpected target difficulty")
+
+//Error for the first block that failed validation
+type ValidationError struct {
+	Height int
+	Hash   string
+	Err    error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("block %v (%v): %v", e.Height, e.Hash, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+//Validates headers one after the other in height order
+type HeaderValidator struct {
+	net      *network.Network
+	powLimit *big.Int
+	started  bool
+	prevHash []byte
+	prevBits uint32
+	prevTime uint32
+	//Timestamp and nBits of the first block of the current retarget period
+	periodStart     uint32
+	periodStartBits uint32
+	hasPeriodStart  bool
+	//nBits of the last block that wasn't mined at minimum difficulty, testnets only
+	lastNormalBits uint32
+}
+
+func NewHeaderValidator(net *network.Network) *HeaderValidator {
+	if net == nil {
+		net = network.MainNet
+	}
+	return &HeaderValidator{
+		net:      net,
+		powLimit: CompactToBig(net.PowLimitBits),
+	}
+}
+
+//Checks proof of work, linkage to the previously checked block and the retarget rule.
+//The difficulty of the first period boundary can only be checked once a whole period has been seen.
+func (v *HeaderValidator) Check(b *parser.Block) error {
+	if err := CheckProofOfWork(b, v.powLimit); err != nil {
+		return v.fail(b, err)
+	}
+
+	bits := b.TargetDifficultyVal()
+	if v.started {
+		if !bytes.Equal(b.PreviousHash[:], v.prevHash) {
+			return v.fail(b, ErrBadPrevHash)
+		}
+		if !v.checkDifficulty(b) {
+			return v.fail(b, ErrBadDifficulty)
+		}
+	}
+
+	if b.Height%v.net.RetargetInterval == 0 {
+		v.periodStart = b.TimeStampVal()
+		v.periodStartBits = bits
+		v.hasPeriodStart = true
+	}
+	if !v.net.ReduceMinDifficulty || bits != v.net.PowLimitBits || b.Height%v.net.RetargetInterval == 0 {
+		v.lastNormalBits = bits
+	}
+	v.started = true
+	v.prevHash = b.Hash()
+	v.prevBits = bits
+	v.prevTime = b.TimeStampVal()
+	return nil
+}
+
+func (v *HeaderValidator) checkDifficulty(b *parser.Block) bool {
+	bits := b.TargetDifficultyVal()
+	if b.Height%v.net.RetargetInterval == 0 {
+		if !v.hasPeriodStart {
+			return true
+		}
+		base := v.prevBits
+		if v.net.EnforceBIP94 {
+			base = v.periodStartBits
+		}
+		return bits == CalcNextRequiredBits(v.net, base, v.periodStart, v.prevTime)
+	}
+
+	if v.net.ReduceMinDifficulty {
+		//A block more than twice the target spacing after its parent must use the minimum difficulty
+		if int64(b.TimeStampVal()) > int64(v.prevTime)+2*v.net.TargetSpacing {
+			return bits == v.net.PowLimitBits
+		}
+		return bits == v.lastNormalBits
+	}
+	return bits == v.prevBits
+}
+
+func (v *HeaderValidator) fail(b *parser.Block, err error) error {
+	return &ValidationError{Height: b.Height, Hash: b.HashString(), Err: err}
+}
+
+//Validates every block from s onwards, following the chunk sequence with Next.
+//Returns the number of valid blocks and the first ValidationError.
+func ValidateStream(s *parser.Stream) (int, error) {
+	v := NewHeaderValidator(s.Network)
+	n := 0
+	for {
+		b, err := s.ReadBlock()
+		if err == io.EOF {
+			if s, err = s.Next(); err == parser.ErrEOF {
+				return n, nil
+			} else if err != nil {
+				return n, err
+			}
+			continue
+		} else if err != nil {
+			return n, err
+		}
+
+		if err := v.Check(b); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
@@ -0,0 +1,137 @@
+package chain
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/network"
+	"github.com/lirancohen/blockparser/pkg/parser"
+)
+
+const testNormalBits = 0x2000ffff
+
+//Testnet rules with short periods and a minimum difficulty easy enough to mine in a test
+func testNet(bip94 bool) *network.Network {
+	n := *network.TestNet3
+	n.PowLimitBits = 0x207fffff
+	n.RetargetInterval = 4
+	n.EnforceBIP94 = bip94
+	return &n
+}
+
+//Mines a block on top of parent, nil parent starts the chain at height 0
+func mine(t *testing.T, parent *parser.Block, bits, time uint32) *parser.Block {
+	t.Helper()
+	b := &parser.Block{}
+	binary.LittleEndian.PutUint32(b.VersionNumber[:], 1)
+	if parent != nil {
+		copy(b.PreviousHash[:], parent.Hash())
+		b.Height = parent.Height + 1
+	}
+	binary.LittleEndian.PutUint32(b.TimeStamp[:], time)
+	binary.LittleEndian.PutUint32(b.TargetDifficulty[:], bits)
+	limit := CompactToBig(0x207fffff)
+	for nonce := uint32(0); ; nonce++ {
+		binary.LittleEndian.PutUint32(b.Nonce[:], nonce)
+		if CheckProofOfWork(b, limit) == nil {
+			return b
+		}
+	}
+}
+
+//Validates chain and returns the validator positioned after it
+func validated(t *testing.T, net *network.Network, chain ...*parser.Block) *HeaderValidator {
+	t.Helper()
+	v := NewHeaderValidator(net)
+	for _, b := range chain {
+		if err := v.Check(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return v
+}
+
+func TestMinDifficultyGap(t *testing.T) {
+	net := testNet(false)
+	const start = 1700000000
+	b0 := mine(t, nil, testNormalBits, start)
+	b1 := mine(t, b0, testNormalBits, start+600)
+
+	gap := uint32(start + 600 + 2*600 + 1)
+	cases := []struct {
+		name string
+		bits uint32
+		time uint32
+		err  error
+	}{
+		{"gap at minimum difficulty", net.PowLimitBits, gap, nil},
+		{"gap at normal difficulty", testNormalBits, gap, ErrBadDifficulty},
+		{"no gap at normal difficulty", testNormalBits, start + 1200, nil},
+		{"no gap at minimum difficulty", net.PowLimitBits, start + 1200, ErrBadDifficulty},
+	}
+	for _, c := range cases {
+		v := validated(t, net, b0, b1)
+		if err := v.Check(mine(t, b1, c.bits, c.time)); !errors.Is(err, c.err) {
+			t.Errorf("%v: %v", c.name, err)
+		}
+	}
+
+	//After a minimum difficulty block the next one goes back to the last normal difficulty
+	b2 := mine(t, b1, net.PowLimitBits, gap)
+	v := validated(t, net, b0, b1, b2)
+	if err := v.Check(mine(t, b2, testNormalBits, gap+600)); err != nil {
+		t.Errorf("back to normal: %v", err)
+	}
+	v = validated(t, net, b0, b1, b2)
+	if err := v.Check(mine(t, b2, net.PowLimitBits, gap+600)); !errors.Is(err, ErrBadDifficulty) {
+		t.Errorf("minimum difficulty without a gap: %v", err)
+	}
+}
+
+//Mainnet rules never allow minimum difficulty blocks, whatever the gap
+func TestNoMinDifficultyOnMainNet(t *testing.T) {
+	net := testNet(false)
+	net.ReduceMinDifficulty = false
+	b0 := mine(t, nil, testNormalBits, 1700000000)
+	v := validated(t, net, b0)
+	if err := v.Check(mine(t, b0, net.PowLimitBits, 1700000000+3600)); !errors.Is(err, ErrBadDifficulty) {
+		t.Errorf("minimum difficulty: %v", err)
+	}
+	v = validated(t, net, b0)
+	if err := v.Check(mine(t, b0, testNormalBits, 1700000000+3600)); err != nil {
+		t.Errorf("previous difficulty: %v", err)
+	}
+}
+
+//A period ending in a minimum difficulty block retargets from the minimum difficulty on testnet3,
+//BIP94 retargets from the first block of the period instead
+func TestRetargetAfterMinDifficulty(t *testing.T) {
+	const start = 1700000000
+	for _, bip94 := range []bool{false, true} {
+		net := testNet(bip94)
+		b0 := mine(t, nil, testNormalBits, start)
+		b1 := mine(t, b0, testNormalBits, start+600)
+		b2 := mine(t, b1, testNormalBits, start+1200)
+		b3 := mine(t, b2, net.PowLimitBits, start+1200+1201)
+
+		base, other := uint32(net.PowLimitBits), uint32(testNormalBits)
+		if bip94 {
+			base, other = other, base
+		}
+		want := CalcNextRequiredBits(net, base, b0.TimeStampVal(), b3.TimeStampVal())
+		wrong := CalcNextRequiredBits(net, other, b0.TimeStampVal(), b3.TimeStampVal())
+		if want == wrong {
+			t.Fatalf("bip94 %v: both bases retarget to %08x", bip94, want)
+		}
+
+		v := validated(t, net, b0, b1, b2, b3)
+		if err := v.Check(mine(t, b3, want, b3.TimeStampVal()+600)); err != nil {
+			t.Errorf("bip94 %v: %v", bip94, err)
+		}
+		v = validated(t, net, b0, b1, b2, b3)
+		if err := v.Check(mine(t, b3, wrong, b3.TimeStampVal()+600)); !errors.Is(err, ErrBadDifficulty) {
+			t.Errorf("bip94 %v: retarget from the wrong base: %v", bip94, err)
+		}
+	}
+}
@@ -2,13 +2,18 @@ package chunker
 
 import (
 	"bufio"
+	"bytes"
+	"path/filepath"
 	"io"
 	"fmt"
 	"os"
 	"log"
 	"sync"
 
-	"github.com/lirancohen/blockparser/pkg/utils"
+	"github.com/lirancohen/blockparser/pkg/index"
+	"github.com/lirancohen/blockparser/pkg/hashes"
+	"github.com/lirancohen/blockparser/pkg/network"
+	"github.com/lirancohen/blockparser/pkg/parser"
 )
 
 const (
@@ -19,12 +24,28 @@ const (
 type ChainChunker struct {
 	*bufio.Reader
 	File *bufio.Writer
+	Network *network.Network
+	//Block locations recorded while chunking, saved to index.DefaultBlockIndexPath
+	Index *index.BlockIndex
+	//Transaction locations recorded while chunking, saved to index.DefaultTxIndexPath.
+	//nil unless set before Chunk, the index is held in memory until then, around 100GB for mainnet.
+	//Set TxIndex.Witness to index wtxids as well
+	TxIndex *index.TxIndex
+	//Spent outpoints recorded while chunking, saved to index.DefaultSpendIndexPath.
+	//nil unless set before Chunk, like TxIndex it's held in memory until then
+	SpendIndex *index.SpendIndex
 	wg sync.WaitGroup
 }
 
-func New(r io.Reader) *ChainChunker {
+//net is the network the blocks belong to, mainnet when nil
+func New(r io.Reader, net *network.Network) *ChainChunker {
+	if net == nil {
+		net = network.MainNet
+	}
 	return &ChainChunker{
 		Reader: bufio.NewReader(r),
+		Network: net,
+		Index: index.NewBlockIndex(),
 	}
 }
 
@@ -32,7 +53,10 @@ func (c *ChainChunker) Chunk() (int,error) {
 
 	//Open BlockChain File and load it into a buffer
 	var path string
-	reader := c.Reader
+	var out *os.File
+	framer := parser.NewFramer(c.Reader, c.Network)
+	//Bytes written to the current chunk, used for the block index
+	var offset int64
 	blocks := 0
 	written := 0
 	chunkLength := 0
@@ -46,81 +70,59 @@ func (c *ChainChunker) Chunk() (int,error) {
 			chunkStart = blocks
 
 			bucket := fmt.Sprintf("%v_%v", chunkStart, (chunkStart + chunkLength))
+			if err := c.closeChunk(out); err != nil {
+				return written, err
+			}
 			var err error
 			path = fmt.Sprintf("./data/chunks/%v.dat",bucket)
-			w, err := os.Create(path)
-			c.File = bufio.NewWriter(w)
+			out, err = os.Create(path)
 			if err != nil {
 				return written, err
 			}
+			c.File = bufio.NewWriter(out)
+			offset = 0
 		}
 
-		//Temporary holder for what might be the MagicID
-		var m []byte
-		if b, err := reader.ReadByte(); err != nil {
-			log.Printf("Reader Error On Block: %v\n", blocks)
-			log.Printf("Error: %v\n", err)
-			break
-		} else {
-			//Check to make sure the first byte in the magic ID chunk is 0xF9
-			//Remember it's encoded in Little Endian so the first byte will end up being the last when encoded
-			if uint8(b) == 249 {
-				if err := c.File.WriteByte(b); err != nil {
-					log.Printf("Error at byte: %v", b)
-				}
-				m = append(m, b)
-				for i := 0; i < 3; i++ {
-					if b, err := reader.ReadByte(); err != nil {
-						log.Printf("Reader Error inside MagicID On Block: %v\n", blocks)
-						log.Printf("Error: %v\n", err)
-						break
-					} else {
-						if err := c.File.WriteByte(b); err != nil {
-							log.Printf("Error at byte: %v", b)
-						}
-						m = append(m, b)
-					}
-				}
-			} else {
-				log.Printf("Invalid Byte: %v\n", b)
-				continue
+		block, err := framer.Next()
+		if err == parser.ErrFrameSize {
+			log.Printf("Invalid block length On Block: %v\n", blocks)
+			continue
+		} else if err != nil {
+			if err != io.EOF {
+				log.Printf("Reader Error On Block: %v\n", blocks)
+				log.Printf("Error: %v\n", err)
 			}
+			break
 		}
-		//Check If the MagicID == 0xD9B4BEF9
-		//If so, this is the beginning of a BitCoin BlockChain Block
-		if utils.ParseLEUint32(m) == 3652501241 {
-			var stmp []byte
-			for i := 0; i < 4; i++ {
-				b, err := reader.ReadByte()
-				if err != nil {
-					break
-				}
-				if err := c.File.WriteByte(b); err != nil {
-					log.Printf("Error at byte: %v", b)
-				}
-				stmp = append(stmp, b)
-			}
-
-			//Parse Size of Block and retrieve it
-			size := utils.ParseLEUint32(stmp)
-
-			for i := 0; i < (int(size)); i++ {
-				b, err := reader.ReadByte()
-				if err != nil {
-					break
-				}
-				if err := c.File.WriteByte(b); err != nil {
-					log.Printf("Error at byte: %v", b)
-				}
-			}
 
-			blocks++
-			//write block to current chunk
+		//write block to current chunk
+		blockOffset := offset
+		if _, err := c.File.Write(block); err != nil {
+			parser.ReleaseFrame(block)
+			return written, err
+		}
+		offset += int64(len(block))
 
-			written++
-		} else {
-			log.Printf("Invalid MagicID Looking for next block")
+		loc := index.BlockLocation{
+			Height: blocks,
+			File: filepath.Base(path),
+			Offset: blockOffset,
+			Length: uint32(len(block)),
+		}
+		//The block hash is taken from the 80 byte header
+		if len(block) >= 88 {
+			copy(loc.Hash[:], hashes.Hash256(block[8:88]))
 		}
+		c.Index.Add(loc)
+		if c.TxIndex != nil || c.SpendIndex != nil {
+			if err := c.indexTransactions(blocks, block); err != nil {
+				log.Printf("Transaction Index Error On Block: %v: %v\n", blocks, err)
+			}
+		}
+		parser.ReleaseFrame(block)
+
+		blocks++
+		written++
 	}
 
 	var pathRenamed string
@@ -130,6 +132,76 @@ func (c *ChainChunker) Chunk() (int,error) {
 		pathRenamed = fmt.Sprintf("%v.%v.%v",path, blocks,"current" )
 	}
 
+	if err := c.closeChunk(out); err != nil {
+		return written, err
+	}
 	os.Rename(path,pathRenamed)
+
+	c.Index.RenameFile(filepath.Base(path), filepath.Base(pathRenamed))
+	if err := c.Index.Save(index.DefaultBlockIndexPath); err != nil {
+		return written, err
+	}
+	if c.TxIndex != nil {
+		if err := c.TxIndex.Save(index.DefaultTxIndexPath); err != nil {
+			return written, err
+		}
+	}
+	if c.SpendIndex != nil {
+		if err := c.SpendIndex.Save(index.DefaultSpendIndexPath); err != nil {
+			return written, err
+		}
+	}
 	return written, nil
 }
+
+//Decodes the block and records where each of its transactions starts and what its inputs spend
+func (c *ChainChunker) indexTransactions(height int, block []byte) error {
+	b, err := parser.NewBlockParser(bytes.NewReader(block), nil, c.Network).Decode(height)
+	if err != nil {
+		return err
+	}
+	if len(b.Transactions) != b.TransactionCountVal() {
+		return fmt.Errorf("decoded %v of %v transactions", len(b.Transactions), b.TransactionCountVal())
+	}
+
+	//MagicID, length, header and transaction count come first
+	offset := 8 + 80 + len(b.TransactionCount)
+	for i := range b.Transactions {
+		t := &b.Transactions[i]
+		length := len(t.Serialize())
+		var txid [32]byte
+		copy(txid[:], t.Hash())
+		if c.TxIndex != nil {
+			var wtxid [32]byte
+			if c.TxIndex.Witness {
+				copy(wtxid[:], t.WitnessHash())
+			}
+			c.TxIndex.Add(txid, wtxid, index.TxLocation{
+				Height: height,
+				Position: i,
+				Offset: uint32(offset),
+				Length: uint32(length),
+			})
+		}
+		for n := range t.Inputs {
+			o := t.Inputs[n].OutPoint()
+			if o.IsNull() || c.SpendIndex == nil {
+				continue
+			}
+			c.SpendIndex.Add(o, index.SpendRecord{Txid: txid, Input: uint32(n), Height: height})
+		}
+		offset += length
+	}
+	return nil
+}
+
+//Flushes whatever is still buffered for the chunk and closes it
+func (c *ChainChunker) closeChunk(f *os.File) error {
+	if f == nil {
+		return nil
+	}
+	if err := c.File.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,67 @@
+package hashes
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+func Sha1(data []byte) []byte {
+	h := sha1.Sum(data)
+	return h[:]
+}
+
+func Sha256(data []byte) []byte {
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+//SHA256 applied twice, used for txids, block hashes and OP_HASH256
+func Hash256(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+//RIPEMD160 of the SHA256, used for pubkey and script hashes
+func Hash160(data []byte) []byte {
+	return Ripemd160(Sha256(data))
+}
+
+//Display form of a hash: the bytes reversed and hex encoded, the way txids and block hashes are shown
+func String(h []byte) string {
+	r := make([]byte, len(h))
+	for i := range h {
+		r[len(h)-1-i] = h[i]
+	}
+	return hex.EncodeToString(r)
+}
+
+//Converts a 32 byte hash in display hex back to the internal (little endian) byte order
+func FromString(s string) ([32]byte, error) {
+	var hash [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return hash, err
+	}
+	if len(b) != len(hash) {
+		return hash, fmt.Errorf("hash must be %v bytes: %v", len(hash), s)
+	}
+	for i := range b {
+		hash[len(b)-1-i] = b[i]
+	}
+	return hash, nil
+}
+
+//BIP340 tagged hash: SHA256(SHA256(tag) || SHA256(tag) || msg)
+func TaggedHash(tag string, msg ...[]byte) []byte {
+	t := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(t[:])
+	h.Write(t[:])
+	for _, m := range msg {
+		h.Write(m)
+	}
+	return h.Sum(nil)
+}
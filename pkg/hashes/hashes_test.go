@@ -0,0 +1,26 @@
+package hashes
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestHashStringRoundTrip(t *testing.T) {
+	//Genesis block header
+	header, _ := hex.DecodeString("0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c")
+	want := "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+	h := Hash256(header)
+	if got := String(h); got != want {
+		t.Fatalf("String: %v", got)
+	}
+	back, err := FromString(want)
+	if err != nil || String(back[:]) != want || string(back[:]) != string(h) {
+		t.Fatalf("FromString: %x %v", back, err)
+	}
+	if _, err := FromString("abcd"); err == nil {
+		t.Error("short hash accepted")
+	}
+	if _, err := FromString(want[:62] + "zz"); err == nil {
+		t.Error("invalid hex accepted")
+	}
+}
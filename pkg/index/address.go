@@ -0,0 +1,208 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const DefaultAddressIndexPath = "./data/index/address.idx"
+
+var addressIndexMagic = [4]byte{'B', 'P', 'A', 'I'}
+
+const addressIndexVersion = 1
+
+//Output paying to a script, and the input that spent it if any
+type AddressEntry struct {
+	Txid   [32]byte
+	Vout   uint32
+	Height int
+	Value  uint64
+	Spent  bool
+	//Only meaningful when Spent is set
+	SpentBy SpendRecord
+}
+
+type entryRef struct {
+	script [32]byte
+	pos    int
+}
+
+//Maps the SHA256 of a scriptPubKey to every output paying to it, in chain order
+type AddressIndex struct {
+	byScript map[[32]byte][]AddressEntry
+	outputs  map[OutPoint]entryRef
+}
+
+func NewAddressIndex() *AddressIndex {
+	return &AddressIndex{
+		byScript: make(map[[32]byte][]AddressEntry),
+		outputs:  make(map[OutPoint]entryRef),
+	}
+}
+
+//Key the index uses for a scriptPubKey
+func ScriptHash(script []byte) [32]byte {
+	return sha256.Sum256(script)
+}
+
+func (i *AddressIndex) AddOutput(script []byte, e AddressEntry) {
+	key := ScriptHash(script)
+	i.outputs[OutPoint{Hash: e.Txid, Index: e.Vout}] = entryRef{script: key, pos: len(i.byScript[key])}
+	i.byScript[key] = append(i.byScript[key], e)
+}
+
+//Marks the output o as spent, false when o isn't in the index
+func (i *AddressIndex) Spend(o OutPoint, r SpendRecord) bool {
+	ref, ok := i.outputs[o]
+	if !ok {
+		return false
+	}
+	e := &i.byScript[ref.script][ref.pos]
+	e.Spent = true
+	e.SpentBy = r
+	return true
+}
+
+//Every output ever paid to script
+func (i *AddressIndex) History(script []byte) []AddressEntry {
+	return i.byScript[ScriptHash(script)]
+}
+
+//Sum of the unspent outputs paying to script
+func (i *AddressIndex) Balance(script []byte) uint64 {
+	var total uint64
+	for _, e := range i.History(script) {
+		if !e.Spent {
+			total += e.Value
+		}
+	}
+	return total
+}
+
+//Number of distinct scripts in the index
+func (i *AddressIndex) Len() int {
+	return len(i.byScript)
+}
+
+//Writes the index to path, creating its directory when needed
+func (i *AddressIndex) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	w.Write(addressIndexMagic[:])
+	binary.Write(w, binary.LittleEndian, uint32(addressIndexVersion))
+	binary.Write(w, binary.LittleEndian, uint32(len(i.byScript)))
+	//Scripts are written in key order so the same index always produces the same file
+	keys := make([][32]byte, 0, len(i.byScript))
+	for key := range i.byScript {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		return bytes.Compare(keys[a][:], keys[b][:]) < 0
+	})
+	for _, key := range keys {
+		entries := i.byScript[key]
+		w.Write(key[:])
+		binary.Write(w, binary.LittleEndian, uint32(len(entries)))
+		for _, e := range entries {
+			var spent uint8
+			if e.Spent {
+				spent = 1
+			}
+			w.Write(e.Txid[:])
+			binary.Write(w, binary.LittleEndian, e.Vout)
+			binary.Write(w, binary.LittleEndian, uint32(e.Height))
+			binary.Write(w, binary.LittleEndian, e.Value)
+			binary.Write(w, binary.LittleEndian, spent)
+			if e.Spent {
+				w.Write(e.SpentBy.Txid[:])
+				binary.Write(w, binary.LittleEndian, e.SpentBy.Input)
+				binary.Write(w, binary.LittleEndian, uint32(e.SpentBy.Height))
+			}
+		}
+	}
+	return w.Flush()
+}
+
+func LoadAddressIndex(path string) (*AddressIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var header struct {
+		Magic   [4]byte
+		Version uint32
+		Count   uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Magic != addressIndexMagic {
+		return nil, ErrBadIndex
+	}
+	if header.Version != addressIndexVersion {
+		return nil, fmt.Errorf("unsupported address index version %v", header.Version)
+	}
+
+	idx := NewAddressIndex()
+	for n := uint32(0); n < header.Count; n++ {
+		var script struct {
+			Key     [32]byte
+			Entries uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &script); err != nil {
+			return nil, err
+		}
+		entries := make([]AddressEntry, 0, script.Entries)
+		for m := uint32(0); m < script.Entries; m++ {
+			var rec struct {
+				Txid   [32]byte
+				Vout   uint32
+				Height uint32
+				Value  uint64
+				Spent  uint8
+			}
+			if err := binary.Read(r, binary.LittleEndian, &rec); err != nil {
+				return nil, err
+			}
+			e := AddressEntry{
+				Txid:   rec.Txid,
+				Vout:   rec.Vout,
+				Height: int(rec.Height),
+				Value:  rec.Value,
+				Spent:  rec.Spent == 1,
+			}
+			if e.Spent {
+				var spend struct {
+					Txid   [32]byte
+					Input  uint32
+					Height uint32
+				}
+				if err := binary.Read(r, binary.LittleEndian, &spend); err != nil {
+					return nil, err
+				}
+				e.SpentBy = SpendRecord{Txid: spend.Txid, Input: spend.Input, Height: int(spend.Height)}
+			}
+			idx.outputs[OutPoint{Hash: e.Txid, Index: e.Vout}] = entryRef{script: script.Key, pos: len(entries)}
+			entries = append(entries, e)
+		}
+		idx.byScript[script.Key] = entries
+	}
+	return idx, nil
+}
@@ -0,0 +1,73 @@
+package index
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//Outputs to 16 scripts, every other one spent
+func fillAddressIndex() *AddressIndex {
+	i := NewAddressIndex()
+	for n := 0; n < 64; n++ {
+		e := AddressEntry{Txid: [32]byte{byte(n), 2}, Vout: uint32(n % 4), Height: n, Value: uint64(1000 * n)}
+		i.AddOutput([]byte{0x51, byte(n % 16)}, e)
+		if n%2 == 0 {
+			i.Spend(OutPoint{Hash: e.Txid, Index: e.Vout}, SpendRecord{Txid: [32]byte{byte(n), 3}, Input: 1, Height: n + 10})
+		}
+	}
+	return i
+}
+
+func TestAddressIndexRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	var files [][]byte
+	for n := 0; n < 3; n++ {
+		p := filepath.Join(dir, "address.idx")
+		if err := fillAddressIndex().Save(p); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, b)
+	}
+	if !bytes.Equal(files[0], files[1]) || !bytes.Equal(files[0], files[2]) {
+		t.Fatal("saving the same index produced different files")
+	}
+
+	built := fillAddressIndex()
+	loaded, err := LoadAddressIndex(filepath.Join(dir, "address.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 16 || !reflect.DeepEqual(loaded.byScript, built.byScript) || !reflect.DeepEqual(loaded.outputs, built.outputs) {
+		t.Fatalf("loaded %v scripts", loaded.Len())
+	}
+	script := []byte{0x51, 3}
+	if h := loaded.History(script); len(h) != 4 || h[0].Height != 3 || h[0].Spent {
+		t.Errorf("history %v", h)
+	}
+	//Outputs 3, 19, 35 and 51 are all odd so none were spent
+	if b := loaded.Balance(script); b != 1000*(3+19+35+51) {
+		t.Errorf("balance %v", b)
+	}
+
+	//Spends still find their output after loading
+	o := OutPoint{Hash: [32]byte{3, 2}, Index: 3}
+	if !loaded.Spend(o, SpendRecord{Input: 7}) || loaded.Balance(script) != 1000*(19+35+51) {
+		t.Error("spend after load")
+	}
+	if loaded.Spend(OutPoint{Index: 9}, SpendRecord{}) {
+		t.Error("spent an unknown output")
+	}
+
+	bad := filepath.Join(dir, "bad.idx")
+	os.WriteFile(bad, []byte("nope, not an index"), 0644)
+	if _, err := LoadAddressIndex(bad); err != ErrBadIndex {
+		t.Errorf("bad magic: %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const DefaultBlockIndexPath = "./data/index/blocks.idx"
+
+var ErrBadIndex = errors.New("not a block index file")
+
+var blockIndexMagic = [4]byte{'B', 'P', 'B', 'I'}
+
+const blockIndexVersion = 1
+
+//Where a block lives inside the chunk files
+type BlockLocation struct {
+	//Block hash in internal (little endian) byte order
+	Hash   [32]byte
+	Height int
+	//Chunk file name relative to the chunks directory
+	File string
+	//Offset of the block's MagicID inside File
+	Offset int64
+	//Length of the framed block, MagicID and length prefix included
+	Length uint32
+}
+
+//Maps block height and hash to the chunk file, offset and length
+type BlockIndex struct {
+	byHeight []BlockLocation
+	byHash   map[[32]byte]int
+}
+
+func NewBlockIndex() *BlockIndex {
+	return &BlockIndex{
+		byHash: make(map[[32]byte]int),
+	}
+}
+
+//Adds or replaces the location of the block at l.Height
+func (i *BlockIndex) Add(l BlockLocation) {
+	for len(i.byHeight) <= l.Height {
+		i.byHeight = append(i.byHeight, BlockLocation{Height: -1})
+	}
+	if old := i.byHeight[l.Height]; old.Height >= 0 && i.byHash[old.Hash] == l.Height {
+		delete(i.byHash, old.Hash)
+	}
+	i.byHeight[l.Height] = l
+	i.byHash[l.Hash] = l.Height
+}
+
+func (i *BlockIndex) ByHeight(height int) (BlockLocation, bool) {
+	if height < 0 || height >= len(i.byHeight) || i.byHeight[height].Height < 0 {
+		return BlockLocation{}, false
+	}
+	return i.byHeight[height], true
+}
+
+func (i *BlockIndex) ByHash(hash [32]byte) (BlockLocation, bool) {
+	h, ok := i.byHash[hash]
+	if !ok {
+		return BlockLocation{}, false
+	}
+	return i.byHeight[h], true
+}
+
+//Number of heights covered, including any gaps
+func (i *BlockIndex) Len() int {
+	return len(i.byHeight)
+}
+
+//Points every entry in chunk file from at file to, used when the chunker renames a chunk
+func (i *BlockIndex) RenameFile(from, to string) {
+	for n := range i.byHeight {
+		if i.byHeight[n].File == from {
+			i.byHeight[n].File = to
+		}
+	}
+}
+
+//Writes the index to path, creating its directory when needed
+func (i *BlockIndex) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	count := 0
+	for _, l := range i.byHeight {
+		if l.Height >= 0 {
+			count++
+		}
+	}
+
+	w := bufio.NewWriter(f)
+	w.Write(blockIndexMagic[:])
+	binary.Write(w, binary.LittleEndian, uint32(blockIndexVersion))
+	binary.Write(w, binary.LittleEndian, uint32(count))
+	for _, l := range i.byHeight {
+		if l.Height < 0 {
+			continue
+		}
+		w.Write(l.Hash[:])
+		binary.Write(w, binary.LittleEndian, uint32(l.Height))
+		binary.Write(w, binary.LittleEndian, l.Offset)
+		binary.Write(w, binary.LittleEndian, l.Length)
+		binary.Write(w, binary.LittleEndian, uint16(len(l.File)))
+		w.WriteString(l.File)
+	}
+	return w.Flush()
+}
+
+func LoadBlockIndex(path string) (*BlockIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var header struct {
+		Magic   [4]byte
+		Version uint32
+		Count   uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Magic != blockIndexMagic {
+		return nil, ErrBadIndex
+	}
+	if header.Version != blockIndexVersion {
+		return nil, fmt.Errorf("unsupported block index version %v", header.Version)
+	}
+
+	idx := NewBlockIndex()
+	for n := uint32(0); n < header.Count; n++ {
+		var rec struct {
+			Hash    [32]byte
+			Height  uint32
+			Offset  int64
+			Length  uint32
+			NameLen uint16
+		}
+		if err := binary.Read(r, binary.LittleEndian, &rec); err != nil {
+			return nil, err
+		}
+		name := make([]byte, rec.NameLen)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, err
+		}
+		idx.Add(BlockLocation{
+			Hash:   rec.Hash,
+			Height: int(rec.Height),
+			File:   string(name),
+			Offset: rec.Offset,
+			Length: rec.Length,
+		})
+	}
+	return idx, nil
+}
@@ -0,0 +1,175 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const DefaultSpendIndexPath = "./data/index/spend.idx"
+
+var spendIndexMagic = [4]byte{'B', 'P', 'S', 'I'}
+
+const spendIndexVersion = 1
+
+//Output of a transaction, Hash is the txid in internal (little endian) byte order
+type OutPoint struct {
+	Hash  [32]byte
+	Index uint32
+}
+
+//Coinbase inputs reference the null outpoint, they don't spend anything
+func (o OutPoint) IsNull() bool {
+	return o.Hash == [32]byte{} && o.Index == 0xffffffff
+}
+
+//Transaction input that spent an outpoint
+type SpendRecord struct {
+	Txid   [32]byte
+	Input  uint32
+	Height int
+}
+
+const (
+	spendIndexHeaderSize = 12
+	spendRecordSize      = 76
+)
+
+//Maps every spent outpoint to the input spending it.
+//Like TxIndex it's held in memory while built and binary searched in its file once loaded
+type SpendIndex struct {
+	spends map[OutPoint]SpendRecord
+	//Sorted records of a loaded index
+	file  *os.File
+	count int
+}
+
+func NewSpendIndex() *SpendIndex {
+	return &SpendIndex{
+		spends: make(map[OutPoint]SpendRecord),
+	}
+}
+
+func (i *SpendIndex) Add(o OutPoint, r SpendRecord) {
+	i.spends[o] = r
+}
+
+//A record of a loaded index that can't be read counts as missing
+func (i *SpendIndex) Lookup(o OutPoint) (SpendRecord, bool) {
+	if r, ok := i.spends[o]; ok || i.file == nil {
+		return r, ok
+	}
+	rec, ok := searchRecords(i.file, spendIndexHeaderSize, i.count, spendRecordSize, func(rec []byte) int {
+		if c := bytes.Compare(rec[:32], o.Hash[:]); c != 0 {
+			return c
+		}
+		switch index := binary.LittleEndian.Uint32(rec[32:]); {
+		case index < o.Index:
+			return -1
+		case index > o.Index:
+			return 1
+		}
+		return 0
+	})
+	if !ok {
+		return SpendRecord{}, false
+	}
+	r := SpendRecord{
+		Input:  binary.LittleEndian.Uint32(rec[68:]),
+		Height: int(binary.LittleEndian.Uint32(rec[72:])),
+	}
+	copy(r.Txid[:], rec[36:68])
+	return r, true
+}
+
+func (i *SpendIndex) Len() int {
+	return len(i.spends) + i.count
+}
+
+//Releases the file of a loaded index
+func (i *SpendIndex) Close() error {
+	if i.file == nil {
+		return nil
+	}
+	return i.file.Close()
+}
+
+//Writes the index to path, creating its directory when needed.
+//A loaded index is already saved, it returns ErrIndexReadOnly.
+func (i *SpendIndex) Save(path string) error {
+	if i.file != nil {
+		return ErrIndexReadOnly
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	w.Write(spendIndexMagic[:])
+	binary.Write(w, binary.LittleEndian, uint32(spendIndexVersion))
+	binary.Write(w, binary.LittleEndian, uint32(len(i.spends)))
+	//Outpoints are written in order so the same index always produces the same file
+	outpoints := make([]OutPoint, 0, len(i.spends))
+	for o := range i.spends {
+		outpoints = append(outpoints, o)
+	}
+	sort.Slice(outpoints, func(a, b int) bool {
+		if c := bytes.Compare(outpoints[a].Hash[:], outpoints[b].Hash[:]); c != 0 {
+			return c < 0
+		}
+		return outpoints[a].Index < outpoints[b].Index
+	})
+	for _, o := range outpoints {
+		r := i.spends[o]
+		w.Write(o.Hash[:])
+		binary.Write(w, binary.LittleEndian, o.Index)
+		w.Write(r.Txid[:])
+		binary.Write(w, binary.LittleEndian, r.Input)
+		binary.Write(w, binary.LittleEndian, uint32(r.Height))
+	}
+	return w.Flush()
+}
+
+//Opens the index at path, lookups read the records they need from the file. Close it when done.
+func LoadSpendIndex(path string) (*SpendIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var header struct {
+		Magic   [4]byte
+		Version uint32
+		Count   uint32
+	}
+	if err := binary.Read(f, binary.LittleEndian, &header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if header.Magic != spendIndexMagic {
+		f.Close()
+		return nil, ErrBadIndex
+	}
+	if header.Version != spendIndexVersion {
+		f.Close()
+		return nil, fmt.Errorf("unsupported spend index version %v", header.Version)
+	}
+	if info, err := f.Stat(); err != nil || info.Size() != spendIndexHeaderSize+int64(header.Count)*spendRecordSize {
+		f.Close()
+		return nil, ErrBadIndex
+	}
+
+	idx := NewSpendIndex()
+	idx.file = f
+	idx.count = int(header.Count)
+	return idx, nil
+}
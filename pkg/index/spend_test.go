@@ -0,0 +1,90 @@
+package index
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func fillSpendIndex() *SpendIndex {
+	i := NewSpendIndex()
+	for n := 0; n < 64; n++ {
+		o := OutPoint{Hash: [32]byte{byte(n * 37)}, Index: uint32(n % 3)}
+		i.Add(o, SpendRecord{Txid: [32]byte{byte(n), 1}, Input: uint32(n % 5), Height: 100 + n})
+	}
+	return i
+}
+
+func TestSpendIndexRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	var files [][]byte
+	for n := 0; n < 3; n++ {
+		p := filepath.Join(dir, "spend.idx")
+		if err := fillSpendIndex().Save(p); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, b)
+	}
+	if !bytes.Equal(files[0], files[1]) || !bytes.Equal(files[0], files[2]) {
+		t.Fatal("saving the same index produced different files")
+	}
+
+	i, err := LoadSpendIndex(filepath.Join(dir, "spend.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+	if i.Len() != 64 {
+		t.Fatalf("loaded %v spends", i.Len())
+	}
+	r, ok := i.Lookup(OutPoint{Hash: [32]byte{byte(5 * 37)}, Index: 2})
+	if !ok || r != (SpendRecord{Txid: [32]byte{5, 1}, Input: 0, Height: 105}) {
+		t.Fatalf("lookup: %+v %v", r, ok)
+	}
+	if _, ok := i.Lookup(OutPoint{Hash: [32]byte{byte(5 * 37)}, Index: 1}); ok {
+		t.Fatal("unspent outpoint found")
+	}
+
+	//Every record is found by binary searching the file
+	built := fillSpendIndex()
+	for n := 0; n < 64; n++ {
+		o := OutPoint{Hash: [32]byte{byte(n * 37)}, Index: uint32(n % 3)}
+		want, _ := built.Lookup(o)
+		if r, ok := i.Lookup(o); !ok || r != want {
+			t.Errorf("outpoint %v: %+v %v", n, r, ok)
+		}
+	}
+	if err := i.Save(filepath.Join(dir, "spend.idx")); err != ErrIndexReadOnly {
+		t.Errorf("saved a loaded index: %v", err)
+	}
+}
+
+//Output indexes above 255 only sort right when compared as numbers, not as little endian bytes
+func TestSpendIndexIndexOrder(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "spend.idx")
+	built := NewSpendIndex()
+	for _, index := range []uint32{1, 255, 256, 511, 65536} {
+		built.Add(OutPoint{Index: index}, SpendRecord{Input: index})
+	}
+	if err := built.Save(p); err != nil {
+		t.Fatal(err)
+	}
+	i, err := LoadSpendIndex(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+	for _, index := range []uint32{1, 255, 256, 511, 65536} {
+		if r, ok := i.Lookup(OutPoint{Index: index}); !ok || r.Input != index {
+			t.Errorf("index %v: %+v %v", index, r, ok)
+		}
+	}
+	if _, ok := i.Lookup(OutPoint{Index: 257}); ok {
+		t.Error("found index 257")
+	}
+}
@@ -0,0 +1,232 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const DefaultTxIndexPath = "./data/index/tx.idx"
+
+var ErrIndexReadOnly = errors.New("index was loaded from a file and can't be saved")
+
+var txIndexMagic = [4]byte{'B', 'P', 'T', 'I'}
+
+const txIndexVersion = 1
+
+//Where a transaction lives, relative to its block
+type TxLocation struct {
+	Height int
+	//Position of the transaction inside the block, 0 is the coinbase
+	Position int
+	//Offset of the transaction from the block's MagicID
+	Offset uint32
+	Length uint32
+}
+
+//Size of the file header and of its txid and wtxid records
+const (
+	txIndexHeaderSize = 16
+	txRecordSize      = 48
+	wtxidRecordSize   = 64
+)
+
+//Maps txid, and optionally wtxid, to the block and byte range holding the transaction.
+//Hashes are in internal (little endian) byte order.
+//An index built with Add is held in memory, roughly 100 bytes per transaction and as much
+//again per wtxid, which suits testnets and chain prefixes but comes to around 100GB for mainnet.
+//LoadTxIndex leaves the records in the file and binary searches them instead.
+type TxIndex struct {
+	//Also record wtxids that differ from the txid
+	Witness bool
+	byTxid  map[[32]byte]TxLocation
+	byWtxid map[[32]byte][32]byte
+	//Sorted records of a loaded index
+	file          *os.File
+	txids, wtxids int
+}
+
+func NewTxIndex(witness bool) *TxIndex {
+	return &TxIndex{
+		Witness: witness,
+		byTxid:  make(map[[32]byte]TxLocation),
+		byWtxid: make(map[[32]byte][32]byte),
+	}
+}
+
+func (i *TxIndex) Add(txid, wtxid [32]byte, l TxLocation) {
+	i.byTxid[txid] = l
+	if i.Witness && wtxid != txid {
+		i.byWtxid[wtxid] = txid
+	}
+}
+
+//Looks hash up as a txid first and as a wtxid second, returning the txid it resolved to.
+//A record of a loaded index that can't be read counts as missing.
+func (i *TxIndex) Lookup(hash [32]byte) ([32]byte, TxLocation, bool) {
+	if l, ok := i.lookupTxid(hash); ok {
+		return hash, l, true
+	}
+	txid, ok := i.byWtxid[hash]
+	if !ok && i.file != nil {
+		var rec []byte
+		rec, ok = searchRecords(i.file, txIndexHeaderSize+int64(i.txids)*txRecordSize, i.wtxids, wtxidRecordSize, byHash(hash))
+		if ok {
+			copy(txid[:], rec[32:])
+		}
+	}
+	if ok {
+		l, ok := i.lookupTxid(txid)
+		return txid, l, ok
+	}
+	return [32]byte{}, TxLocation{}, false
+}
+
+func (i *TxIndex) lookupTxid(txid [32]byte) (TxLocation, bool) {
+	if l, ok := i.byTxid[txid]; ok || i.file == nil {
+		return l, ok
+	}
+	rec, ok := searchRecords(i.file, txIndexHeaderSize, i.txids, txRecordSize, byHash(txid))
+	if !ok {
+		return TxLocation{}, false
+	}
+	return TxLocation{
+		Height:   int(binary.LittleEndian.Uint32(rec[32:])),
+		Position: int(binary.LittleEndian.Uint32(rec[36:])),
+		Offset:   binary.LittleEndian.Uint32(rec[40:]),
+		Length:   binary.LittleEndian.Uint32(rec[44:]),
+	}, true
+}
+
+func (i *TxIndex) Len() int {
+	return len(i.byTxid) + i.txids
+}
+
+//Releases the file of a loaded index
+func (i *TxIndex) Close() error {
+	if i.file == nil {
+		return nil
+	}
+	return i.file.Close()
+}
+
+//Writes the index to path, creating its directory when needed.
+//A loaded index is already saved, it returns ErrIndexReadOnly.
+func (i *TxIndex) Save(path string) error {
+	if i.file != nil {
+		return ErrIndexReadOnly
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	w.Write(txIndexMagic[:])
+	binary.Write(w, binary.LittleEndian, uint32(txIndexVersion))
+	binary.Write(w, binary.LittleEndian, uint32(len(i.byTxid)))
+	binary.Write(w, binary.LittleEndian, uint32(len(i.byWtxid)))
+	//Entries are written in hash order so the same index always produces the same file
+	for _, txid := range sortedHashes(i.byTxid) {
+		l := i.byTxid[txid]
+		w.Write(txid[:])
+		binary.Write(w, binary.LittleEndian, uint32(l.Height))
+		binary.Write(w, binary.LittleEndian, uint32(l.Position))
+		binary.Write(w, binary.LittleEndian, l.Offset)
+		binary.Write(w, binary.LittleEndian, l.Length)
+	}
+	for _, wtxid := range sortedHashes(i.byWtxid) {
+		txid := i.byWtxid[wtxid]
+		w.Write(wtxid[:])
+		w.Write(txid[:])
+	}
+	return w.Flush()
+}
+
+//Opens the index at path, lookups read the records they need from the file. Close it when done.
+func LoadTxIndex(path string) (*TxIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var header struct {
+		Magic   [4]byte
+		Version uint32
+		Txids   uint32
+		Wtxids  uint32
+	}
+	if err := binary.Read(f, binary.LittleEndian, &header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if header.Magic != txIndexMagic {
+		f.Close()
+		return nil, ErrBadIndex
+	}
+	if header.Version != txIndexVersion {
+		f.Close()
+		return nil, fmt.Errorf("unsupported tx index version %v", header.Version)
+	}
+	//A truncated file would otherwise only show up as missing records
+	size := txIndexHeaderSize + int64(header.Txids)*txRecordSize + int64(header.Wtxids)*wtxidRecordSize
+	if info, err := f.Stat(); err != nil || info.Size() != size {
+		f.Close()
+		return nil, ErrBadIndex
+	}
+
+	idx := NewTxIndex(header.Wtxids > 0)
+	idx.file = f
+	idx.txids, idx.wtxids = int(header.Txids), int(header.Wtxids)
+	return idx, nil
+}
+
+//Keys of m in byte order
+func sortedHashes[V any](m map[[32]byte]V) [][32]byte {
+	keys := make([][32]byte, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		return bytes.Compare(keys[a][:], keys[b][:]) < 0
+	})
+	return keys
+}
+
+//Binary searches count records of size bytes starting at offset in r. cmp orders a record
+//against the one sought, the records being sorted by it. Returns the record cmp matches.
+func searchRecords(r io.ReaderAt, offset int64, count, size int, cmp func(rec []byte) int) ([]byte, bool) {
+	rec := make([]byte, size)
+	var err error
+	n := sort.Search(count, func(n int) bool {
+		if err != nil {
+			return true
+		}
+		_, err = r.ReadAt(rec, offset+int64(n)*int64(size))
+		return cmp(rec) >= 0
+	})
+	if err != nil || n == count {
+		return nil, false
+	}
+	if _, err := r.ReadAt(rec, offset+int64(n)*int64(size)); err != nil || cmp(rec) != 0 {
+		return nil, false
+	}
+	return rec, true
+}
+
+//Orders records by the hash they start with
+func byHash(hash [32]byte) func(rec []byte) int {
+	return func(rec []byte) int {
+		return bytes.Compare(rec[:32], hash[:])
+	}
+}
@@ -0,0 +1,118 @@
+package index
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func fillTxIndex(witness bool) *TxIndex {
+	i := NewTxIndex(witness)
+	for n := 0; n < 64; n++ {
+		txid := [32]byte{byte(n * 37), byte(n)}
+		wtxid := txid
+		if n%2 == 1 {
+			wtxid[31] = 0xff
+		}
+		i.Add(txid, wtxid, TxLocation{Height: n / 4, Position: n % 4, Offset: uint32(80 + n), Length: uint32(200 + n)})
+	}
+	return i
+}
+
+func TestTxIndexSaveDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	var files [][]byte
+	for n := 0; n < 3; n++ {
+		p := filepath.Join(dir, "tx.idx")
+		if err := fillTxIndex(true).Save(p); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, b)
+	}
+	if !bytes.Equal(files[0], files[1]) || !bytes.Equal(files[0], files[2]) {
+		t.Fatal("saving the same index produced different files")
+	}
+}
+
+func TestTxIndexRoundTrip(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "tx.idx")
+	if err := fillTxIndex(true).Save(p); err != nil {
+		t.Fatal(err)
+	}
+	i, err := LoadTxIndex(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+	if i.Len() != 64 || !i.Witness {
+		t.Fatalf("loaded %v txids, witness %v", i.Len(), i.Witness)
+	}
+
+	txid := [32]byte{byte(5 * 37), 5}
+	wtxid := txid
+	wtxid[31] = 0xff
+	got, l, ok := i.Lookup(wtxid)
+	if !ok || got != txid || l != (TxLocation{Height: 1, Position: 1, Offset: 85, Length: 205}) {
+		t.Fatalf("wtxid lookup: %x %+v %v", got, l, ok)
+	}
+	if got, _, ok := i.Lookup(txid); !ok || got != txid {
+		t.Fatalf("txid lookup: %x %v", got, ok)
+	}
+
+	//Every record is found by binary searching the file, first and last included
+	built := fillTxIndex(true)
+	for n := 0; n < 64; n++ {
+		txid := [32]byte{byte(n * 37), byte(n)}
+		_, want, _ := built.Lookup(txid)
+		if got, l, ok := i.Lookup(txid); !ok || got != txid || l != want {
+			t.Errorf("txid %v: %x %+v %v", n, got, l, ok)
+		}
+	}
+	for _, missing := range [][32]byte{{1}, {0xff, 0xff}, {byte(5 * 37), 6}} {
+		if _, _, ok := i.Lookup(missing); ok {
+			t.Errorf("found %x", missing)
+		}
+	}
+	if err := i.Save(p); err != ErrIndexReadOnly {
+		t.Errorf("saved a loaded index: %v", err)
+	}
+}
+
+func TestTxIndexTruncated(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "tx.idx")
+	if err := fillTxIndex(true).Save(p); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, b[:len(b)-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTxIndex(p); err != ErrBadIndex {
+		t.Fatalf("loaded a truncated index: %v", err)
+	}
+}
+
+func TestTxIndexWitnessOff(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "tx.idx")
+	if err := fillTxIndex(false).Save(p); err != nil {
+		t.Fatal(err)
+	}
+	i, err := LoadTxIndex(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+	wtxid := [32]byte{byte(5 * 37), 5}
+	wtxid[31] = 0xff
+	if _, _, ok := i.Lookup(wtxid); ok || i.Witness {
+		t.Fatal("wtxid indexed with Witness off")
+	}
+}
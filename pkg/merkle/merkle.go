@@ -0,0 +1,121 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/lirancohen/blockparser/pkg/hashes"
+)
+
+var ErrEmptyTree = errors.New("merkle tree has no leaves")
+var ErrIndexOutOfRange = errors.New("leaf index out of range")
+
+//Inclusion proof for a single leaf.
+//Hashes holds the sibling of the node at every level, starting at the leaves.
+type Proof struct {
+	Index  int
+	Leaves int
+	Hashes [][]byte
+}
+
+//Computes the merkle root from leaf hashes in internal (little endian) byte order.
+//Levels with an odd number of nodes duplicate their last node, as Bitcoin does.
+func Root(leaves [][]byte) ([]byte, error) {
+	root, _, err := RootMutated(leaves)
+	return root, err
+}
+
+//Same as Root, also reporting whether two sibling nodes are equal anywhere in the tree.
+//Such a tree has the same root as one with some leaves left out (CVE-2012-2459),
+//so a block whose transactions produce it is invalid whatever its merkle root.
+func RootMutated(leaves [][]byte) ([]byte, bool, error) {
+	if len(leaves) == 0 {
+		return nil, false, ErrEmptyTree
+	}
+	mutated := false
+	level := leaves
+	for len(level) > 1 {
+		for i := 0; i+1 < len(level); i += 2 {
+			if bytes.Equal(level[i], level[i+1]) {
+				mutated = true
+			}
+		}
+		level = nextLevel(level)
+	}
+	return level[0], mutated, nil
+}
+
+//Number of hashes in a proof for a tree with the given number of leaves
+func Depth(leaves int) int {
+	depth := 0
+	for n := leaves; n > 1; n = (n + 1) / 2 {
+		depth++
+	}
+	return depth
+}
+
+//Builds the inclusion proof for the leaf at index
+func BuildProof(leaves [][]byte, index int) (Proof, error) {
+	if len(leaves) == 0 {
+		return Proof{}, ErrEmptyTree
+	}
+	if index < 0 || index >= len(leaves) {
+		return Proof{}, ErrIndexOutOfRange
+	}
+
+	p := Proof{Index: index, Leaves: len(leaves)}
+	level := leaves
+	for i := index; len(level) > 1; i >>= 1 {
+		sibling := i ^ 1
+		if sibling >= len(level) {
+			sibling = i
+		}
+		p.Hashes = append(p.Hashes, level[sibling])
+		level = nextLevel(level)
+	}
+	return p, nil
+}
+
+//Checks that leaf hashes up to root through the proof.
+//The proof has to match the shape of a tree with Leaves leaves: one hash per level,
+//and where the node is the last of an odd level its sibling is the node itself.
+func (p Proof) Verify(leaf, root []byte) bool {
+	if p.Index < 0 || p.Index >= p.Leaves || len(p.Hashes) != Depth(p.Leaves) {
+		return false
+	}
+	cur := leaf
+	i := p.Index
+	width := p.Leaves
+	for _, h := range p.Hashes {
+		if i == width-1 && width%2 == 1 && !bytes.Equal(h, cur) {
+			return false
+		}
+		if i&1 == 0 {
+			cur = hashPair(cur, h)
+		} else {
+			cur = hashPair(h, cur)
+		}
+		i >>= 1
+		width = (width + 1) / 2
+	}
+	return bytes.Equal(cur, root)
+}
+
+func nextLevel(level [][]byte) [][]byte {
+	var next [][]byte
+	for i := 0; i < len(level); i += 2 {
+		if i+1 < len(level) {
+			next = append(next, hashPair(level[i], level[i+1]))
+		} else {
+			next = append(next, hashPair(level[i], level[i]))
+		}
+	}
+	return next
+}
+
+func hashPair(l, r []byte) []byte {
+	d := make([]byte, 0, len(l)+len(r))
+	d = append(d, l...)
+	d = append(d, r...)
+	return hashes.Hash256(d)
+}
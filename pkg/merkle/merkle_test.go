@@ -0,0 +1,101 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func leaves(n int) [][]byte {
+	var l [][]byte
+	for i := 0; i < n; i++ {
+		h := sha256.Sum256([]byte{byte(i), byte(i >> 8)})
+		l = append(l, h[:])
+	}
+	return l
+}
+
+func TestProofsVerify(t *testing.T) {
+	for n := 1; n <= 33; n++ {
+		l := leaves(n)
+		root, err := Root(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range l {
+			p, err := BuildProof(l, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Hashes) != Depth(n) {
+				t.Fatalf("%v leaves: proof has %v hashes, depth %v", n, len(p.Hashes), Depth(n))
+			}
+			if !p.Verify(l[i], root) {
+				t.Fatalf("%v leaves: proof for %v rejected", n, i)
+			}
+			if p.Verify(l[(i+1)%n], root) && n > 1 {
+				t.Fatalf("%v leaves: proof for %v accepts another leaf", n, i)
+			}
+		}
+	}
+}
+
+func TestProofShape(t *testing.T) {
+	l := leaves(5)
+	root, _ := Root(l)
+	p, _ := BuildProof(l, 2)
+
+	//A proof for the same leaf claimed to come from a tree of another depth
+	for _, n := range []int{2, 3, 4, 9, 17} {
+		q := p
+		q.Leaves = n
+		if q.Verify(l[2], root) {
+			t.Errorf("proof for 5 leaves accepted as %v", n)
+		}
+	}
+
+	//Too short and too long
+	short := p
+	short.Hashes = p.Hashes[:len(p.Hashes)-1]
+	long := p
+	long.Hashes = append(append([][]byte{}, p.Hashes...), root)
+	if short.Verify(l[2], root) || long.Verify(l[2], root) {
+		t.Error("proof with the wrong number of hashes accepted")
+	}
+
+	//The last leaf of an odd level must be paired with itself
+	last, _ := BuildProof(l, 4)
+	forged := last
+	forged.Hashes = append([][]byte{l[3]}, last.Hashes[1:]...)
+	if forged.Verify(l[4], root) {
+		t.Error("odd level sibling other than the node itself accepted")
+	}
+}
+
+func TestRootMutated(t *testing.T) {
+	l := leaves(3)
+	root, mutated, err := RootMutated(l)
+	if err != nil || mutated {
+		t.Fatalf("3 leaves reported mutated: %v", err)
+	}
+
+	//CVE-2012-2459: repeating the last leaf gives the same root
+	dup := append(append([][]byte{}, l...), l[2])
+	droot, mutated, _ := RootMutated(dup)
+	if !bytes.Equal(root, droot) || !mutated {
+		t.Fatalf("duplicated leaf: same root %v, mutated %v", bytes.Equal(root, droot), mutated)
+	}
+
+	//Same for a duplicated subtree higher up
+	six := leaves(6)
+	sroot, _ := Root(six)
+	dup = append(append([][]byte{}, six...), six[4], six[5])
+	droot, mutated, _ = RootMutated(dup)
+	if !bytes.Equal(sroot, droot) || !mutated {
+		t.Fatalf("duplicated subtree: same root %v, mutated %v", bytes.Equal(sroot, droot), mutated)
+	}
+
+	if _, _, err := RootMutated(nil); err != ErrEmptyTree {
+		t.Errorf("empty tree: %v", err)
+	}
+}
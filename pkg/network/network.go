@@ -0,0 +1,134 @@
+package network
+
+import (
+	"fmt"
+	"strings"
+)
+
+//Consensus and encoding parameters of a Bitcoin network
+type Network struct {
+	Name string
+	//Message start bytes as they appear on disk in front of every block
+	Magic [4]byte
+	//Genesis block hash in display (big endian) hex
+	GenesisHash string
+
+	//Base58Check version bytes and Bech32 human readable part
+	PubKeyHashAddrID byte
+	ScriptHashAddrID byte
+	Bech32HRP        string
+
+	PowLimitBits     uint32
+	RetargetInterval int
+	TargetTimespan   int64
+	TargetSpacing    int64
+	//Testnets allow a minimum difficulty block when none was found for twice the target spacing
+	ReduceMinDifficulty bool
+	//Regtest never retargets
+	NoRetargeting bool
+	//BIP94: retarget from the first block of the period so min difficulty blocks can't leak in
+	EnforceBIP94 bool
+}
+
+var MainNet = &Network{
+	Name:             "mainnet",
+	Magic:            [4]byte{0xf9, 0xbe, 0xb4, 0xd9},
+	GenesisHash:      "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+	PubKeyHashAddrID: 0x00,
+	ScriptHashAddrID: 0x05,
+	Bech32HRP:        "bc",
+	PowLimitBits:     0x1d00ffff,
+	RetargetInterval: 2016,
+	TargetTimespan:   14 * 24 * 60 * 60,
+	TargetSpacing:    10 * 60,
+}
+
+var TestNet3 = &Network{
+	Name:                "testnet3",
+	Magic:               [4]byte{0x0b, 0x11, 0x09, 0x07},
+	GenesisHash:         "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943",
+	PubKeyHashAddrID:    0x6f,
+	ScriptHashAddrID:    0xc4,
+	Bech32HRP:           "tb",
+	PowLimitBits:        0x1d00ffff,
+	RetargetInterval:    2016,
+	TargetTimespan:      14 * 24 * 60 * 60,
+	TargetSpacing:       10 * 60,
+	ReduceMinDifficulty: true,
+}
+
+var TestNet4 = &Network{
+	Name:                "testnet4",
+	Magic:               [4]byte{0x1c, 0x16, 0x3f, 0x28},
+	GenesisHash:         "00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043",
+	PubKeyHashAddrID:    0x6f,
+	ScriptHashAddrID:    0xc4,
+	Bech32HRP:           "tb",
+	PowLimitBits:        0x1d00ffff,
+	RetargetInterval:    2016,
+	TargetTimespan:      14 * 24 * 60 * 60,
+	TargetSpacing:       10 * 60,
+	ReduceMinDifficulty: true,
+	EnforceBIP94:        true,
+}
+
+//Default public signet
+var SigNet = &Network{
+	Name:             "signet",
+	Magic:            [4]byte{0x0a, 0x03, 0xcf, 0x40},
+	GenesisHash:      "00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6",
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0xc4,
+	Bech32HRP:        "tb",
+	PowLimitBits:     0x1e0377ae,
+	RetargetInterval: 2016,
+	TargetTimespan:   14 * 24 * 60 * 60,
+	TargetSpacing:    10 * 60,
+}
+
+var RegTest = &Network{
+	Name:                "regtest",
+	Magic:               [4]byte{0xfa, 0xbf, 0xb5, 0xda},
+	GenesisHash:         "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206",
+	PubKeyHashAddrID:    0x6f,
+	ScriptHashAddrID:    0xc4,
+	Bech32HRP:           "bcrt",
+	PowLimitBits:        0x207fffff,
+	RetargetInterval:    2016,
+	TargetTimespan:      14 * 24 * 60 * 60,
+	TargetSpacing:       10 * 60,
+	ReduceMinDifficulty: true,
+	NoRetargeting:       true,
+}
+
+var Networks = []*Network{MainNet, TestNet3, TestNet4, SigNet, RegTest}
+
+//Looks a network up by name, "main", "test" and "testnet" are accepted as aliases
+func ByName(name string) (*Network, error) {
+	switch strings.ToLower(name) {
+	case "main":
+		return MainNet, nil
+	case "test", "testnet":
+		return TestNet3, nil
+	}
+	for _, n := range Networks {
+		if strings.EqualFold(n.Name, name) {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown network: %v", name)
+}
+
+//Looks a network up by the MagicID found in front of a block
+func ByMagic(m [4]byte) (*Network, error) {
+	for _, n := range Networks {
+		if n.Magic == m {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown magic id: %x", m)
+}
+
+func (n *Network) String() string {
+	return n.Name
+}
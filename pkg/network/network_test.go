@@ -0,0 +1,84 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+//Display hash of a version 1 header with no parent
+func genesisHash(merkleRoot string, time, bits, nonce uint32) string {
+	header := binary.LittleEndian.AppendUint32(nil, 1)
+	header = append(header, make([]byte, 32)...)
+	root, _ := hex.DecodeString(merkleRoot)
+	for i := len(root) - 1; i >= 0; i-- {
+		header = append(header, root[i])
+	}
+	header = binary.LittleEndian.AppendUint32(header, time)
+	header = binary.LittleEndian.AppendUint32(header, bits)
+	header = binary.LittleEndian.AppendUint32(header, nonce)
+	first := sha256.Sum256(header)
+	hash := sha256.Sum256(first[:])
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	return hex.EncodeToString(hash[:])
+}
+
+func TestGenesis(t *testing.T) {
+	const satoshiRoot = "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+	cases := []struct {
+		net   *Network
+		magic string
+		hash  string
+	}{
+		{MainNet, "f9beb4d9", genesisHash(satoshiRoot, 1231006505, 0x1d00ffff, 2083236893)},
+		{TestNet3, "0b110907", genesisHash(satoshiRoot, 1296688602, 0x1d00ffff, 414098458)},
+		{TestNet4, "1c163f28", genesisHash("7aa0a7ae1e223414cb807e40cd57e667b718e42aaf9306db9102fe28912b7b4e", 1714777860, 0x1d00ffff, 393743547)},
+		{SigNet, "0a03cf40", genesisHash(satoshiRoot, 1598918400, 0x1e0377ae, 52613770)},
+		{RegTest, "fabfb5da", genesisHash(satoshiRoot, 1296688602, 0x207fffff, 2)},
+	}
+	for _, c := range cases {
+		if m := hex.EncodeToString(c.net.Magic[:]); m != c.magic {
+			t.Errorf("%v: magic %v", c.net, m)
+		}
+		if c.net.GenesisHash != c.hash {
+			t.Errorf("%v: genesis %v, header hashes to %v", c.net, c.net.GenesisHash, c.hash)
+		}
+		if n, err := ByMagic(c.net.Magic); err != nil || n != c.net {
+			t.Errorf("%v: ByMagic gave %v, %v", c.net, n, err)
+		}
+	}
+	if len(cases) != len(Networks) {
+		t.Errorf("%v networks, %v tested", len(Networks), len(cases))
+	}
+	if _, err := ByMagic([4]byte{1, 2, 3, 4}); err == nil {
+		t.Error("unknown magic found")
+	}
+}
+
+func TestByName(t *testing.T) {
+	cases := map[string]*Network{
+		"mainnet":  MainNet,
+		"main":     MainNet,
+		"MAINNET":  MainNet,
+		"testnet3": TestNet3,
+		"testnet":  TestNet3,
+		"test":     TestNet3,
+		"testnet4": TestNet4,
+		"signet":   SigNet,
+		"regtest":  RegTest,
+		"RegTest":  RegTest,
+	}
+	for name, want := range cases {
+		if n, err := ByName(name); err != nil || n != want {
+			t.Errorf("%v: %v, %v", name, n, err)
+		}
+	}
+	for _, name := range []string{"", "testnet5", "bitcoin"} {
+		if n, err := ByName(name); err == nil {
+			t.Errorf("%q: %v", name, n)
+		}
+	}
+}
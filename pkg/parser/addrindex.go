@@ -0,0 +1,41 @@
+package parser
+
+import (
+	"context"
+
+	"github.com/lirancohen/blockparser/pkg/index"
+	"github.com/lirancohen/blockparser/pkg/script"
+)
+
+//Builds the address index by walking every block from s onwards, following the chunk sequence with Next
+func BuildAddressIndex(s *Stream) (*index.AddressIndex, error) {
+	idx := index.NewAddressIndex()
+	var txid [32]byte
+
+	sc := NewScanner()
+	sc.OnTransaction(func(p ScanPosition, t *Transaction) error {
+		copy(txid[:], t.Hash())
+		return nil
+	})
+	//Inputs run before outputs, an output can't be spent by the transaction creating it
+	sc.OnInput(func(p ScanPosition, t *Transaction, in *TransInput) error {
+		if o := in.OutPoint(); !o.IsNull() {
+			idx.Spend(o, index.SpendRecord{Txid: txid, Input: uint32(p.Index), Height: p.Height})
+		}
+		return nil
+	})
+	sc.OnOutput(func(p ScanPosition, t *Transaction, out *TransOutput) error {
+		if out.ScriptType() == script.NullData {
+			return nil
+		}
+		idx.AddOutput(out.Script(), index.AddressEntry{
+			Txid:   txid,
+			Vout:   uint32(p.Index),
+			Height: p.Height,
+			Value:  out.Value(),
+		})
+		return nil
+	})
+	_, err := sc.Scan(context.Background(), s)
+	return idx, err
+}
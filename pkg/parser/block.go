@@ -6,7 +6,9 @@ import (
 	"encoding/binary"
 	"log"
 	"time"
-	"crypto/sha256"
+	"github.com/lirancohen/blockparser/pkg/merkle"
+	"github.com/lirancohen/blockparser/pkg/hashes"
+	"github.com/lirancohen/blockparser/pkg/script"
 	"github.com/lirancohen/blockparser/pkg/utils"
 	"strings"
 )
@@ -26,37 +28,51 @@ type Block struct {
 	Transactions     []Transaction
 }
 
-func (b *Block) Hash() []byte {
+//80 byte block header, the part of the block that is hashed
+func (b *Block) SerializeHeader() []uint8 {
 	var d []uint8
-	//d = append(d, b.magicid[:]...)
-	//d = append(d, b.blocklength[:]...)
 	d = append(d, b.VersionNumber[:]...)
 	d = append(d, b.PreviousHash[:]...)
 	d = append(d, b.MerkleRoot[:]...)
 	d = append(d, b.TimeStamp[:]...)
 	d = append(d, b.TargetDifficulty[:]...)
 	d = append(d, b.Nonce[:]...)
-	h := sha256.New()
-	h.Reset()
-	if _, err := h.Write(d); err != nil {
-		return []byte{}
-	}
-	tmp := h.Sum(nil)
-	h.Reset()
-	if _, err := h.Write(tmp); err != nil {
-		return []byte{}
+	return d
+}
+
+//Block as it is stored on disk: MagicID, block length, header and transactions.
+//The length is recomputed so a block with filtered transactions is still framed correctly.
+func (b *Block) Serialize() []uint8 {
+	return b.frame(b.encode(true))
+}
+
+//Same as Serialize but every transaction is written without its witness data
+func (b *Block) SerializeNoWitness() []uint8 {
+	return b.frame(b.encode(false))
+}
+
+func (b *Block) encode(witness bool) []uint8 {
+	d := b.SerializeHeader()
+	d = append(d, utils.CompactSize(len(b.Transactions)).Bytes()...)
+	for i := range b.Transactions {
+		d = append(d, b.Transactions[i].encode(witness)...)
 	}
-	return h.Sum(nil)
+	return d
+}
+
+func (b *Block) frame(payload []uint8) []uint8 {
+	d := make([]uint8, 8, 8+len(payload))
+	copy(d, b.MagicID[:])
+	binary.LittleEndian.PutUint32(d[4:], uint32(len(payload)))
+	return append(d, payload...)
+}
+
+func (b *Block) Hash() []byte {
+	return hashes.Hash256(b.SerializeHeader())
 }
 
 func (b *Block) HashString() string {
-	var temp []byte
-	hash := b.Hash()
-	//Not sure how else to convert little endian to string.
-	for i := 0; i < len(hash); i++ {
-		temp = append([]byte{hash[i]}, temp...)
-	}
-	return fmt.Sprintf("%x", temp[:])
+	return hashes.String(b.Hash())
 }
 
 func (b *Block) MagicIDVal() uint32 {
@@ -87,21 +103,11 @@ func (b *Block) VersionNumberVal() uint32 {
 }
 
 func (b *Block) PreviousHashString() string {
-	var temp []byte
-	//Not sure how else to convert little endian to string.
-	for i := 0; i < cap(b.PreviousHash); i++ {
-		temp = append([]byte{b.PreviousHash[i]}, temp...)
-	}
-	return fmt.Sprintf("%x", temp[:])
+	return hashes.String(b.PreviousHash[:])
 }
 
 func (b *Block) MerkleRootString() string {
-	var temp []byte
-	//Not sure how else to convert little endian to string.
-	for i := 0; i < cap(b.MerkleRoot); i++ {
-		temp = append([]byte{b.MerkleRoot[i]}, temp...)
-	}
-	return fmt.Sprintf("%x", temp[:])
+	return hashes.String(b.MerkleRoot[:])
 }
 
 func (b *Block) TimeStampVal() uint32 {
@@ -142,6 +148,56 @@ func (b *Block) NonceVal() uint32 {
 func (b *Block) TransactionCountVal() int {
 	return utils.VarInt(b.TransactionCount)
 }
+
+//Transaction IDs in block order, still in little endian
+func (b *Block) TransactionHashes() [][]byte {
+	var hashes [][]byte
+	for i := range b.Transactions {
+		hashes = append(hashes, b.Transactions[i].Hash())
+	}
+	return hashes
+}
+
+//Number of outputs of each script type in the block
+func (b *Block) ScriptTypeStats() map[script.ScriptClass]int {
+	stats := make(map[script.ScriptClass]int)
+	for i := range b.Transactions {
+		for n := range b.Transactions[i].Outputs {
+			stats[b.Transactions[i].Outputs[n].ScriptType()]++
+		}
+	}
+	return stats
+}
+
+//Recomputes the merkle root from the decoded transactions and compares it to the header.
+//Blocks with a mutated merkle tree, see MerkleRootMutated, never verify
+func (b *Block) VerifyMerkleRoot() bool {
+	if len(b.Transactions) != b.TransactionCountVal() {
+		return false
+	}
+	root, mutated, err := merkle.RootMutated(b.TransactionHashes())
+	if err != nil || mutated {
+		return false
+	}
+	return bytes.Equal(root, b.MerkleRoot[:])
+}
+
+//Reports whether the transactions repeat a subtree of the merkle tree (CVE-2012-2459).
+//Such a block matches the merkle root of the block without the repeats but is invalid.
+func (b *Block) MerkleRootMutated() bool {
+	_, mutated, _ := merkle.RootMutated(b.TransactionHashes())
+	return mutated
+}
+
+//Builds the merkle inclusion proof for the transaction with the given txid
+func (b *Block) MerkleProof(txid string) (merkle.Proof, error) {
+	for i := range b.Transactions {
+		if b.Transactions[i].HashString() == txid {
+			return merkle.BuildProof(b.TransactionHashes(), i)
+		}
+	}
+	return merkle.Proof{}, ErrNotFound
+}
 func (b *Block) PrintBlockInfo() string {
 	blockOutputLog := []string{}
 
@@ -186,6 +242,24 @@ func (b *Block) PrintBlockInfo() string {
 			blockOutputLog,
 			fmt.Sprintf("\tTransaction Hash: %v\n", t.HashString()),
 		)
+		if t.HasWitness() {
+			blockOutputLog = append(
+				blockOutputLog,
+				fmt.Sprintf("\tWitness Hash: %v\n", t.WitnessHashString()),
+			)
+		}
+		for n, in := range t.Inputs {
+			blockOutputLog = append(
+				blockOutputLog,
+				fmt.Sprintf("\tInput %v Script: %v\n", n, in.ScriptAsm()),
+			)
+		}
+		for n, out := range t.Outputs {
+			blockOutputLog = append(
+				blockOutputLog,
+				fmt.Sprintf("\tOutput %v Value: %v Script: %v\n", n, out.Value(), out.ScriptAsm()),
+			)
+		}
 	}
 
 	blockOutputLog = append(
@@ -0,0 +1,151 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/merkle"
+)
+
+//testdata/bootstrap.dat holds the mainnet genesis block followed by a synthetic block
+//(b17bd0ef…) that isn't on any chain: its transactions are the first bitcoin transfer,
+//taken from block 170, and the signed BIP143 P2WPKH example
+func readBootstrap(t *testing.T) [][]byte {
+	t.Helper()
+	f, err := os.Open("testdata/bootstrap.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var frames [][]byte
+	fr := NewFramer(f, nil)
+	for {
+		frame, err := fr.Next()
+		if err == io.EOF {
+			return frames
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, append([]byte{}, frame...))
+		ReleaseFrame(frame)
+	}
+}
+
+func TestBlockSerializeRoundTrip(t *testing.T) {
+	frames := readBootstrap(t)
+	if len(frames) != 2 {
+		t.Fatalf("got %v blocks", len(frames))
+	}
+	hashes := []string{
+		"000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+		"b17bd0ef3f6e01ffbaf12651b7651e30581754e678ff431a17c69148b2489ae8",
+	}
+	for i, frame := range frames {
+		b, err := NewBlockParser(bytes.NewReader(frame), nil, nil).Decode(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := b.HashString(); got != hashes[i] {
+			t.Errorf("block %v: hash %v", i, got)
+		}
+		if !b.VerifyMerkleRoot() {
+			t.Errorf("block %v: merkle root mismatch", i)
+		}
+		if !bytes.Equal(b.Serialize(), frame) {
+			t.Errorf("block %v: Serialize differs from the file", i)
+		}
+		for n := range b.Transactions {
+			tx := &b.Transactions[n]
+			if !bytes.Equal(tx.Serialize(), tx.encode(true)) {
+				t.Errorf("block %v tx %v: Serialize differs from the witness encoding", i, n)
+			}
+			//Only a transaction with witness data has two different forms
+			if tx.HasWitness() {
+				if bytes.Equal(tx.SerializeNoWitness(), tx.Serialize()) {
+					t.Errorf("block %v tx %v: legacy form keeps the witness", i, n)
+				}
+			} else if !bytes.Equal(tx.SerializeNoWitness(), tx.Serialize()) {
+				t.Errorf("block %v tx %v: legacy form differs without a witness", i, n)
+			}
+		}
+
+		//Stripping witnesses keeps the txids and therefore the merkle root
+		stripped, err := NewBlockParser(bytes.NewReader(b.SerializeNoWitness()), nil, nil).Decode(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stripped.HashString() != hashes[i] || len(stripped.Transactions) != len(b.Transactions) {
+			t.Errorf("block %v: legacy form decodes to a different block", i)
+		}
+		for n := range stripped.Transactions {
+			if stripped.Transactions[n].HasWitness() {
+				t.Errorf("block %v tx %v: witness left in legacy form", i, n)
+			}
+		}
+	}
+}
+
+func TestSerializeRecomputesCounts(t *testing.T) {
+	frames := readBootstrap(t)
+	b, err := NewBlockParser(bytes.NewReader(frames[1]), nil, nil).Decode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Drop a transaction and an output, the counts and length must follow
+	b.Transactions = b.Transactions[1:]
+	b.Transactions[0].Outputs = b.Transactions[0].Outputs[:1]
+	again, err := NewBlockParser(bytes.NewReader(b.Serialize()), nil, nil).Decode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Transactions) != 1 || len(again.Transactions[0].Outputs) != 1 || len(again.Transactions[0].Inputs) != 2 {
+		t.Fatalf("decoded %v transactions", len(again.Transactions))
+	}
+	if int(again.BlockLengthVal()) != len(b.Serialize())-8 {
+		t.Errorf("length field %v", again.BlockLengthVal())
+	}
+	if !bytes.Equal(again.Transactions[0].Serialize(), b.Transactions[0].Serialize()) {
+		t.Error("filtered transaction doesn't round trip")
+	}
+}
+
+func TestVerifyMerkleRootMutated(t *testing.T) {
+	frames := readBootstrap(t)
+	b, err := NewBlockParser(bytes.NewReader(frames[1]), nil, nil).Decode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := NewBlockParser(bytes.NewReader(frames[0]), nil, nil).Decode(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Three transactions with a matching root
+	b.Transactions = append(b.Transactions, genesis.Transactions[0])
+	b, err = NewBlockParser(bytes.NewReader(b.Serialize()), nil, nil).Decode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := merkle.Root(b.TransactionHashes())
+	copy(b.MerkleRoot[:], root)
+	if !b.VerifyMerkleRoot() || b.MerkleRootMutated() {
+		t.Fatal("three transaction block doesn't verify")
+	}
+
+	//Repeating the last transaction keeps the root, CVE-2012-2459
+	b.Transactions = append(b.Transactions, b.Transactions[2])
+	b, err = NewBlockParser(bytes.NewReader(b.Serialize()), nil, nil).Decode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := merkle.Root(b.TransactionHashes()); !bytes.Equal(r, root) {
+		t.Fatal("duplicate changed the root")
+	}
+	if !b.MerkleRootMutated() || b.VerifyMerkleRoot() {
+		t.Error("mutated block verifies")
+	}
+}
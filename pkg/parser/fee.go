@@ -0,0 +1,158 @@
+package parser
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/lirancohen/blockparser/pkg/hashes"
+	"github.com/lirancohen/blockparser/pkg/index"
+	"github.com/lirancohen/blockparser/pkg/utils"
+)
+
+var ErrCoinbase = errors.New("coinbase transactions don't spend outputs")
+
+//Resolves the output an input spends
+type PrevOutFetcher interface {
+	FetchPrevOut(o index.OutPoint) (TransOutput, error)
+}
+
+//Builds an output from its value and scriptPubKey, for fetchers that don't keep whole transactions
+func NewTransOutput(value uint64, script []uint8) TransOutput {
+	return TransOutput{
+		value:        value,
+		scriptlength: utils.CompactSize(len(script)).Bytes(),
+		script:       script,
+	}
+}
+
+//A coinbase has a single input spending the null outpoint
+func (t *Transaction) IsCoinBase() bool {
+	return len(t.Inputs) == 1 && t.Inputs[0].OutPoint().IsNull()
+}
+
+//Sum of the values of the outputs spent by the inputs
+func (t *Transaction) InputValue(f PrevOutFetcher) (uint64, error) {
+	if t.IsCoinBase() {
+		return 0, ErrCoinbase
+	}
+	var total uint64
+	for i := range t.Inputs {
+		out, err := f.FetchPrevOut(t.Inputs[i].OutPoint())
+		if err != nil {
+			return 0, err
+		}
+		total += out.Value()
+	}
+	return total, nil
+}
+
+func (t *Transaction) OutputValue() uint64 {
+	var total uint64
+	for i := range t.Outputs {
+		total += t.Outputs[i].Value()
+	}
+	return total
+}
+
+//Input value minus output value, 0 for a coinbase
+func (t *Transaction) Fee(f PrevOutFetcher) (uint64, error) {
+	if t.IsCoinBase() {
+		return 0, nil
+	}
+	in, err := t.InputValue(f)
+	if err != nil {
+		return 0, err
+	}
+	out := t.OutputValue()
+	if out > in {
+		return 0, errors.New("transaction spends more than its inputs")
+	}
+	return in - out, nil
+}
+
+//BIP141 weight: the stripped size counts four times, witness data once
+func (t *Transaction) Weight() int {
+	return len(t.SerializeNoWitness())*3 + len(t.Serialize())
+}
+
+//Weight divided by four, rounded up
+func (t *Transaction) VirtualSize() int {
+	return (t.Weight() + 3) / 4
+}
+
+//Fee in satoshis per virtual byte
+func (t *Transaction) FeeRate(f PrevOutFetcher) (float64, error) {
+	fee, err := t.Fee(f)
+	if err != nil {
+		return 0, err
+	}
+	return float64(fee) / float64(t.VirtualSize()), nil
+}
+
+//Block weight, header and transaction count included
+func (b *Block) Weight() int {
+	stripped := len(b.SerializeNoWitness()) - 8
+	total := len(b.Serialize()) - 8
+	return stripped*3 + total
+}
+
+//Fee statistics of a block, the coinbase is left out of every figure
+type FeeSummary struct {
+	Height       int
+	Transactions int
+	TotalFees    uint64
+	TotalVSize   int
+	//Fee rates in sat/vB
+	MinFeeRate     float64
+	MaxFeeRate     float64
+	MedianFeeRate  float64
+	AverageFeeRate float64
+}
+
+//Computes the fee of every transaction in the block. f must resolve outputs as they
+//were before the block, outputs created earlier in the same block included.
+func (b *Block) FeeSummary(f PrevOutFetcher) (FeeSummary, error) {
+	s := FeeSummary{Height: b.Height}
+	var rates []float64
+	for i := range b.Transactions {
+		t := &b.Transactions[i]
+		if t.IsCoinBase() {
+			continue
+		}
+		fee, err := t.Fee(f)
+		if err != nil {
+			return s, err
+		}
+		vsize := t.VirtualSize()
+		s.Transactions++
+		s.TotalFees += fee
+		s.TotalVSize += vsize
+		rates = append(rates, float64(fee)/float64(vsize))
+	}
+	if len(rates) == 0 {
+		return s, nil
+	}
+
+	sort.Float64s(rates)
+	s.MinFeeRate = rates[0]
+	s.MaxFeeRate = rates[len(rates)-1]
+	if len(rates)%2 == 1 {
+		s.MedianFeeRate = rates[len(rates)/2]
+	} else {
+		s.MedianFeeRate = (rates[len(rates)/2-1] + rates[len(rates)/2]) / 2
+	}
+	s.AverageFeeRate = float64(s.TotalFees) / float64(s.TotalVSize)
+	return s, nil
+}
+
+//Resolves previous outputs through the transaction index
+func (s *Stream) FetchPrevOut(o index.OutPoint) (TransOutput, error) {
+	t, err := s.LookupTransaction(hashes.String(o.Hash[:]))
+	if err != nil {
+		return TransOutput{}, err
+	}
+	if int(o.Index) >= len(t.Outputs) {
+		return TransOutput{}, ErrNotFound
+	}
+	return t.Outputs[o.Index], nil
+}
@@ -0,0 +1,119 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/index"
+)
+
+var errMissingPrevOut = errors.New("missing previous output")
+
+type prevOutMap map[index.OutPoint]TransOutput
+
+func (m prevOutMap) FetchPrevOut(o index.OutPoint) (TransOutput, error) {
+	out, ok := m[o]
+	if !ok {
+		return TransOutput{}, errMissingPrevOut
+	}
+	return out, nil
+}
+
+//Outputs spent by bip143SignedTx: 6.25 BTC to P2PK and 6 BTC to P2WPKH
+func bip143PrevOuts(tx *Transaction) prevOutMap {
+	p2pk, _ := hex.DecodeString("2103c9f4836b9a4f77fc0d81f7bcb01b7f1b35916864b9476c241ce9fc198bd25432ac")
+	p2wpkh, _ := hex.DecodeString("00141d0f172a0ecb48aee1be1f2687d2963ae33f71a1")
+	return prevOutMap{
+		tx.Inputs[0].OutPoint(): NewTransOutput(625000000, p2pk),
+		tx.Inputs[1].OutPoint(): NewTransOutput(600000000, p2wpkh),
+	}
+}
+
+func TestWeightBIP143(t *testing.T) {
+	tx, err := decodeTx(t, bip143SignedTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//343 bytes, 110 of them marker, flag and witness
+	if w, v := tx.Weight(), tx.VirtualSize(); w != 233*3+343 || v != 261 {
+		t.Errorf("weight %v, vsize %v", w, v)
+	}
+
+	//Without witness data every byte weighs four
+	legacy, err := decodeTx(t, hex.EncodeToString(tx.SerializeNoWitness()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, v := legacy.Weight(), legacy.VirtualSize(); w != 233*4 || v != 233 {
+		t.Errorf("stripped: weight %v, vsize %v", w, v)
+	}
+}
+
+func TestFeeBIP143(t *testing.T) {
+	tx, err := decodeTx(t, bip143SignedTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := bip143PrevOuts(&tx)
+
+	if in, err := tx.InputValue(prev); err != nil || in != 1225000000 {
+		t.Errorf("input value %v, %v", in, err)
+	}
+	if out := tx.OutputValue(); out != 112340000+223450000 {
+		t.Errorf("output value %v", out)
+	}
+	const fee = 1225000000 - 112340000 - 223450000
+	if got, err := tx.Fee(prev); err != nil || got != fee {
+		t.Errorf("fee %v, %v", got, err)
+	}
+	if rate, err := tx.FeeRate(prev); err != nil || rate != float64(fee)/261 {
+		t.Errorf("fee rate %v, %v", rate, err)
+	}
+
+	//Outputs worth more than the inputs
+	for o, out := range prev {
+		prev[o] = NewTransOutput(1, out.Script())
+	}
+	if got, err := tx.Fee(prev); err == nil {
+		t.Errorf("negative fee reported as %v", got)
+	}
+}
+
+func TestFeeMissingPrevOut(t *testing.T) {
+	tx, err := decodeTx(t, bip143SignedTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := bip143PrevOuts(&tx)
+	delete(prev, tx.Inputs[1].OutPoint())
+
+	if _, err := tx.InputValue(prev); err != errMissingPrevOut {
+		t.Errorf("input value: %v", err)
+	}
+	if _, err := tx.Fee(prev); err != errMissingPrevOut {
+		t.Errorf("fee: %v", err)
+	}
+	if _, err := tx.FeeRate(prev); err != errMissingPrevOut {
+		t.Errorf("fee rate: %v", err)
+	}
+}
+
+func TestFeeCoinbase(t *testing.T) {
+	frames := readBootstrap(t)
+	b, err := NewBlockParser(bytes.NewReader(frames[0]), nil, nil).Decode(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := &b.Transactions[0]
+	if !cb.IsCoinBase() {
+		t.Fatal("first transaction isn't a coinbase")
+	}
+	if fee, err := cb.Fee(prevOutMap{}); err != nil || fee != 0 {
+		t.Errorf("fee %v, %v", fee, err)
+	}
+	if _, err := cb.InputValue(prevOutMap{}); err != ErrCoinbase {
+		t.Errorf("input value: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/lirancohen/blockparser/pkg/network"
+)
+
+//Largest block a frame may announce, anything above is taken as corruption rather than allocated
+const MaxFrameSize = 4000000
+
+var ErrFrameSize = errors.New("block length exceeds the maximum block size")
+
+//Frame buffers handed out by Framer.Next, returned with ReleaseFrame
+var framePool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 1<<20)
+		return &b
+	},
+}
+
+//Empty slice headers framePool entries are put back in, so releasing a frame doesn't allocate
+var frameHolders = sync.Pool{
+	New: func() interface{} {
+		return new([]byte)
+	},
+}
+
+//Splits a stream of framed blocks, MagicID, length and block as found in blk*.dat and chunk files.
+//Bytes up to the next MagicID are skipped.
+type Framer struct {
+	r     *bufio.Reader
+	magic []byte
+	//Bytes consumed from r so far
+	offset int64
+	//Where the last frame returned by Next starts
+	start int64
+}
+
+//Frames blocks from r, which is read directly when it is already a *bufio.Reader.
+//net is the network the blocks belong to, mainnet when nil.
+func NewFramer(r io.Reader, net *network.Network) *Framer {
+	if net == nil {
+		net = network.MainNet
+	}
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReaderSize(r, 1<<20)
+	}
+	return &Framer{r: br, magic: net.Magic[:]}
+}
+
+//Returns the next framed block, MagicID and length included, io.EOF when no more blocks start.
+//The slice comes from a pool, pass it to ReleaseFrame once it is no longer used.
+func (f *Framer) Next() ([]byte, error) {
+	if err := f.skipToMagic(); err != nil {
+		return nil, err
+	}
+	f.start = f.offset
+
+	header, err := f.r.Peek(8)
+	if err != nil {
+		f.offset += int64(len(header))
+		f.r.Discard(len(header))
+		return nil, io.ErrUnexpectedEOF
+	}
+	size := binary.LittleEndian.Uint32(header[4:])
+	if size > MaxFrameSize {
+		//Only the MagicID is consumed so the search resumes right after it
+		f.r.Discard(len(f.magic))
+		f.offset += int64(len(f.magic))
+		return nil, ErrFrameSize
+	}
+
+	frame := getFrame(8 + int(size))
+	n, err := io.ReadFull(f.r, frame)
+	f.offset += int64(n)
+	if err != nil {
+		ReleaseFrame(frame)
+		return nil, io.ErrUnexpectedEOF
+	}
+	return frame, nil
+}
+
+//Offset from the start of the reader of the last frame Next returned
+func (f *Framer) Offset() int64 {
+	return f.start
+}
+
+//Advances to the next MagicID without consuming it
+func (f *Framer) skipToMagic() error {
+	for {
+		buf, err := f.r.Peek(len(f.magic))
+		if len(buf) < len(f.magic) {
+			f.offset += int64(len(buf))
+			f.r.Discard(len(buf))
+			return err
+		}
+		if bytes.Equal(buf, f.magic) {
+			return nil
+		}
+
+		//Jump straight to the next byte that could start a MagicID
+		avail := f.r.Buffered()
+		window, _ := f.r.Peek(avail)
+		skip := len(window)
+		if i := bytes.IndexByte(window[1:], f.magic[0]); i >= 0 {
+			skip = i + 1
+		}
+		f.r.Discard(skip)
+		f.offset += int64(skip)
+	}
+}
+
+func getFrame(n int) []byte {
+	p := framePool.Get().(*[]byte)
+	if cap(*p) < n {
+		framePool.Put(p)
+		return make([]byte, n)
+	}
+	frame := (*p)[:n]
+	*p = nil
+	frameHolders.Put(p)
+	return frame
+}
+
+//Hands a frame returned by Framer.Next back to the pool, it must not be used afterwards
+func ReleaseFrame(frame []byte) {
+	if frame == nil {
+		return
+	}
+	p := frameHolders.Get().(*[]byte)
+	*p = frame[:0]
+	framePool.Put(p)
+}
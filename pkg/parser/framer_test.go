@@ -0,0 +1,178 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/network"
+)
+
+//Endless reader cycling over data
+type loopReader struct {
+	data []byte
+	pos  int
+}
+
+func (l *loopReader) Read(p []byte) (int, error) {
+	n := copy(p, l.data[l.pos:])
+	l.pos = (l.pos + n) % len(l.data)
+	return n, nil
+}
+
+//Mainnet sized frames with random payloads and some padding between them
+func benchFrames() []byte {
+	rnd := rand.New(rand.NewSource(1))
+	var data []byte
+	for _, size := range []int{1000000, 250000, 1500000, 40000} {
+		data = append(data, network.MainNet.Magic[:]...)
+		data = binary.LittleEndian.AppendUint32(data, uint32(size))
+		payload := make([]byte, size)
+		rnd.Read(payload)
+		data = append(data, payload...)
+		data = append(data, make([]byte, 16)...)
+	}
+	return data
+}
+
+//The loop Framer replaced: the frame is read a byte at a time into a slice grown by append
+func readByteFrame(r *bufio.Reader, magic []byte) ([]byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != magic[0] {
+			continue
+		}
+		m := []byte{b}
+		for i := 0; i < 3; i++ {
+			if b, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+			m = append(m, b)
+		}
+		if !bytes.Equal(m, magic) {
+			continue
+		}
+		var size []byte
+		for i := 0; i < 4; i++ {
+			if b, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+			size = append(size, b)
+		}
+		block := append(append([]byte{}, m...), size...)
+		for i := 0; i < int(binary.LittleEndian.Uint32(size)); i++ {
+			if b, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+			block = append(block, b)
+		}
+		return block, nil
+	}
+}
+
+func TestFramerMatchesReadByte(t *testing.T) {
+	data := benchFrames()
+	fr := NewFramer(bytes.NewReader(data), nil)
+	old := bufio.NewReader(bytes.NewReader(data))
+	for n := 0; ; n++ {
+		frame, err := fr.Next()
+		want, oldErr := readByteFrame(old, network.MainNet.Magic[:])
+		if err == io.EOF && oldErr == io.EOF {
+			if n != 4 {
+				t.Fatalf("framed %v blocks", n)
+			}
+			return
+		}
+		if err != nil || oldErr != nil {
+			t.Fatalf("frame %v: %v, %v", n, err, oldErr)
+		}
+		if !bytes.Equal(frame, want) {
+			t.Fatalf("frame %v differs", n)
+		}
+		ReleaseFrame(frame)
+	}
+}
+
+//Frames b.N blocks, about 700KB each on average, so -benchtime=5000x goes through several GB.
+//With BLOCKPARSER_BENCH_FILE set to a blk*.dat or chunk file, each iteration frames the whole file instead.
+func BenchmarkFramer(b *testing.B) {
+	path := os.Getenv("BLOCKPARSER_BENCH_FILE")
+	data := benchFrames()
+	perFrame := int64(len(data) / 4)
+
+	b.Run("Framer", func(b *testing.B) {
+		if path != "" {
+			benchFile(b, path, func(r io.Reader) error {
+				fr := NewFramer(r, nil)
+				for {
+					frame, err := fr.Next()
+					if err != nil {
+						return err
+					}
+					ReleaseFrame(frame)
+				}
+			})
+			return
+		}
+		b.SetBytes(perFrame)
+		b.ReportAllocs()
+		fr := NewFramer(&loopReader{data: data}, nil)
+		for i := 0; i < b.N; i++ {
+			frame, err := fr.Next()
+			if err != nil {
+				b.Fatal(err)
+			}
+			ReleaseFrame(frame)
+		}
+	})
+
+	b.Run("ReadByte", func(b *testing.B) {
+		if path != "" {
+			benchFile(b, path, func(r io.Reader) error {
+				br := bufio.NewReader(r)
+				for {
+					if _, err := readByteFrame(br, network.MainNet.Magic[:]); err != nil {
+						return err
+					}
+				}
+			})
+			return
+		}
+		b.SetBytes(perFrame)
+		b.ReportAllocs()
+		br := bufio.NewReader(&loopReader{data: data})
+		for i := 0; i < b.N; i++ {
+			if _, err := readByteFrame(br, network.MainNet.Magic[:]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+//Runs frame over the whole file at path once per iteration
+func benchFile(b *testing.B, path string, frame func(r io.Reader) error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(info.Size())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = frame(f)
+		f.Close()
+		if err != io.EOF {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package parser
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/lirancohen/blockparser/pkg/index"
+	"github.com/lirancohen/blockparser/pkg/network"
+)
+
+//Walks blocks in height order, moving from chunk to chunk as needed
+type BlockIterator struct {
+	//Block locations written by the chunker, lets the iterator start mid chunk without scanning
+	Index *index.BlockIndex
+	net   *network.Network
+	//Chunk currently being read, nil between chunks
+	stream *Stream
+	//Height of the block Next returns
+	next int
+	//Last height to return, negative to run to the tip
+	end  int
+	done bool
+}
+
+//Iterates from height start through end, a negative end runs to the last chunked block.
+//net is the network the chunks belong to, mainnet when nil.
+func NewBlockIterator(net *network.Network, start, end int) *BlockIterator {
+	if net == nil {
+		net = network.MainNet
+	}
+	if start < 0 {
+		start = 0
+	}
+	return &BlockIterator{net: net, next: start, end: end}
+}
+
+//Height of the block the next call to Next returns
+func (it *BlockIterator) Height() int {
+	return it.next
+}
+
+//Returns the next block, io.EOF past the end height or the last chunked block.
+//Once Next returns io.EOF it keeps doing so, ctx errors are returned as they are.
+//A block that fails to decode is skipped, the following call returns the one after it.
+func (it *BlockIterator) Next(ctx context.Context) (*Block, error) {
+	if err := ctx.Err(); err != nil {
+		return &Block{}, err
+	}
+	if it.done || (it.end >= 0 && it.next > it.end) {
+		return &Block{}, io.EOF
+	}
+
+	if it.stream == nil {
+		if err := it.open(it.next); err == ErrNoChunk {
+			it.done = true
+			return &Block{}, io.EOF
+		} else if err != nil {
+			return &Block{}, err
+		}
+	}
+
+	b, err := it.stream.ReadBlock()
+	if err == io.EOF {
+		ceiling := it.stream.Ceiling
+		it.stream.Close()
+		it.stream = nil
+		//The last chunk ends before its range does, that's the tip
+		if it.next <= ceiling {
+			it.done = true
+			return &Block{}, io.EOF
+		}
+		return it.Next(ctx)
+	}
+	//A block that fails to decode has still been read, Height moves past it along with the chunk
+	it.next = it.stream.Floor + it.stream.position
+	return b, err
+}
+
+//Opens the chunk holding height and positions it on that block
+func (it *BlockIterator) open(height int) error {
+	s, err := SeekChunk(it.net, height)
+	if err != nil {
+		return err
+	}
+	s.Index = it.Index
+
+	if it.Index != nil {
+		if loc, ok := it.Index.ByHeight(height); ok && loc.File == s.Filename {
+			if f, ok := s.closer.(*os.File); ok {
+				if _, err := f.Seek(loc.Offset, io.SeekStart); err == nil {
+					s.Stream.Reset(f)
+					s.position = height - s.Floor
+				}
+			}
+		}
+	}
+
+	//Without an index entry the blocks before height are framed and skipped
+	for s.Floor+s.position < height {
+		raw, err := s.readRawBlock()
+		if err == io.EOF {
+			s.Close()
+			return ErrNoChunk
+		} else if err != nil {
+			s.Close()
+			return err
+		}
+		ReleaseFrame(raw)
+		s.position++
+	}
+	it.stream = s
+	return nil
+}
+
+//Closes the chunk file being read
+func (it *BlockIterator) Close() error {
+	if it.stream == nil {
+		return nil
+	}
+	err := it.stream.Close()
+	it.stream = nil
+	return err
+}
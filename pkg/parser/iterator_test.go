@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/index"
+)
+
+//Writes chunks into data/chunks under a temporary working directory, restored when the test ends
+func withChunks(t *testing.T, chunks map[string][][]byte) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "data", "chunks"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for name, frames := range chunks {
+		var data []byte
+		for _, f := range frames {
+			data = append(data, f...)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "data", "chunks", name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(old) })
+}
+
+//Hashes of the blocks an iterator returns, in order, until io.EOF
+func iterate(t *testing.T, it *BlockIterator) []string {
+	t.Helper()
+	defer it.Close()
+	var got []string
+	for {
+		b, err := it.Next(context.Background())
+		if err == io.EOF {
+			if _, err := it.Next(context.Background()); err != io.EOF {
+				t.Fatalf("Next after io.EOF: %v", err)
+			}
+			return got
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if b.Height != it.Height()-1 {
+			t.Fatalf("block at %v, iterator at %v", b.Height, it.Height())
+		}
+		got = append(got, b.HashString())
+	}
+}
+
+func TestBlockIterator(t *testing.T) {
+	frames := readBootstrap(t)
+	genesis, second := "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f", "b17bd0ef3f6e01ffbaf12651b7651e30581754e678ff431a17c69148b2489ae8"
+	//Heights 0-2 in a full chunk, 3 and 4 in the chunk still being written
+	withChunks(t, map[string][][]byte{
+		"0_2.dat":           {frames[0], frames[1], frames[0]},
+		"3_5.dat.5.current": {frames[1], frames[0]},
+	})
+
+	cases := []struct {
+		start, end int
+		want       []string
+	}{
+		{0, -1, []string{genesis, second, genesis, second, genesis}},
+		{2, 3, []string{genesis, second}},
+		{4, -1, []string{genesis}},
+		{1, 1, []string{second}},
+		{5, -1, nil},
+		{9, -1, nil},
+	}
+	for _, c := range cases {
+		got := iterate(t, NewBlockIterator(nil, c.start, c.end))
+		if len(got) != len(c.want) {
+			t.Errorf("%v-%v: %v blocks", c.start, c.end, len(got))
+			continue
+		}
+		for n := range got {
+			if got[n] != c.want[n] {
+				t.Errorf("%v-%v: block %v is %v", c.start, c.end, n, got[n])
+			}
+		}
+	}
+
+	//Starting from the block index seeks straight to the block
+	idx := index.NewBlockIndex()
+	idx.Add(index.BlockLocation{Height: 4, File: "3_5.dat.5.current", Offset: int64(len(frames[1])), Length: uint32(len(frames[0]))})
+	it := NewBlockIterator(nil, 4, -1)
+	it.Index = idx
+	if got := iterate(t, it); len(got) != 1 || got[0] != genesis {
+		t.Errorf("indexed start: %v", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewBlockIterator(nil, 0, -1).Next(ctx); err != context.Canceled {
+		t.Errorf("cancelled: %v", err)
+	}
+}
+
+//A block that fails to decode is skipped without the heights drifting from the chunk
+func TestBlockIteratorDecodeError(t *testing.T) {
+	frames := readBootstrap(t)
+	broken := append([]byte{}, frames[1][:len(frames[1])-4]...)
+	binary.LittleEndian.PutUint32(broken[4:8], uint32(len(broken)-8))
+	withChunks(t, map[string][][]byte{
+		"0_3.dat": {frames[0], broken, frames[1], frames[0]},
+	})
+
+	it := NewBlockIterator(nil, 0, 2)
+	defer it.Close()
+	ctx := context.Background()
+	if b, err := it.Next(ctx); err != nil || b.Height != 0 {
+		t.Fatalf("block 0: %v", err)
+	}
+	if _, err := it.Next(ctx); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("block 1: %v", err)
+	}
+	if it.Height() != 2 {
+		t.Fatalf("iterator at %v after the broken block", it.Height())
+	}
+	b, err := it.Next(ctx)
+	if err != nil || b.Height != 2 || b.HashString() != "b17bd0ef3f6e01ffbaf12651b7651e30581754e678ff431a17c69148b2489ae8" {
+		t.Fatalf("block 2: %v %v", b.Height, err)
+	}
+	//The end height still stops at the right block
+	if _, err := it.Next(ctx); err != io.EOF {
+		t.Errorf("past the end: %v", err)
+	}
+}
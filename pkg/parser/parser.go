@@ -3,24 +3,45 @@ package parser
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"sync"
 	"strings"
+	"github.com/lirancohen/blockparser/pkg/network"
 	"github.com/lirancohen/blockparser/pkg/utils"
 )
 
+//A length or count read from the wire that no block could hold
+var ErrLengthTooLarge = errors.New("length exceeds the maximum block size")
+
+//BIP144 only defines the 0x01 flag
+var ErrUnknownTxFlag = errors.New("unknown transaction optional data")
+
+//Witness serialization with no witness data, the legacy serialization must be used instead
+var ErrSuperfluousWitness = errors.New("superfluous witness record")
 
 type BlockParser struct {
 	*bufio.Reader
 	wg *sync.WaitGroup
+	net *network.Network
 }
 
-func NewBlockParser(r io.Reader, wg *sync.WaitGroup) *BlockParser {
+//Decodes from r, which is read directly when it is already a *bufio.Reader.
+//net is the network blocks are expected to belong to, mainnet when nil
+func NewBlockParser(r io.Reader, wg *sync.WaitGroup, net *network.Network) *BlockParser {
+	if net == nil {
+		net = network.MainNet
+	}
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
 	return &BlockParser{
-		Reader: bufio.NewReader(r),
+		Reader: br,
 		wg: wg,
+		net: net,
 	}
 }
 
@@ -30,6 +51,9 @@ func (w *BlockParser) Decode(height int) (*Block, error) {
 	if err := binary.Read(w, binary.LittleEndian, &block.MagicID); err != nil {
 		return &block, err
 	}
+	if block.MagicID != w.net.Magic {
+		return &block, ErrBadMagic
+	}
 
 	if err := binary.Read(w, binary.LittleEndian, &block.BlockLength); err != nil {
 		return &block, err
@@ -57,41 +81,27 @@ func (w *BlockParser) Decode(height int) (*Block, error) {
 		return &block, err
 	}
 
-	if b, err := w.ReadByte(); err != nil {
-		log.Printf("Transaction Count Read Error: %v\n", err)
-	} else {
-		var c int
-		block.TransactionCount = append(block.TransactionCount, b)
-		if uint8(b) == 253 {
-			c = 2
-		} else if uint8(b) == 254 {
-			c = 4
-		} else if uint8(b) == 255 {
-			c = 8
-		}
-		if c > 1 {
-			d := make([]uint8, c)
-			if err := binary.Read(w, binary.LittleEndian, &d); err != nil {
-				if err == io.EOF {
-					block.TransactionCount = make([]uint8, 0)
-					return &block, nil
-				}
-				log.Printf("Transaction Count Error: %v\n", err)
-			} else {
-				block.TransactionCount = append(block.TransactionCount, d...)
-			}
+	if c, err := w.readLength(); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			block.TransactionCount = make([]uint8, 0)
+			return &block, nil
 		}
+		log.Printf("Transaction Count Error: %v\n", err)
+		return &block, err
+	} else {
+		block.TransactionCount = c
 	}
 
 	for i := 0; i < block.TransactionCountVal(); i++ {
-		if t, err := w.DecodeTrans(); err == nil {
-			block.Transactions = append(block.Transactions, t)
-		} else if err != io.EOF {
-			log.Printf("Transaction Parse Error: %v\n", err)
-		} else if err == io.EOF {
-			log.Printf("Transaction EOF: %v\n", err)
-			return &block, err;
+		t, err := w.DecodeTrans()
+		if err != nil {
+			//The count promised more, so running out is a truncated block and not the end of the stream
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return &block, fmt.Errorf("transaction %v: %w", i, err)
 		}
+		block.Transactions = append(block.Transactions, t)
 	}
 	return &block, nil
 }
@@ -141,6 +151,24 @@ func (w *BlockParser) PrintBlockInfo(block Block) {
 			blockOutputLog,
 			fmt.Sprintf("\tTransaction Hash: %v\n", t.HashString()),
 		)
+		if t.HasWitness() {
+			blockOutputLog = append(
+				blockOutputLog,
+				fmt.Sprintf("\tWitness Hash: %v\n", t.WitnessHashString()),
+			)
+		}
+		for n, in := range t.Inputs {
+			blockOutputLog = append(
+				blockOutputLog,
+				fmt.Sprintf("\tInput %v Script: %v\n", n, in.ScriptAsm()),
+			)
+		}
+		for n, out := range t.Outputs {
+			blockOutputLog = append(
+				blockOutputLog,
+				fmt.Sprintf("\tOutput %v Value: %v Script: %v\n", n, out.Value(), out.ScriptAsm()),
+			)
+		}
 	}
 
 	blockOutputLog = append(
@@ -159,58 +187,41 @@ func (w *BlockParser) DecodeTrans() (Transaction, error) {
 		return trans, err
 	}
 
-	if b, err := w.ReadByte(); err != nil {
-		log.Printf("Input Transaction Count Read Error: %v\n", err)
-		return trans, err
-	} else {
-		var c int
-		trans.inputcount = append(trans.inputcount, b)
-		if uint8(b) == 253 {
-			c = 2
-		} else if uint8(b) == 254 {
-			c = 4
-		} else if uint8(b) == 255 {
-			c = 8
+	//BIP144: a 0x00 marker followed by a non zero flag replaces the input count of a witness transaction
+	if p, err := w.Peek(2); err == nil && p[0] == 0 && p[1] != 0 {
+		if p[1] != 1 {
+			log.Printf("Transaction Flag Error: %#x\n", p[1])
+			return trans, fmt.Errorf("%w: flag %#x", ErrUnknownTxFlag, p[1])
 		}
-		if c > 1 {
-			d := make([]uint8, c)
-			if err := binary.Read(w, binary.LittleEndian, &d); err != nil {
-				log.Printf("Transaction Input Count Error: %v\n", err)
-			} else {
-				trans.inputcount = append(trans.inputcount, b)
-			}
+		trans.marker = p[0]
+		trans.flag = p[1]
+		if _, err := w.Discard(2); err != nil {
+			log.Printf("Transaction Marker Read Error: %v\n", err)
+			return trans, err
 		}
 	}
 
+	if c, err := w.readLength(); err != nil {
+		log.Printf("Transaction Input Count Error: %v\n", err)
+		return trans, err
+	} else {
+		trans.inputcount = c
+	}
+
 	for i := 0; i < trans.InputCount(); i++ {
-		if input, err := w.DecodeInput(); err == nil {
-			trans.Inputs = append(trans.Inputs, input)
-		} else if err != io.EOF {
+		input, err := w.DecodeInput()
+		if err != nil {
 			log.Printf("Transaction Parse Error: %v\n", err)
+			return trans, err
 		}
+		trans.Inputs = append(trans.Inputs, input)
 	}
 
-	if b, err := w.ReadByte(); err != nil {
-		log.Printf("Output Transaction Count Read Error: %v\n", err)
+	if c, err := w.readLength(); err != nil {
+		log.Printf("Transaction Output Count Error: %v\n", err)
 		return trans, err
 	} else {
-		var c int
-		trans.outputcount = append(trans.outputcount, b)
-		if uint8(b) == 253 {
-			c = 2
-		} else if uint8(b) == 254 {
-			c = 4
-		} else if uint8(b) == 255 {
-			c = 8
-		}
-		if c > 1 {
-			d := make([]uint8, c)
-			if err := binary.Read(w, binary.LittleEndian, &d); err != nil {
-				log.Printf("Transaction Output Count Error: %v\n", err)
-			} else {
-				trans.outputcount = append(trans.outputcount, d...)
-			}
-		}
+		trans.outputcount = c
 	}
 
 	for i := 0; i < trans.OutputCount(); i++ {
@@ -225,8 +236,25 @@ func (w *BlockParser) DecodeTrans() (Transaction, error) {
 		}
 	}
 
+	if trans.HasWitness() {
+		empty := true
+		for i := range trans.Inputs {
+			if err := w.DecodeWitness(&trans.Inputs[i]); err != nil {
+				log.Printf("Transaction Witness Error: %v\n", err)
+				return trans, err
+			}
+			empty = empty && trans.Inputs[i].WitnessCount() == 0
+		}
+		//Otherwise the same transaction would have two serializations, and two wtxids
+		if empty {
+			log.Printf("Transaction Witness Error: %v\n", ErrSuperfluousWitness)
+			return trans, ErrSuperfluousWitness
+		}
+	}
+
 	if err := binary.Read(w, binary.LittleEndian, &trans.locktime); err != nil {
-		//log.Printf("Transaction Lock Time Error: %v\n", err)
+		log.Printf("Transaction Lock Time Error: %v\n", err)
+		return trans, err
 	}
 	return trans, nil
 }
@@ -244,33 +272,17 @@ func (w *BlockParser) DecodeInput() (TransInput, error) {
 	}
 	//log.Printf("\tInput Index: %v\n", input.Index())
 
-	if b, err := w.ReadByte(); err != nil {
-		log.Printf("Script Length Read Error: %v\n", err)
+	if c, err := w.readLength(); err != nil {
+		log.Printf("Input Script Length Error: %v\n", err)
 		return input, err
 	} else {
-		var c int
-		input.scriptlength = append(input.scriptlength, b)
-		if uint8(b) == 253 {
-			c = 2
-		} else if uint8(b) == 254 {
-			c = 4
-		} else if uint8(b) == 255 {
-			c = 8
-		}
-		if c > 1 {
-			d := make([]uint8, c)
-			if err := binary.Read(w, binary.LittleEndian, &d); err != nil {
-				log.Printf("Input Script Length Error: %v\n", err)
-			} else {
-				input.scriptlength = append(input.scriptlength, d...)
-			}
-		}
+		input.scriptlength = c
 		//log.Printf("\tScript Length: %v\n", input.ScriptLength())
 
-		for i := 0; i < input.ScriptLength(); i++ {
-			if b, err := w.ReadByte(); err == nil {
-				input.script = append(input.script, b)
-			}
+		input.script = make([]uint8, input.ScriptLength())
+		if _, err := io.ReadFull(w, input.script); err != nil {
+			log.Printf("Input Script Error: %v\n", err)
+			return input, err
 		}
 		//log.Printf("\tScript: %v\n", input.Script())
 	}
@@ -284,6 +296,40 @@ func (w *BlockParser) DecodeInput() (TransInput, error) {
 	return input, nil
 }
 
+func (w *BlockParser) DecodeWitness(input *TransInput) error {
+	b, err := w.readLength()
+	if err != nil {
+		return err
+	}
+	input.witnesscount = b
+
+	for i := 0; i < input.WitnessCount(); i++ {
+		item := WitnessItem{}
+		if item.length, err = w.readLength(); err != nil {
+			return err
+		}
+		item.data = make([]uint8, item.Length())
+		if _, err := io.ReadFull(w, item.data); err != nil {
+			return err
+		}
+		input.witness = append(input.witness, item)
+	}
+	return nil
+}
+
+//Reads a CompactSize length or count and returns it still encoded, prefix included.
+//Anything larger than a block is rejected, the value sizes allocations and loops
+func (w *BlockParser) readLength() ([]uint8, error) {
+	c, err := utils.ReadCompactSize(w)
+	if err != nil {
+		return nil, err
+	}
+	if c > MaxFrameSize {
+		return nil, ErrLengthTooLarge
+	}
+	return c.Bytes(), nil
+}
+
 func (w *BlockParser) DecodeOutput() (TransOutput, error) {
 	out := TransOutput{}
 	if err := binary.Read(w, binary.LittleEndian, &out.value); err != nil {
@@ -292,34 +338,18 @@ func (w *BlockParser) DecodeOutput() (TransOutput, error) {
 	}
 	//log.Printf("\tOutput Value: %v\n", out.value)
 
-	if b, err := w.ReadByte(); err != nil {
-		log.Printf("Script Length Read Error: %v\n", err)
+	if c, err := w.readLength(); err != nil {
+		log.Printf("Output Script Length Error: %v\n", err)
 		return out, err
 	} else {
-		var c int
-		out.scriptlength = append(out.scriptlength, b)
-		if uint8(b) == 253 {
-			c = 2
-		} else if uint8(b) == 254 {
-			c = 4
-		} else if uint8(b) == 255 {
-			c = 8
-		}
-		if c > 1 {
-			d := make([]uint8, c)
-			if err := binary.Read(w, binary.LittleEndian, &d); err != nil {
-				log.Printf("Input Script Length Error: %v\n", err)
-			} else {
-				out.scriptlength = append(out.scriptlength, d...)
-			}
-		}
+		out.scriptlength = c
 	}
 	//log.Printf("\tScript Length: %v\n", VarInt(out.scriptlength))
 
-	for i := 0; i < utils.VarInt(out.scriptlength); i++ {
-		if b, err := w.ReadByte(); err == nil {
-			out.script = append(out.script, b)
-		}
+	out.script = make([]uint8, out.ScriptLength())
+	if _, err := io.ReadFull(w, out.script); err != nil {
+		log.Printf("Output Script Error: %v\n", err)
+		return out, err
 	}
 	//log.Printf("\tScript: %v\n", out.script)
 
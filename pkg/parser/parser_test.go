@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+//Signed native P2WPKH example from BIP143, one legacy and one witness input
+const bip143SignedTx = "01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000"
+
+func decodeTx(t *testing.T, h string) (Transaction, error) {
+	t.Helper()
+	raw, err := hex.DecodeString(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewBlockParser(bytes.NewReader(raw), nil, nil).DecodeTrans()
+}
+
+func TestDecodeSegwitTransaction(t *testing.T) {
+	tx, err := decodeTx(t, bip143SignedTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tx.HasWitness() {
+		t.Fatal("marker and flag not detected")
+	}
+	if tx.InputCount() != 2 || tx.OutputCount() != 2 || tx.LockTime() != 17 {
+		t.Fatalf("got %v inputs, %v outputs, locktime %v", tx.InputCount(), tx.OutputCount(), tx.LockTime())
+	}
+	if n := len(tx.Inputs[0].Witness()); n != 0 {
+		t.Fatalf("legacy input has %v witness items", n)
+	}
+	w := tx.Inputs[1].Witness()
+	if len(w) != 2 || len(w[0]) != 71 || hex.EncodeToString(w[1]) != "025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee6357" {
+		t.Fatalf("bad witness stack %x", w)
+	}
+
+	if got := tx.HashString(); got != "e8151a2af31c368a35053ddd4bdb285a8595c769a3ad83e0fa02314a602d4609" {
+		t.Errorf("txid %v", got)
+	}
+	if got := tx.WitnessHashString(); got != "c36c38370907df2324d9ce9d149d191192f338b37665a82e78e76a12c909b762" {
+		t.Errorf("wtxid %v", got)
+	}
+	if got := hex.EncodeToString(tx.Serialize()); got != bip143SignedTx {
+		t.Errorf("round trip\n%v", got)
+	}
+}
+
+func TestDecodeWitnessLengthTooLarge(t *testing.T) {
+	prefix := "02000000" + "0001" + "01" + strings.Repeat("ab", 32) + "00000000" + "00" + "ffffffff" +
+		"01" + "e803000000000000" + "00"
+	cases := map[string]string{
+		"item count":                "ffffffffffffffffff",
+		"item length":               "01" + "ffffffffffffffffff",
+		"item length above a block": "01" + "fe01093d00",
+	}
+	for name, witness := range cases {
+		_, err := decodeTx(t, prefix+witness+"00000000")
+		if err != ErrLengthTooLarge {
+			t.Errorf("%v: got %v", name, err)
+		}
+	}
+}
+
+func TestDecodeScriptLengthTooLarge(t *testing.T) {
+	input := strings.Repeat("ab", 32) + "00000000"
+	cases := map[string]string{
+		"input count":          "01000000" + "ffffffffffffffffff",
+		"input script length":  "01000000" + "01" + input + "ffffffffffffffffff",
+		"output count":         "01000000" + "01" + input + "00" + "ffffffff" + "fe01093d00",
+		"output script length": "01000000" + "01" + input + "00" + "ffffffff" + "01" + "e803000000000000" + "ff0000000000000080",
+	}
+	for name, h := range cases {
+		if _, err := decodeTx(t, h); err != ErrLengthTooLarge {
+			t.Errorf("%v: got %v", name, err)
+		}
+	}
+}
+
+func TestDecodeWitnessFlag(t *testing.T) {
+	input := strings.Repeat("ab", 32) + "00000000" + "00" + "ffffffff"
+	output := "01" + "e803000000000000" + "0151"
+	cases := []struct {
+		name string
+		raw  string
+		want error
+	}{
+		{"flag 0x01", "02000000" + "0001" + "01" + input + output + "01" + "00" + "00000000", nil},
+		{"flag 0x02", "02000000" + "0002" + "01" + input + output + "01" + "00" + "00000000", ErrUnknownTxFlag},
+		{"flag 0x03", "02000000" + "0003" + "01" + input + output + "01" + "00" + "00000000", ErrUnknownTxFlag},
+		{"empty witness", "02000000" + "0001" + "01" + input + output + "00" + "00000000", ErrSuperfluousWitness},
+		{"two empty witnesses", "02000000" + "0001" + "02" + input + input + output + "00" + "00" + "00000000", ErrSuperfluousWitness},
+		{"one empty witness of two", "02000000" + "0001" + "02" + input + input + output + "00" + "0100" + "00000000", nil},
+	}
+	for _, c := range cases {
+		tx, err := decodeTx(t, c.raw)
+		if !errors.Is(err, c.want) {
+			t.Errorf("%v: got %v", c.name, err)
+		} else if err == nil && (!tx.HasWitness() || hex.EncodeToString(tx.Serialize()) != c.raw) {
+			t.Errorf("%v: round trip %x", c.name, tx.Serialize())
+		}
+	}
+}
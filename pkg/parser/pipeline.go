@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"runtime"
+	"sync"
+)
+
+//Block decoded by the pipeline, or the error that stopped it
+type Result struct {
+	Block *Block
+	Err   error
+}
+
+type decodeJob struct {
+	seq    int
+	height int
+	raw    []byte
+}
+
+type decodeResult struct {
+	seq int
+	Result
+}
+
+//Decodes the rest of the stream with workers goroutines, runtime.NumCPU() when workers <= 0,
+//and delivers the blocks in height order. At most 2*workers blocks are in flight, so a slow
+//consumer holds the readers back instead of piling blocks up in memory.
+//The channel is closed after the last block, after the first error, which is delivered
+//in order as a Result with Err set, or once ctx is done.
+//The stream must not be used by anything else until the channel is closed.
+func (s *Stream) DecodeBlocks(ctx context.Context, workers int) <-chan Result {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+
+	//A token per block between framing and delivery
+	tokens := make(chan struct{}, 2*workers)
+	jobs := make(chan decodeJob, workers)
+	results := make(chan decodeResult, workers)
+	out := make(chan Result)
+
+	//Framer: splits the stream into raw blocks
+	framerDone := make(chan struct{})
+	go func() {
+		defer close(framerDone)
+		defer close(jobs)
+		for seq := 0; ; seq++ {
+			select {
+			case tokens <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			raw, err := s.readRawBlock()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				//Goes through the sequencer so it is delivered after the blocks before it
+				select {
+				case results <- decodeResult{seq: seq, Result: Result{Err: err}}:
+				case <-ctx.Done():
+				}
+				return
+			}
+			job := decodeJob{seq: seq, height: s.Floor + s.position, raw: raw}
+			s.position++
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	//Workers: decode raw blocks
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			//Reused for every block, a new bufio.Reader per block would allocate its 4KB buffer each time
+			raw := bytes.NewReader(nil)
+			br := bufio.NewReader(raw)
+			for job := range jobs {
+				raw.Reset(job.raw)
+				br.Reset(raw)
+				b, err := NewBlockParser(br, nil, s.Network).Decode(job.height)
+				ReleaseFrame(job.raw)
+				select {
+				case results <- decodeResult{seq: job.seq, Result: Result{Block: b, Err: err}}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		<-framerDone
+		close(results)
+	}()
+
+	//Sequencer: holds results back until every earlier block has been delivered
+	go func() {
+		defer func() {
+			cancel()
+			//The stream is free again once the framer is gone
+			<-framerDone
+			close(out)
+		}()
+		pending := make(map[int]Result)
+		next := 0
+		for r := range results {
+			pending[r.seq] = r.Result
+			for {
+				res, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				select {
+				case out <- res:
+				case <-ctx.Done():
+					return
+				}
+				<-tokens
+				next++
+				if res.Err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
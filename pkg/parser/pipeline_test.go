@@ -0,0 +1,136 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestDecodeBlocksError(t *testing.T) {
+	frames := readBootstrap(t)
+
+	//Copies of the synthetic second fixture block broken inside a transaction, with the frame length kept consistent
+	truncated := append([]byte{}, frames[1][:len(frames[1])-4]...)
+	binary.LittleEndian.PutUint32(truncated[4:8], uint32(len(truncated)-8))
+	//Input count of the coinbase, right after MagicID, length, header, transaction count and version
+	huge := append([]byte{}, frames[1]...)
+	copy(huge[8+80+1+4:], []byte{0xfe, 0xff, 0xff, 0xff, 0xff})
+
+	cases := []struct {
+		name string
+		raw  []byte
+		want error
+	}{
+		{"missing lock time", truncated, io.ErrUnexpectedEOF},
+		{"huge input count", huge, ErrLengthTooLarge},
+	}
+	for _, c := range cases {
+		stream := bytes.Join([][]byte{frames[0], frames[1], c.raw, frames[0]}, nil)
+		for _, workers := range []int{1, 4} {
+			s := NewStream(nil, "0_3.dat", bytes.NewReader(stream), 0, 3)
+			var got []Result
+			for r := range s.DecodeBlocks(context.Background(), workers) {
+				got = append(got, r)
+			}
+			if len(got) != 3 || got[0].Err != nil || got[1].Err != nil || got[1].Block.Height != 1 {
+				t.Fatalf("%v, %v workers: got %v results", c.name, workers, len(got))
+			}
+			if !errors.Is(got[2].Err, c.want) {
+				t.Errorf("%v, %v workers: %v", c.name, workers, got[2].Err)
+			}
+
+			s = NewStream(nil, "0_3.dat", bytes.NewReader(stream), 0, 3)
+			s.Workers = workers
+			if n, err := s.Parse(0, 0); n != 2 || !errors.Is(err, c.want) {
+				t.Errorf("%v, %v workers: Parse decoded %v, %v", c.name, workers, n, err)
+			}
+		}
+	}
+}
+
+//Goroutine count settles back to base, or fails after a second
+func waitGoroutines(t *testing.T, base int) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if runtime.NumGoroutine() <= base {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("%v goroutines left running, %v before", runtime.NumGoroutine(), base)
+}
+
+func TestDecodeBlocksOrder(t *testing.T) {
+	frames := readBootstrap(t)
+	const count = 300
+	var stream []byte
+	for i := 0; i < count; i++ {
+		stream = append(stream, frames[i%2]...)
+	}
+	hashes := []string{
+		"000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+		"b17bd0ef3f6e01ffbaf12651b7651e30581754e678ff431a17c69148b2489ae8",
+	}
+
+	base := runtime.NumGoroutine()
+	for _, workers := range []int{1, 3, 16} {
+		s := NewStream(nil, "100_399.dat", bytes.NewReader(stream), 100, 399)
+		n := 0
+		for r := range s.DecodeBlocks(context.Background(), workers) {
+			if r.Err != nil {
+				t.Fatalf("%v workers: %v", workers, r.Err)
+			}
+			if r.Block.Height != 100+n || r.Block.HashString() != hashes[n%2] {
+				t.Fatalf("%v workers: result %v is block %v", workers, n, r.Block.Height)
+			}
+			n++
+		}
+		if n != count {
+			t.Errorf("%v workers: %v blocks", workers, n)
+		}
+	}
+	waitGoroutines(t, base)
+}
+
+func TestDecodeBlocksCancel(t *testing.T) {
+	frames := readBootstrap(t)
+	var stream []byte
+	for i := 0; i < 200; i++ {
+		stream = append(stream, frames[i%2]...)
+	}
+
+	base := runtime.NumGoroutine()
+	for _, drain := range []bool{true, false} {
+		for _, workers := range []int{1, 8} {
+			ctx, cancel := context.WithCancel(context.Background())
+			s := NewStream(nil, "0_199.dat", bytes.NewReader(stream), 0, 199)
+			out := s.DecodeBlocks(ctx, workers)
+			for i := 0; i < 5; i++ {
+				if r := <-out; r.Err != nil || r.Block.Height != i {
+					t.Fatalf("%v workers: block %v: %v", workers, i, r.Err)
+				}
+			}
+			cancel()
+			if drain {
+				//Blocks already decoded may still arrive, in order, before the channel closes
+				n := 5
+				for r := range out {
+					if r.Err == nil && r.Block.Height != n {
+						t.Fatalf("%v workers: block %v after cancel, want %v", workers, r.Block.Height, n)
+					}
+					n++
+				}
+				if n >= 200 {
+					t.Errorf("%v workers: cancel didn't stop decoding", workers)
+				}
+			}
+		}
+	}
+	//An abandoned channel doesn't keep the pipeline alive either
+	waitGoroutines(t, base)
+}
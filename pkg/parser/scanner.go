@@ -0,0 +1,181 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/lirancohen/blockparser/pkg/network"
+)
+
+//Returned by a handler to end the scan early, the scan itself then returns nil
+var ErrStopScan = errors.New("scan stopped")
+
+//Where in the chain a handler is called
+type ScanPosition struct {
+	Height int
+	//Index of the transaction in its block, -1 in block handlers
+	Tx int
+	//Index of the input or output in its transaction, -1 in block and transaction handlers
+	Index int
+}
+
+type BlockHandler func(p ScanPosition, b *Block) error
+type TransactionHandler func(p ScanPosition, tx *Transaction) error
+type InputHandler func(p ScanPosition, tx *Transaction, in *TransInput) error
+type OutputHandler func(p ScanPosition, tx *Transaction, out *TransOutput) error
+
+//Walks blocks once and calls every registered handler on them.
+//For each block the block handlers run first, then per transaction the transaction,
+//input and output handlers, each kind in the order it was registered.
+type Scanner struct {
+	//Decoding goroutines used by Scan, runtime.NumCPU() when <= 0.
+	//Handlers are always called from a single goroutine in height order.
+	Workers int
+
+	blocks       []BlockHandler
+	transactions []TransactionHandler
+	inputs       []InputHandler
+	outputs      []OutputHandler
+}
+
+func NewScanner() *Scanner {
+	return &Scanner{}
+}
+
+func (sc *Scanner) OnBlock(h BlockHandler) *Scanner {
+	sc.blocks = append(sc.blocks, h)
+	return sc
+}
+
+func (sc *Scanner) OnTransaction(h TransactionHandler) *Scanner {
+	sc.transactions = append(sc.transactions, h)
+	return sc
+}
+
+func (sc *Scanner) OnInput(h InputHandler) *Scanner {
+	sc.inputs = append(sc.inputs, h)
+	return sc
+}
+
+func (sc *Scanner) OnOutput(h OutputHandler) *Scanner {
+	sc.outputs = append(sc.outputs, h)
+	return sc
+}
+
+//Scans the rest of the stream and the chunks after it, following them with Next,
+//and returns how many blocks were visited.
+//Stops at the first handler or decode error, ErrStopScan ends it without an error.
+func (sc *Scanner) Scan(ctx context.Context, s *Stream) (int, error) {
+	n := 0
+	first := s
+	for {
+		m, err := sc.scanStream(ctx, s)
+		if s != first {
+			//Chunks opened here are closed here, s belongs to the caller
+			s.Close()
+		}
+		n += m
+		if err == ErrStopScan {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		next, err := s.Next()
+		if err == ErrEOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		s = next
+	}
+}
+
+//Visits the blocks of a single stream, decoding them in parallel
+func (sc *Scanner) scanStream(ctx context.Context, s *Stream) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	blocks := s.DecodeBlocks(ctx, sc.Workers)
+	defer func() {
+		cancel()
+		for range blocks {
+		}
+	}()
+
+	n := 0
+	for r := range blocks {
+		if r.Err != nil {
+			return n, r.Err
+		}
+		if err := sc.visit(r.Block); err == ErrStopScan {
+			return n + 1, err
+		} else if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, ctx.Err()
+}
+
+//Scans the chunked blocks from height start through end, a negative end runs to the tip.
+//Returns how many blocks were visited, like Scan.
+func (sc *Scanner) ScanChain(ctx context.Context, net *network.Network, start, end int) (int, error) {
+	it := NewBlockIterator(net, start, end)
+	defer it.Close()
+
+	n := 0
+	for {
+		b, err := it.Next(ctx)
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		if err := sc.visit(b); err == ErrStopScan {
+			return n + 1, nil
+		} else if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+//Runs the handlers over a single block
+func (sc *Scanner) visit(b *Block) error {
+	p := ScanPosition{Height: b.Height, Tx: -1, Index: -1}
+	for _, h := range sc.blocks {
+		if err := h(p, b); err != nil {
+			return err
+		}
+	}
+	if len(sc.transactions) == 0 && len(sc.inputs) == 0 && len(sc.outputs) == 0 {
+		return nil
+	}
+
+	for t := range b.Transactions {
+		tx := &b.Transactions[t]
+		p := ScanPosition{Height: b.Height, Tx: t, Index: -1}
+		for _, h := range sc.transactions {
+			if err := h(p, tx); err != nil {
+				return err
+			}
+		}
+		for i := range tx.Inputs {
+			p.Index = i
+			for _, h := range sc.inputs {
+				if err := h(p, tx, &tx.Inputs[i]); err != nil {
+					return err
+				}
+			}
+		}
+		for i := range tx.Outputs {
+			p.Index = i
+			for _, h := range sc.outputs {
+				if err := h(p, tx, &tx.Outputs[i]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
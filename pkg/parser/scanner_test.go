@@ -0,0 +1,201 @@
+package parser
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/hashes"
+	"github.com/lirancohen/blockparser/pkg/index"
+	"github.com/lirancohen/blockparser/pkg/network"
+)
+
+//Frames a block holding txs behind an all zero header
+func frameTxs(txs ...[]byte) []byte {
+	payload := make([]byte, 80, 81)
+	payload = append(payload, byte(len(txs)))
+	for _, t := range txs {
+		payload = append(payload, t...)
+	}
+	frame := append([]byte{}, network.MainNet.Magic[:]...)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+//Spends output vout of txid, given in internal byte order, into one OP_1 output of value
+func spendOutput(txid []byte, vout uint32, value uint64) []byte {
+	tx := []byte{1, 0, 0, 0, 1}
+	tx = append(tx, txid...)
+	tx = binary.LittleEndian.AppendUint32(tx, vout)
+	tx = append(tx, 0, 0xff, 0xff, 0xff, 0xff, 1)
+	tx = binary.LittleEndian.AppendUint64(tx, value)
+	return append(tx, 1, 0x51, 0, 0, 0, 0)
+}
+
+//Txid in internal byte order
+func mustTxid(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hashes.FromString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b[:]
+}
+
+const genesisCoinbase = "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+
+//The fixture blocks, then a block spending the genesis coinbase
+func scanFixture(t *testing.T) {
+	t.Helper()
+	frames := readBootstrap(t)
+	withChunks(t, map[string][][]byte{
+		"0_2.dat": {frames[0], frames[1], frameTxs(spendOutput(mustTxid(t, genesisCoinbase), 0, 5000000000))},
+	})
+}
+
+func TestScannerOrder(t *testing.T) {
+	scanFixture(t)
+	var events []string
+	sc := NewScanner()
+	sc.OnBlock(func(p ScanPosition, b *Block) error {
+		events = append(events, fmt.Sprintf("block %v %v", p.Height, len(b.Transactions)))
+		return nil
+	})
+	sc.OnTransaction(func(p ScanPosition, tx *Transaction) error {
+		events = append(events, fmt.Sprintf("tx %v:%v %v", p.Height, p.Tx, tx.HashString()[:8]))
+		return nil
+	})
+	sc.OnInput(func(p ScanPosition, tx *Transaction, in *TransInput) error {
+		o := in.OutPoint()
+		events = append(events, fmt.Sprintf("in %v:%v:%v %v:%v", p.Height, p.Tx, p.Index, hashes.String(o.Hash[:])[:8], o.Index))
+		return nil
+	})
+	sc.OnOutput(func(p ScanPosition, tx *Transaction, out *TransOutput) error {
+		events = append(events, fmt.Sprintf("out %v:%v:%v %v %v", p.Height, p.Tx, p.Index, out.Value(), out.ScriptType()))
+		return nil
+	})
+
+	spender := hashes.Hash256(spendOutput(mustTxid(t, genesisCoinbase), 0, 5000000000))
+	want := []string{
+		"block 0 1",
+		"tx 0:0 4a5e1e4b",
+		"in 0:0:0 00000000:4294967295",
+		"out 0:0:0 5000000000 pubkey",
+		"block 1 2",
+		"tx 1:0 f4184fc5",
+		"in 1:0:0 0437cd7f:0",
+		"out 1:0:0 1000000000 pubkey",
+		"out 1:0:1 4000000000 pubkey",
+		"tx 1:1 e8151a2a",
+		"in 1:1:0 9f96ade4:0",
+		"in 1:1:1 8ac60eb9:1",
+		"out 1:1:0 112340000 pubkeyhash",
+		"out 1:1:1 223450000 pubkeyhash",
+		"block 2 1",
+		"tx 2:0 " + hashes.String(spender)[:8],
+		"in 2:0:0 4a5e1e4b:0",
+		"out 2:0:0 5000000000 nonstandard",
+	}
+
+	for _, workers := range []int{1, 4} {
+		events = nil
+		sc.Workers = workers
+		s, err := SeekChunk(nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := sc.Scan(context.Background(), s)
+		s.Close()
+		if err != nil || n != 3 {
+			t.Fatalf("%v workers: scanned %v blocks, %v", workers, n, err)
+		}
+		if !reflect.DeepEqual(events, want) {
+			t.Errorf("%v workers: events\n%v", workers, events)
+		}
+	}
+
+	events = nil
+	if n, err := sc.ScanChain(context.Background(), nil, 1, 1); err != nil || n != 1 || events[0] != "block 1 2" || len(events) != 10 {
+		t.Errorf("ScanChain 1-1: %v blocks, %v, %v", n, err, events)
+	}
+}
+
+func TestScannerStop(t *testing.T) {
+	scanFixture(t)
+	boom := errors.New("boom")
+	cases := []struct {
+		name string
+		err  error
+		n    int
+		want error
+	}{
+		{"stop", ErrStopScan, 2, nil},
+		{"error", boom, 1, boom},
+	}
+	for _, c := range cases {
+		var heights []int
+		sc := NewScanner().OnBlock(func(p ScanPosition, b *Block) error {
+			heights = append(heights, p.Height)
+			if p.Height == 1 {
+				return c.err
+			}
+			return nil
+		})
+		s, err := SeekChunk(nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := sc.Scan(context.Background(), s)
+		s.Close()
+		if n != c.n || err != c.want || !reflect.DeepEqual(heights, []int{0, 1}) {
+			t.Errorf("%v: scanned %v blocks, %v, heights %v", c.name, n, err, heights)
+		}
+	}
+}
+
+func TestBuildAddressIndexFixture(t *testing.T) {
+	scanFixture(t)
+	s, err := SeekChunk(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	idx, err := BuildAddressIndex(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Len() != 6 {
+		t.Fatalf("%v scripts", idx.Len())
+	}
+
+	//Genesis pays to a bare key, spent by block 2
+	genesis, _ := hex.DecodeString("4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")
+	h := idx.History(genesis)
+	spender := hashes.Hash256(spendOutput(mustTxid(t, genesisCoinbase), 0, 5000000000))
+	var want index.AddressEntry
+	copy(want.Txid[:], mustTxid(t, genesisCoinbase))
+	want.Value = 5000000000
+	want.Spent = true
+	copy(want.SpentBy.Txid[:], spender)
+	want.SpentBy.Height = 2
+	if len(h) != 1 || h[0] != want {
+		t.Errorf("genesis history %+v", h)
+	}
+	if bal := idx.Balance(genesis); bal != 0 {
+		t.Errorf("genesis balance %v", bal)
+	}
+
+	//The BIP143 transaction's outputs stay unspent
+	p2pkh, _ := hex.DecodeString("76a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac")
+	h = idx.History(p2pkh)
+	if len(h) != 1 || h[0].Height != 1 || h[0].Vout != 0 || h[0].Spent || idx.Balance(p2pkh) != 112340000 {
+		t.Errorf("p2pkh history %+v", h)
+	}
+	if bal := idx.Balance([]byte{0x51}); bal != 5000000000 {
+		t.Errorf("OP_1 balance %v", bal)
+	}
+}
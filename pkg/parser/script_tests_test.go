@@ -0,0 +1,233 @@
+package parser
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/script"
+	"github.com/lirancohen/blockparser/pkg/utils"
+)
+
+var scriptTestFlags = map[string]script.Flags{
+	"NONE":                                  script.VerifyNone,
+	"P2SH":                                  script.VerifyP2SH,
+	"STRICTENC":                             script.VerifyStrictEnc,
+	"DERSIG":                                script.VerifyDERSig,
+	"LOW_S":                                 script.VerifyLowS,
+	"NULLDUMMY":                             script.VerifyNullDummy,
+	"SIGPUSHONLY":                           script.VerifySigPushOnly,
+	"MINIMALDATA":                           script.VerifyMinimalData,
+	"DISCOURAGE_UPGRADABLE_NOPS":            script.VerifyDiscourageUpgradableNops,
+	"CLEANSTACK":                            script.VerifyCleanStack,
+	"CHECKLOCKTIMEVERIFY":                   script.VerifyCheckLockTimeVerify,
+	"CHECKSEQUENCEVERIFY":                   script.VerifyCheckSequenceVerify,
+	"NULLFAIL":                              script.VerifyNullFail,
+	"CONST_SCRIPTCODE":                      script.VerifyConstScriptCode,
+	"WITNESS":                               script.VerifyWitness,
+	"MINIMALIF":                             script.VerifyMinimalIf,
+	"WITNESS_PUBKEYTYPE":                    script.VerifyWitnessPubKeyType,
+	"DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM": script.VerifyDiscourageUpgradableWitnessProgram,
+	"TAPROOT":                               script.VerifyTaproot,
+}
+
+//UNKNOWN_ERROR only says the script fails, not how
+var errAny = errors.New("any error")
+
+var scriptTestErrors = map[string]error{
+	"OK":                                    nil,
+	"EVAL_FALSE":                            script.ErrEvalFalse,
+	"OP_RETURN":                             script.ErrOpReturn,
+	"VERIFY":                                script.ErrVerify,
+	"EQUALVERIFY":                           script.ErrEqualVerify,
+	"BAD_OPCODE":                            script.ErrBadOpcode,
+	"DISABLED_OPCODE":                       script.ErrDisabledOpcode,
+	"INVALID_STACK_OPERATION":               script.ErrInvalidStackOperation,
+	"INVALID_ALTSTACK_OPERATION":            script.ErrInvalidAltstackOperation,
+	"UNBALANCED_CONDITIONAL":                script.ErrUnbalancedConditional,
+	"NEGATIVE_LOCKTIME":                     script.ErrNegativeLockTime,
+	"UNSATISFIED_LOCKTIME":                  script.ErrUnsatisfiedLockTime,
+	"SIG_HASHTYPE":                          script.ErrSigHashType,
+	"SIG_DER":                               script.ErrSigDER,
+	"MINIMALDATA":                           script.ErrMinimalData,
+	"SIG_PUSHONLY":                          script.ErrSigPushOnly,
+	"SIG_HIGH_S":                            script.ErrSigHighS,
+	"SIG_NULLDUMMY":                         script.ErrSigNullDummy,
+	"PUBKEYTYPE":                            script.ErrPubKeyType,
+	"CLEANSTACK":                            script.ErrCleanStack,
+	"NULLFAIL":                              script.ErrNullFail,
+	"DISCOURAGE_UPGRADABLE_NOPS":            script.ErrDiscourageUpgradableNops,
+	"OP_CODESEPARATOR":                      script.ErrOpCodeSeparator,
+	"SCRIPT_SIZE":                           script.ErrScriptSize,
+	"PUSH_SIZE":                             script.ErrPushSize,
+	"OP_COUNT":                              script.ErrOpCount,
+	"STACK_SIZE":                            script.ErrStackSize,
+	"SIG_COUNT":                             script.ErrSigCount,
+	"PUBKEY_COUNT":                          script.ErrPubKeyCount,
+	"MINIMALIF":                             script.ErrMinimalIf,
+	"WITNESS_PUBKEYTYPE":                    script.ErrWitnessPubKeyType,
+	"DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM": script.ErrDiscourageUpgradableWitnessProgram,
+	"SCHNORR_SIG_SIZE":                      script.ErrSchnorrSigSize,
+	"SCHNORR_SIG_HASHTYPE":                  script.ErrSchnorrSigHashType,
+	"SCHNORR_SIG":                           script.ErrSchnorrSig,
+	"TAPROOT_WRONG_CONTROL_SIZE":            script.ErrControlBlockSize,
+	"TAPSCRIPT_VALIDATION_WEIGHT":           script.ErrTapscriptValidationWeight,
+	"TAPSCRIPT_CHECKMULTISIG":               script.ErrTapscriptCheckMultiSig,
+	"TAPSCRIPT_MINIMALIF":                   script.ErrMinimalIf,
+	"UNKNOWN_ERROR":                         errAny,
+	"WITNESS_PROGRAM_WRONG_LENGTH":          script.ErrWitnessProgramWrongLength,
+	"WITNESS_PROGRAM_WITNESS_EMPTY":         script.ErrWitnessEmpty,
+	"WITNESS_PROGRAM_MISMATCH":              script.ErrWitnessProgramMismatch,
+	"WITNESS_MALLEATED":                     script.ErrWitnessMalleated,
+	"WITNESS_MALLEATED_P2SH":                script.ErrWitnessMalleatedP2SH,
+	"WITNESS_UNEXPECTED":                    script.ErrWitnessUnexpected,
+}
+
+//Runs testdata/script_tests.json through Core's crediting and spending transactions.
+//The file is Bitcoin Core's src/test/data/script_tests.json (MIT licensed) as btcd v0.22.1
+//vendors it, unmodified.
+func TestScriptVectors(t *testing.T) {
+	data, err := os.ReadFile("testdata/script_tests.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests [][]interface{}
+	if err := json.Unmarshal(data, &tests); err != nil {
+		t.Fatal(err)
+	}
+
+	run := 0
+	for n, test := range tests {
+		var witness [][]byte
+		var amount uint64
+		if w, ok := test[0].([]interface{}); ok {
+			for _, item := range w[:len(w)-1] {
+				b, err := hex.DecodeString(item.(string))
+				if err != nil {
+					t.Fatalf("test %v: witness: %v", n, err)
+				}
+				witness = append(witness, b)
+			}
+			amount = uint64(math.Round(w[len(w)-1].(float64) * 1e8))
+			test = test[1:]
+		}
+		//Single strings are comments
+		if len(test) < 4 {
+			continue
+		}
+		run++
+
+		name := fmt.Sprintf("test %v %q %q", n, test[0], test[1])
+		scriptSig, err := parseCoreScript(test[0].(string))
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		scriptPubKey, err := parseCoreScript(test[1].(string))
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		flags, err := parseCoreFlags(test[2].(string))
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		want, ok := scriptTestErrors[test[3].(string)]
+		if !ok {
+			t.Fatalf("%v: unknown result %v", name, test[3])
+		}
+
+		credit := creditTx(t, scriptPubKey, amount)
+		spend := spendTx(t, credit, scriptSig, witness)
+		err = spend.VerifyInputFlags(0, credit.Outputs[0], flags)
+		//This copy of the file predates Core 0.21, which reports a witness script
+		//leaving more than one item as CLEANSTACK instead of EVAL_FALSE
+		if want == script.ErrEvalFalse && err == script.ErrCleanStack && len(witness) > 0 {
+			continue
+		}
+		if err != want && (want != errAny || err == nil) {
+			t.Errorf("%v %v: got %v, want %v", name, test[2], err, test[3])
+		}
+	}
+	if run == 0 {
+		t.Fatal("no vectors found")
+	}
+}
+
+//Core's BuildCreditingTransaction
+func creditTx(t *testing.T, scriptPubKey []byte, amount uint64) Transaction {
+	raw := "01000000" + "01" + strings.Repeat("00", 32) + "ffffffff" + "020000" + "ffffffff" +
+		"01" + hex.EncodeToString(appendUint64(nil, amount)) + compactHex(scriptPubKey) + "00000000"
+	tx, err := decodeTx(t, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+//Core's BuildSpendingTransaction
+func spendTx(t *testing.T, credit Transaction, scriptSig []byte, witness [][]byte) Transaction {
+	raw := "01000000"
+	if len(witness) > 0 {
+		raw += "0001"
+	}
+	raw += "01" + hex.EncodeToString(credit.Hash()) + "00000000" + compactHex(scriptSig) + "ffffffff" +
+		"01" + hex.EncodeToString(appendUint64(nil, credit.Outputs[0].Value())) + "00"
+	if len(witness) > 0 {
+		raw += hex.EncodeToString(utils.CompactSize(len(witness)).Bytes())
+		for _, item := range witness {
+			raw += compactHex(item)
+		}
+	}
+	tx, err := decodeTx(t, raw+"00000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func compactHex(b []byte) string {
+	return hex.EncodeToString(utils.CompactSize(len(b)).Bytes()) + hex.EncodeToString(b)
+}
+
+//Core's ParseScript: decimal numbers, 0x prefixed raw bytes, 'quoted' pushes and opcode names
+func parseCoreScript(s string) ([]byte, error) {
+	var out []byte
+	for _, word := range strings.Fields(s) {
+		if n, err := strconv.ParseInt(word, 10, 64); err == nil {
+			out = append(out, script.PushNum(n)...)
+		} else if strings.HasPrefix(word, "0x") && len(word) > 2 {
+			b, err := hex.DecodeString(word[2:])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, b...)
+		} else if len(word) >= 2 && word[0] == '\'' && word[len(word)-1] == '\'' {
+			out = append(out, script.PushData([]byte(word[1:len(word)-1]))...)
+		} else if op, err := script.ParseAsm(word); err == nil && len(op) == 1 {
+			out = append(out, op...)
+		} else {
+			return nil, fmt.Errorf("unknown script token %v", word)
+		}
+	}
+	return out, nil
+}
+
+func parseCoreFlags(s string) (script.Flags, error) {
+	var flags script.Flags
+	if s == "" {
+		return script.VerifyNone, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		f, ok := scriptTestFlags[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown flag %v", name)
+		}
+		flags |= f
+	}
+	return flags, nil
+}
@@ -0,0 +1,341 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/lirancohen/blockparser/pkg/hashes"
+	"github.com/lirancohen/blockparser/pkg/script"
+	"github.com/lirancohen/blockparser/pkg/utils"
+)
+
+var (
+	ErrPrevOutsRequired = errors.New("taproot signature hash needs every spent output")
+	ErrNoWitnessScript  = errors.New("input has no witness script")
+	ErrNoSingleOutput   = errors.New("SIGHASH_SINGLE without a matching output")
+)
+
+//Digest a signature on input i commits to, in internal byte order.
+//prevScript and amount describe the output being spent, the algorithm follows from it:
+//witness v0 outputs use BIP143 with the scriptCode derived from the program or the
+//witness script, taproot outputs use the BIP341 key path digest and anything else the
+//legacy algorithm. P2SH outputs are resolved through the redeem script in scriptSig.
+//Taproot digests commit to every spent output, so without SigHashAnyOneCanPay they
+//need TaprootSigHash instead.
+func (t *Transaction) SigHash(i int, prevScript []uint8, amount uint64, hashType uint32) ([]uint8, error) {
+	if i < 0 || i >= len(t.Inputs) {
+		return nil, ErrInputIndex
+	}
+	in := &t.Inputs[i]
+
+	if c := script.Classify(prevScript); c.Class == script.ScriptHash {
+		redeem, ok := lastPush(in.script)
+		if !ok {
+			return nil, script.ErrSigPushOnly
+		}
+		if _, _, ok := script.WitnessProgram(redeem); !ok {
+			return t.legacySigHash(i, redeem, hashType), nil
+		}
+		//P2SH wrapped witness program
+		prevScript = redeem
+	}
+
+	version, program, ok := script.WitnessProgram(prevScript)
+	switch {
+	case !ok:
+		return t.legacySigHash(i, prevScript, hashType), nil
+	case version == 0 && len(program) == 20:
+		return t.witnessV0SigHash(i, payToPubKeyHashScript(program), amount, hashType), nil
+	case version == 0 && len(program) == 32:
+		w := in.Witness()
+		if len(w) == 0 {
+			return nil, ErrNoWitnessScript
+		}
+		return t.witnessV0SigHash(i, w[len(w)-1], amount, hashType), nil
+	case version == 1 && len(program) == 32:
+		if hashType&script.SigHashAnyOneCanPay == 0 {
+			return nil, ErrPrevOutsRequired
+		}
+		//With ANYONECANPAY only the spent output of this input is committed to
+		prevOuts := make([]TransOutput, len(t.Inputs))
+		prevOuts[i] = NewTransOutput(amount, prevScript)
+		return t.TaprootSigHash(i, prevOuts, hashType, nil)
+	}
+	//Unknown witness versions have no signature hash yet
+	return nil, script.ErrSigHashType
+}
+
+//Last data push of a scriptSig, the redeem script of a P2SH spend
+func lastPush(scriptSig []uint8) ([]uint8, bool) {
+	tokens, err := script.Parse(scriptSig)
+	if err != nil || len(tokens) == 0 || !tokens[len(tokens)-1].IsPush() {
+		return nil, false
+	}
+	return tokens[len(tokens)-1].Data, true
+}
+
+//scriptCode BIP143 defines for P2WPKH
+func payToPubKeyHashScript(hash []uint8) []uint8 {
+	s := []uint8{script.OP_DUP, script.OP_HASH160, 20}
+	s = append(s, hash...)
+	return append(s, script.OP_EQUALVERIFY, script.OP_CHECKSIG)
+}
+
+//BIP143 signature hash for witness v0 inputs
+func (t *Transaction) witnessV0SigHash(i int, scriptCode []uint8, amount uint64, hashType uint32) []uint8 {
+	base := hashType & 0x1f
+	anyoneCanPay := hashType&script.SigHashAnyOneCanPay != 0
+
+	zero := make([]uint8, 32)
+	hashPrevouts, hashSequence, hashOutputs := zero, zero, zero
+	if !anyoneCanPay {
+		hashPrevouts = hashes.Hash256(t.prevoutsData())
+		if base != script.SigHashSingle && base != script.SigHashNone {
+			hashSequence = hashes.Hash256(t.sequencesData())
+		}
+	}
+	if base != script.SigHashSingle && base != script.SigHashNone {
+		hashOutputs = hashes.Hash256(t.outputsData())
+	} else if base == script.SigHashSingle && i < len(t.Outputs) {
+		hashOutputs = hashes.Hash256(t.Outputs[i].serialize())
+	}
+
+	in := &t.Inputs[i]
+	var d []uint8
+	d = append(d, t.versionnumber[:]...)
+	d = append(d, hashPrevouts...)
+	d = append(d, hashSequence...)
+	d = append(d, in.hash[:]...)
+	d = append(d, in.index[:]...)
+	d = append(d, utils.CompactSize(len(scriptCode)).Bytes()...)
+	d = append(d, scriptCode...)
+	d = appendUint64(d, amount)
+	d = append(d, in.sequencenumber[:]...)
+	d = append(d, hashOutputs...)
+	d = append(d, t.locktime[:]...)
+	d = appendUint32(d, hashType)
+	return hashes.Hash256(d)
+}
+
+//Script path data BIP342 adds to the taproot signature hash
+type TapLeafExt struct {
+	LeafHash [32]uint8
+	//Opcode position of the last executed OP_CODESEPARATOR, 0xffffffff for none
+	CodeSepPos uint32
+}
+
+//BIP341 signature hash of input i. prevOuts holds the output spent by every
+//input in order, leaf is nil for key path spends. The annex is taken from the
+//input's witness.
+func (t *Transaction) TaprootSigHash(i int, prevOuts []TransOutput, hashType uint32, leaf *TapLeafExt) ([]uint8, error) {
+	if i < 0 || i >= len(t.Inputs) {
+		return nil, ErrInputIndex
+	}
+	if len(prevOuts) != len(t.Inputs) {
+		return nil, ErrPrevOutsRequired
+	}
+	switch hashType {
+	case script.SigHashDefault, script.SigHashAll, script.SigHashNone, script.SigHashSingle,
+		script.SigHashAll | script.SigHashAnyOneCanPay, script.SigHashNone | script.SigHashAnyOneCanPay,
+		script.SigHashSingle | script.SigHashAnyOneCanPay:
+	default:
+		return nil, script.ErrSigHashType
+	}
+
+	outputType := hashType & 3
+	if hashType == script.SigHashDefault {
+		outputType = script.SigHashAll
+	}
+	anyoneCanPay := hashType&script.SigHashAnyOneCanPay != 0
+	in := &t.Inputs[i]
+	annex := in.annex()
+
+	//Epoch 0
+	d := []uint8{0, uint8(hashType)}
+	d = append(d, t.versionnumber[:]...)
+	d = append(d, t.locktime[:]...)
+
+	if !anyoneCanPay {
+		var amounts, scripts []uint8
+		for n := range prevOuts {
+			amounts = appendUint64(amounts, prevOuts[n].value)
+			scripts = append(scripts, utils.CompactSize(len(prevOuts[n].script)).Bytes()...)
+			scripts = append(scripts, prevOuts[n].script...)
+		}
+		d = append(d, hashes.Sha256(t.prevoutsData())...)
+		d = append(d, hashes.Sha256(amounts)...)
+		d = append(d, hashes.Sha256(scripts)...)
+		d = append(d, hashes.Sha256(t.sequencesData())...)
+	}
+	if outputType != script.SigHashNone && outputType != script.SigHashSingle {
+		d = append(d, hashes.Sha256(t.outputsData())...)
+	}
+
+	var spendType uint8
+	if leaf != nil {
+		spendType |= 2
+	}
+	if annex != nil {
+		spendType |= 1
+	}
+	d = append(d, spendType)
+
+	if anyoneCanPay {
+		d = append(d, in.hash[:]...)
+		d = append(d, in.index[:]...)
+		d = append(d, prevOuts[i].serialize()...)
+		d = append(d, in.sequencenumber[:]...)
+	} else {
+		d = appendUint32(d, uint32(i))
+	}
+	if annex != nil {
+		d = append(d, hashes.Sha256(append(utils.CompactSize(len(annex)).Bytes(), annex...))...)
+	}
+	if outputType == script.SigHashSingle {
+		if i >= len(t.Outputs) {
+			return nil, ErrNoSingleOutput
+		}
+		d = append(d, hashes.Sha256(t.Outputs[i].serialize())...)
+	}
+	if leaf != nil {
+		d = append(d, leaf.LeafHash[:]...)
+		//key_version 0
+		d = append(d, 0)
+		d = appendUint32(d, leaf.CodeSepPos)
+	}
+	return hashes.TaggedHash("TapSighash", d), nil
+}
+
+//Every input's outpoint, back to back
+func (t *Transaction) prevoutsData() []uint8 {
+	var d []uint8
+	for n := range t.Inputs {
+		d = append(d, t.Inputs[n].hash[:]...)
+		d = append(d, t.Inputs[n].index[:]...)
+	}
+	return d
+}
+
+func (t *Transaction) sequencesData() []uint8 {
+	var d []uint8
+	for n := range t.Inputs {
+		d = append(d, t.Inputs[n].sequencenumber[:]...)
+	}
+	return d
+}
+
+func (t *Transaction) outputsData() []uint8 {
+	var d []uint8
+	for n := range t.Outputs {
+		d = append(d, t.Outputs[n].serialize()...)
+	}
+	return d
+}
+
+//Annex of a taproot spend: the last witness item when there are at least two and it starts with 0x50
+func (ti *TransInput) annex() []uint8 {
+	w := ti.Witness()
+	if len(w) < 2 || len(w[len(w)-1]) == 0 || w[len(w)-1][0] != script.TaprootAnnexTag {
+		return nil
+	}
+	return w[len(w)-1]
+}
+
+func appendUint32(d []uint8, v uint32) []uint8 {
+	b := make([]uint8, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return append(d, b...)
+}
+
+func appendUint64(d []uint8, v uint64) []uint8 {
+	b := make([]uint8, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return append(d, b...)
+}
+
+//Returned instead of a digest when SIGHASH_SINGLE has no matching output,
+//a quirk of the original implementation every consensus client keeps
+var sigHashOne = append([]uint8{1}, make([]uint8, 31)...)
+
+//Pre-segwit signature hash of input i, scriptCode being the script the signature commits to.
+//The digest is in internal byte order, the way it's fed to ECDSA.
+func (t *Transaction) legacySigHash(i int, scriptCode []uint8, hashType uint32) []uint8 {
+	if i >= len(t.Inputs) {
+		return sigHashOne
+	}
+	base := hashType & 0x1f
+	anyoneCanPay := hashType&script.SigHashAnyOneCanPay != 0
+	if base == script.SigHashSingle && i >= len(t.Outputs) {
+		return sigHashOne
+	}
+
+	var d []uint8
+	d = append(d, t.versionnumber[:]...)
+
+	code := removeCodeSeparators(scriptCode)
+	writeInput := func(n int) {
+		ti := &t.Inputs[n]
+		d = append(d, ti.hash[:]...)
+		d = append(d, ti.index[:]...)
+		if n == i {
+			d = append(d, utils.CompactSize(len(code)).Bytes()...)
+			d = append(d, code...)
+		} else {
+			d = append(d, 0)
+		}
+		if n != i && (base == script.SigHashNone || base == script.SigHashSingle) {
+			//Other inputs may update their sequence numbers freely
+			d = append(d, 0, 0, 0, 0)
+		} else {
+			d = append(d, ti.sequencenumber[:]...)
+		}
+	}
+	if anyoneCanPay {
+		d = append(d, 1)
+		writeInput(i)
+	} else {
+		d = append(d, utils.CompactSize(len(t.Inputs)).Bytes()...)
+		for n := range t.Inputs {
+			writeInput(n)
+		}
+	}
+
+	outputs := len(t.Outputs)
+	switch base {
+	case script.SigHashNone:
+		outputs = 0
+	case script.SigHashSingle:
+		outputs = i + 1
+	}
+	d = append(d, utils.CompactSize(outputs).Bytes()...)
+	for n := 0; n < outputs; n++ {
+		if base == script.SigHashSingle && n != i {
+			//Outputs before the signed one are blanked: value -1 and an empty script
+			d = append(d, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0)
+			continue
+		}
+		d = append(d, t.Outputs[n].serialize()...)
+	}
+
+	d = append(d, t.locktime[:]...)
+	d = appendUint32(d, hashType)
+	return hashes.Hash256(d)
+}
+
+//Strips OP_CODESEPARATOR from a scriptCode, anything past a malformed push is kept as is
+func removeCodeSeparators(code []uint8) []uint8 {
+	var out []uint8
+	last := 0
+	t := script.NewTokenizer(code)
+	for t.Next() {
+		tok := t.Token()
+		if tok.Opcode == script.OP_CODESEPARATOR {
+			out = append(out, code[last:tok.Offset]...)
+			last = tok.Offset + 1
+		}
+	}
+	if last == 0 {
+		return code
+	}
+	return append(out, code[last:]...)
+}
@@ -0,0 +1,109 @@
+package parser
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/script"
+	"github.com/lirancohen/blockparser/pkg/secp256k1"
+)
+
+//Unsigned transaction of the BIP143 native P2WPKH example
+const bip143UnsignedTx = "0100000002fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f0000000000eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac11000000"
+
+func mustDecodeTx(t *testing.T, h string) Transaction {
+	t.Helper()
+	tx, err := decodeTx(t, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func checkSigHash(t *testing.T, name string, got []byte, err error, want string) {
+	t.Helper()
+	if err != nil {
+		t.Errorf("%v: %v", name, err)
+	} else if hex.EncodeToString(got) != want {
+		t.Errorf("%v: got %x, want %v", name, got, want)
+	}
+}
+
+func TestSigHashBIP143(t *testing.T) {
+	tx := mustDecodeTx(t, bip143UnsignedTx)
+	spk, _ := hex.DecodeString("00141d0f172a0ecb48aee1be1f2687d2963ae33f71a1")
+	h, err := tx.SigHash(1, spk, 600000000, script.SigHashAll)
+	checkSigHash(t, "native P2WPKH", h, err, "c37af31116d1b27caf68aae9e3ac82f1477929014d5b917657d0eb49478cb670")
+
+	//P2SH-P2WPKH example, scriptSig set to the redeem script push
+	tx = mustDecodeTx(t, "0100000001db6b1b20aa0fd7b23880be2ecbd4a98130974cf4748fb66092ac4d3ceb1a54770100000000feffffff02b8b4eb0b000000001976a914a457b684d7f0d539a46a45bbc043f35b59d0d96388ac0008af2f000000001976a914fd270b1ee6abcaea97fea7ad0402e8bd8ad6d77c88ac92040000")
+	tx.Inputs[0].script, _ = hex.DecodeString("16001479091972186c449eb1ded22b78e40d009bdf0089")
+	spk, _ = hex.DecodeString("a9144733f37cf4db86fbc2efed2500b4f4e49f31202387")
+	h, err = tx.SigHash(0, spk, 1000000000, script.SigHashAll)
+	checkSigHash(t, "P2SH-P2WPKH", h, err, "64f3b0f4dd2bb3aa1ce8566d220cc74dda9df97d8490cc81d89d735c92e59fb6")
+}
+
+//The digest of the first bitcoin transfer must be the one its signature signs
+func TestSigHashLegacy(t *testing.T) {
+	tx := mustDecodeTx(t, "0100000001c997a5e56e104102fa209c6a852dd90660a20b2d9c352423edce25857fcd3704000000004847304402204e45e16932b8af514961a1d3a1a25fdf3f4f7732e9d624c6c61548ab5fb8cd410220181522ec8eca07de4860a4acdd12909d831cc56cbbac4622082221a8768d1d0901ffffffff0200ca9a3b00000000434104ae1a62fe09c5f51b13905f07f06b99a2f7159b2225f374cd378d71302fa28414e7aab37397f554a7df5f142c21c1b7303b8a0626f1baded5c72a704f7e6cd84cac00286bee0000000043410411db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5cb2e0eaddfb84ccf9744464f82e160bfa9b8b64f9d4c03f999b8643f656b412a3ac00000000")
+	prevScript, _ := hex.DecodeString("410411db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5cb2e0eaddfb84ccf9744464f82e160bfa9b8b64f9d4c03f999b8643f656b412a3ac")
+	h, err := tx.SigHash(0, prevScript, 0, script.SigHashAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := secp256k1.ParsePubKey(prevScript[1:66])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigPush := tx.Inputs[0].Script()
+	sig, err := secp256k1.ParseDERSignature(sigPush[1 : len(sigPush)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !secp256k1.Verify(pub, h, sig) {
+		t.Fatalf("signature doesn't sign %x", h)
+	}
+}
+
+//Digests from an independent implementation of the BIP341 signature message
+func TestSigHashTaproot(t *testing.T) {
+	tx := mustDecodeTx(t, bip143UnsignedTx)
+	spk0, _ := hex.DecodeString("5120aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	spk1, _ := hex.DecodeString("5120bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	prevOuts := []TransOutput{NewTransOutput(625000000, spk0), NewTransOutput(600000000, spk1)}
+	leaf := &TapLeafExt{CodeSepPos: 0xffffffff}
+	copy(leaf.LeafHash[:], script.TapLeafHash(script.TaprootLeafTapscript, []byte{script.OP_1}))
+
+	cases := []struct {
+		name     string
+		input    int
+		hashType uint32
+		leaf     *TapLeafExt
+		want     string
+	}{
+		{"default", 0, script.SigHashDefault, nil, "1ac8b4df0bd328ee7519c0d13c86549b6ea83a5804d69c31f793a3d4705a74f5"},
+		{"all", 1, script.SigHashAll, nil, "e11074024dfc067613c5ced60e2a06a9d077a7e0f32be38b8706fa3a6a199abd"},
+		{"single anyonecanpay", 1, script.SigHashSingle | script.SigHashAnyOneCanPay, nil, "2261972620ba4a71989a304a7f26cd4fdbd7cb02c23e48af7817fba03d0f85c6"},
+		{"none script path", 1, script.SigHashNone, leaf, "002305125ff1dc45c2ad614806446ec5f1df4da6eb2e060d5794c2cddbd92dda"},
+		{"all anyonecanpay codeseparator", 0, script.SigHashAll | script.SigHashAnyOneCanPay, &TapLeafExt{LeafHash: leaf.LeafHash, CodeSepPos: 3}, "7de6f579ddfa35f3e75a3d43b2dd788e59f51d37657a7ec1a28c463d1458d676"},
+	}
+	for _, c := range cases {
+		h, err := tx.TaprootSigHash(c.input, prevOuts, c.hashType, c.leaf)
+		checkSigHash(t, c.name, h, err, c.want)
+	}
+
+	//With ANYONECANPAY SigHash only needs the output being spent
+	h, err := tx.SigHash(1, spk1, 600000000, script.SigHashSingle|script.SigHashAnyOneCanPay)
+	checkSigHash(t, "SigHash single anyonecanpay", h, err, "2261972620ba4a71989a304a7f26cd4fdbd7cb02c23e48af7817fba03d0f85c6")
+	if _, err := tx.SigHash(1, spk1, 600000000, script.SigHashAll); err != ErrPrevOutsRequired {
+		t.Errorf("SigHash without every prevout: %v", err)
+	}
+	if _, err := tx.TaprootSigHash(0, prevOuts, 0x04, nil); err != script.ErrSigHashType {
+		t.Errorf("undefined hash type: %v", err)
+	}
+
+	//Same transaction with witnesses, input 0 carrying the annex 0x50aa
+	tx = mustDecodeTx(t, "01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f0000000000eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac0240000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000250aa01400000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000011000000")
+	h, err = tx.TaprootSigHash(0, prevOuts, script.SigHashDefault, nil)
+	checkSigHash(t, "annex", h, err, "6f2f37a1b07edc22e25dd4372d3f108dfd13db80bfab70547540428a8a382057")
+}
@@ -0,0 +1,171 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/lirancohen/blockparser/pkg/hashes"
+	"github.com/lirancohen/blockparser/pkg/script"
+	"github.com/lirancohen/blockparser/pkg/secp256k1"
+)
+
+var ErrInvalidSignature = errors.New("invalid signature")
+
+//Adds the signatures of inputs with a known shape to b: P2PK, P2PKH, P2WPKH, P2SH-P2WPKH and
+//taproot key path spends. prevOuts holds the output spent by each input in order.
+//Returns the indexes of the inputs added, other inputs are left to VerifyInput.
+func (t *Transaction) AddSignatures(b *secp256k1.Batch, prevOuts []TransOutput) ([]int, error) {
+	if len(prevOuts) != len(t.Inputs) {
+		return nil, ErrPrevOutsRequired
+	}
+	var added []int
+	for i := range t.Inputs {
+		in := &t.Inputs[i]
+		prev := &prevOuts[i]
+
+		//keyHash is the HASH160 the revealed public key must match, nil when the key is in the script
+		var sig, pubKey, keyHash []uint8
+		switch c := script.Classify(prev.script); c.Class {
+		case script.PubKey:
+			tokens, err := script.Parse(in.script)
+			if err != nil || len(tokens) != 1 || !tokens[0].IsPush() {
+				continue
+			}
+			sig, pubKey = tokens[0].Data, c.Data[0]
+		case script.PubKeyHash:
+			tokens, err := script.Parse(in.script)
+			if err != nil || len(tokens) != 2 || !tokens[0].IsPush() || !tokens[1].IsPush() {
+				continue
+			}
+			sig, pubKey, keyHash = tokens[0].Data, tokens[1].Data, c.Data[0]
+		case script.ScriptHash:
+			redeem, ok := lastPush(in.script)
+			if !ok {
+				continue
+			}
+			scriptHash := c.Data[0]
+			//From here on the redeem script is the output being spent
+			if c = script.Classify(redeem); c.Class != script.WitnessV0PubKeyHash {
+				continue
+			}
+			if !bytes.Equal(hashes.Hash160(redeem), scriptHash) {
+				return added, fmt.Errorf("%w: input %v redeem script doesn't match its hash", ErrInvalidSignature, i)
+			}
+			//Anything besides the redeem script push would be malleable
+			if !bytes.Equal(in.script, script.PushData(redeem)) {
+				return added, fmt.Errorf("%w: input %v scriptSig isn't only the redeem script", ErrInvalidSignature, i)
+			}
+			fallthrough
+		case script.WitnessV0PubKeyHash:
+			w := in.Witness()
+			if len(w) != 2 {
+				continue
+			}
+			sig, pubKey, keyHash = w[0], w[1], c.Data[0]
+		case script.WitnessV1Taproot:
+			spend, err := script.ParseTaprootWitness(c.Data[0], in.Witness())
+			if err != nil || spend.Type != script.KeyPath {
+				continue
+			}
+			if err := t.addSchnorr(b, i, prevOuts, spend); err != nil {
+				return added, err
+			}
+			added = append(added, i)
+			continue
+		default:
+			continue
+		}
+
+		if len(sig) == 0 {
+			return added, fmt.Errorf("%w: input %v has an empty signature", ErrInvalidSignature, i)
+		}
+		if keyHash != nil && !bytes.Equal(hashes.Hash160(pubKey), keyHash) {
+			return added, fmt.Errorf("%w: input %v public key doesn't match its hash", ErrInvalidSignature, i)
+		}
+		pub, err := secp256k1.ParsePubKey(pubKey)
+		if err != nil {
+			return added, fmt.Errorf("input %v: %w", i, err)
+		}
+		s, err := secp256k1.ParseDERSignature(sig[:len(sig)-1])
+		if err != nil {
+			return added, fmt.Errorf("input %v: %w", i, err)
+		}
+		hash, err := t.SigHash(i, prev.script, prev.value, uint32(sig[len(sig)-1]))
+		if err != nil {
+			return added, fmt.Errorf("input %v: %w", i, err)
+		}
+		b.AddECDSA(pub, hash, s)
+		added = append(added, i)
+	}
+	return added, nil
+}
+
+//Key path: a 64 byte signature uses SIGHASH_DEFAULT, a 65th byte is an explicit hash type
+func (t *Transaction) addSchnorr(b *secp256k1.Batch, i int, prevOuts []TransOutput, spend *script.TaprootSpend) error {
+	sig := spend.Signature
+	hashType := uint32(script.SigHashDefault)
+	if len(sig) == 65 {
+		hashType = uint32(sig[64])
+		//An explicit 0x00 would let the same signature be encoded two ways
+		if hashType == script.SigHashDefault {
+			return fmt.Errorf("%w: input %v", script.ErrSigHashType, i)
+		}
+		sig = sig[:64]
+	}
+	s, err := secp256k1.ParseSchnorrSignature(sig)
+	if err != nil {
+		return fmt.Errorf("input %v: %w", i, err)
+	}
+	pub, err := secp256k1.ParseXOnlyPubKey(spend.OutputKey)
+	if err != nil {
+		return fmt.Errorf("input %v: %w", i, err)
+	}
+	hash, err := t.TaprootSigHash(i, prevOuts, hashType, nil)
+	if err != nil {
+		return fmt.Errorf("input %v: %w", i, err)
+	}
+	b.AddSchnorr(pub, hash, s)
+	return nil
+}
+
+//Checks the signatures AddSignatures understands across the whole block in one batch,
+//f resolves the outputs the inputs spend. Returns how many signatures were checked.
+func (b *Block) VerifySignatures(f PrevOutFetcher) (int, error) {
+	type ref struct{ tx, input int }
+	var refs []ref
+
+	batch := secp256k1.NewBatch()
+	for n := range b.Transactions {
+		t := &b.Transactions[n]
+		if t.IsCoinBase() {
+			continue
+		}
+		prevOuts := make([]TransOutput, len(t.Inputs))
+		for i := range t.Inputs {
+			out, err := f.FetchPrevOut(t.Inputs[i].OutPoint())
+			if err != nil {
+				return 0, err
+			}
+			prevOuts[i] = out
+		}
+		added, err := t.AddSignatures(batch, prevOuts)
+		if err != nil {
+			return 0, fmt.Errorf("transaction %v: %w", t.HashString(), err)
+		}
+		for _, i := range added {
+			refs = append(refs, ref{tx: n, input: i})
+		}
+	}
+
+	if batch.Verify() {
+		return batch.Len(), nil
+	}
+	//Find the culprit
+	invalid := batch.Invalid()
+	if len(invalid) == 0 {
+		return batch.Len(), ErrInvalidSignature
+	}
+	bad := refs[invalid[0]]
+	return batch.Len(), fmt.Errorf("%w: transaction %v input %v", ErrInvalidSignature, b.Transactions[bad.tx].HashString(), bad.input)
+}
@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/hashes"
+	"github.com/lirancohen/blockparser/pkg/script"
+	"github.com/lirancohen/blockparser/pkg/secp256k1"
+)
+
+func TestAddSignaturesP2WPKH(t *testing.T) {
+	pub := testPubKey()
+	spk := append([]byte{script.OP_0, 20}, hashes.Hash160(pub)...)
+	prevOut := NewTransOutput(100000000, spk)
+	tx := signedWitnessTx(t, nil, prevOut, func(sig []byte) [][]byte {
+		return [][]byte{sig, pub}
+	})
+
+	b := secp256k1.NewBatch()
+	added, err := tx.AddSignatures(b, []TransOutput{prevOut})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || !b.Verify() {
+		t.Fatalf("added %v, batch verified %v", added, b.Verify())
+	}
+}
+
+//A key that doesn't hash to the one committed to must not reach the batch,
+//where its signature would verify against the wrong key
+func TestAddSignaturesKeyMismatch(t *testing.T) {
+	pub := testPubKey()
+	other := (&secp256k1.PublicKey{Point: *secp256k1.ScalarBaseMult(big.NewInt(0xbad))}).SerializeCompressed()
+	sig := testSign(make([]byte, 32))
+
+	p2wpkh := append([]byte{script.OP_0, 20}, hashes.Hash160(other)...)
+	redeem := append([]byte{script.OP_0, 20}, hashes.Hash160(other)...)
+	p2sh := append(append([]byte{script.OP_HASH160, 20}, hashes.Hash160(redeem)...), script.OP_EQUAL)
+	p2pkh := append(append([]byte{script.OP_DUP, script.OP_HASH160, 20}, hashes.Hash160(other)...), script.OP_EQUALVERIFY, script.OP_CHECKSIG)
+
+	cases := []struct {
+		name      string
+		scriptSig []byte
+		witness   [][]byte
+		prevOut   []byte
+	}{
+		{"P2WPKH", nil, [][]byte{sig, pub}, p2wpkh},
+		{"P2SH-P2WPKH", script.PushData(redeem), [][]byte{sig, pub}, p2sh},
+		{"P2PKH", append(script.PushData(sig), script.PushData(pub)...), nil, p2pkh},
+	}
+	for _, c := range cases {
+		tx := witnessTx(t, c.scriptSig, c.witness)
+		added, err := tx.AddSignatures(secp256k1.NewBatch(), []TransOutput{NewTransOutput(1, c.prevOut)})
+		if !errors.Is(err, ErrInvalidSignature) || len(added) != 0 {
+			t.Errorf("%v: added %v, %v", c.name, added, err)
+		}
+	}
+}
+
+//The redeem script must be the one the P2SH output commits to, pushed on its own
+func TestAddSignaturesNestedP2WPKH(t *testing.T) {
+	pub := testPubKey()
+	redeem := append([]byte{script.OP_0, 20}, hashes.Hash160(pub)...)
+	p2sh := func(redeem []byte) TransOutput {
+		return NewTransOutput(200000000, append(append([]byte{script.OP_HASH160, 20}, hashes.Hash160(redeem)...), script.OP_EQUAL))
+	}
+	sign := func(scriptSig []byte, prevOut TransOutput) Transaction {
+		return signedWitnessTx(t, scriptSig, prevOut, func(sig []byte) [][]byte {
+			return [][]byte{sig, pub}
+		})
+	}
+
+	prevOut := p2sh(redeem)
+	tx := sign(script.PushData(redeem), prevOut)
+	b := secp256k1.NewBatch()
+	if added, err := tx.AddSignatures(b, []TransOutput{prevOut}); err != nil || len(added) != 1 || !b.Verify() {
+		t.Fatalf("added %v, %v", added, err)
+	}
+
+	//Key and signature are valid for the redeem script, but the output commits to another one
+	other := p2sh(append([]byte{script.OP_1}, redeem...))
+	wrong := sign(script.PushData(redeem), other)
+	if added, err := wrong.AddSignatures(secp256k1.NewBatch(), []TransOutput{other}); !errors.Is(err, ErrInvalidSignature) || len(added) != 0 {
+		t.Errorf("wrong redeem script: added %v, %v", added, err)
+	}
+
+	padded := sign(append([]byte{script.OP_1}, script.PushData(redeem)...), prevOut)
+	if added, err := padded.AddSignatures(secp256k1.NewBatch(), []TransOutput{prevOut}); !errors.Is(err, ErrInvalidSignature) || len(added) != 0 {
+		t.Errorf("padded scriptSig: added %v, %v", added, err)
+	}
+}
@@ -6,56 +6,122 @@ import (
 	"strconv"
 	"fmt"
 	"io"
-	"log"
 	"sync"
 	"bufio"
 	"bytes"
 	"errors"
+	"context"
 
-	"github.com/lirancohen/blockparser/pkg/utils"
+	"github.com/lirancohen/blockparser/pkg/hashes"
+	"github.com/lirancohen/blockparser/pkg/index"
+	"github.com/lirancohen/blockparser/pkg/network"
 
 )
 
 
-var magic_id = []byte{249,190,180,217}
-
 var ErrEOF = errors.New("EOF")
 var ErrNotFound = errors.New("NotFound")
+var ErrBadMagic = errors.New("MagicID doesn't match the network")
+var ErrNoChunk = errors.New("block doesn't exist")
 
 
 type Stream struct {
 	Filename string
 	Floor, Ceiling int
 	Stream *bufio.Reader
+	//Network the chunks belong to, mainnet when nil
+	Network *network.Network
+	//Block locations written by the chunker, SeekBlock scans the chunk when nil
+	Index *index.BlockIndex
+	//Transaction locations written by the chunker, needed by LookupTransaction
+	TxIndex *index.TxIndex
+	//Spent outpoints written by the chunker, for Transaction.SpentOutputs
+	SpendIndex *index.SpendIndex
+	//Decoding goroutines used by Parse, runtime.NumCPU() when <= 0
+	Workers int
 	wg     sync.WaitGroup
+	//Blocks already read from Stream by ReadBlock
+	position int
+	//Chunk file behind Stream, when the stream opened one
+	closer io.Closer
+	//Frames blocks out of Stream, created on first use
+	framer *Framer
 }
 
-func New(r io.Reader) *Stream {
+func New(r io.Reader, net *network.Network) *Stream {
 	return &Stream{
 		Stream: bufio.NewReader(r),
+		Network: net,
 	}
 }
 
-func NewStream(name string,r io.Reader, f,c int) *Stream {
-	return &Stream{
+func NewStream(net *network.Network, name string,r io.Reader, f,c int) *Stream {
+	s := &Stream{
 		Filename: name,
 		Stream: bufio.NewReader(r),
+		Network: net,
 		Floor: f,
 		Ceiling: c,
 	}
+	if closer, ok := r.(io.Closer); ok {
+		s.closer = closer
+	}
+	return s
+}
+
+//Closes the chunk file behind the stream, if there is one
+func (s *Stream) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	err := s.closer.Close()
+	s.closer = nil
+	return err
 }
 
 func EmptyStream() *Stream {
 	return &Stream{}
 }
 
+func (s *Stream) net() *network.Network {
+	if s.Network == nil {
+		return network.MainNet
+	}
+	return s.Network
+}
+
 func (s *Stream) ParseBlock(n int, b []byte) (*Block, error) {
 	defer s.wg.Done()
 	buf := bytes.NewReader(b)
-	parser := NewBlockParser(buf, &s.wg)
+	parser := NewBlockParser(buf, &s.wg, s.Network)
 	return parser.Decode(n)
 }
 
+//Reads and decodes the next block of the stream, io.EOF once the chunk is exhausted
+func (s *Stream) ReadBlock() (*Block, error) {
+	block, err := s.readRawBlock()
+	if err != nil {
+		return &Block{}, err
+	}
+	defer ReleaseFrame(block)
+	height := s.Floor + s.position
+	s.position++
+	s.wg.Add(1)
+	return s.ParseBlock(height, block)
+}
+
+//Skips to the next MagicID and returns the whole framed block, MagicID and length included.
+//The block is a pooled frame, see ReleaseFrame
+func (s *Stream) readRawBlock() ([]byte, error) {
+	if s.Stream == nil {
+		return nil, io.EOF
+	}
+	if s.framer == nil {
+		s.framer = NewFramer(s.Stream, s.net())
+	}
+	return s.framer.Next()
+}
+
 func (s *Stream) Next() (*Stream, error){
 	_, err := os.Stat("./data/chunks")
 	if err != nil{
@@ -89,7 +155,12 @@ func (s *Stream) Next() (*Stream, error){
 					if err != nil {
 						return EmptyStream(), err
 					}
-					return NewStream(file.Name(),chunk,b,t), nil
+					next := NewStream(s.Network,file.Name(),chunk,b,t)
+					next.Index = s.Index
+					next.TxIndex = s.TxIndex
+					next.SpendIndex = s.SpendIndex
+					next.Workers = s.Workers
+					return next, nil
 				}
 			}
 		}
@@ -132,7 +203,12 @@ func (s *Stream) Previous()(*Stream, error) {
 					if err != nil {
 						return EmptyStream(), err
 					}
-					return NewStream(file.Name(),chunk,b,t),nil
+					prev := NewStream(s.Network,file.Name(),chunk,b,t)
+					prev.Index = s.Index
+					prev.TxIndex = s.TxIndex
+					prev.SpendIndex = s.SpendIndex
+					prev.Workers = s.Workers
+					return prev, nil
 				}
 			}
 		}
@@ -141,240 +217,164 @@ func (s *Stream) Previous()(*Stream, error) {
 	return EmptyStream(), errors.New("this is the first block")
 }
 
-func(s *Stream)SeekTransaction(h string) (Transaction, error){
-	reader := s.Stream
-	blocks := 0
+func (s *Stream) SeekTransaction(h string) (Transaction, error) {
 	for {
-		var m []byte
-		if b, err := reader.ReadByte(); err != nil && err != io.EOF{
-			log.Printf("Reader Error On Block: %v\n", blocks)
-			log.Printf("Error: %v\n", err)
-			break
-		} else if err == io.EOF {
-			break
-		} else {
-			//Check to make sure the first byte in the magic ID chunk is 0xF9
-			//Remember it's encoded in Little Endian so the first byte will end up being the last when encoded
-			if bytes.Compare([]byte{uint8(b)}, []byte{uint8(249)}) == 0 {
-				m = append(m, b)
-				for i := 0; i < 3; i++ {
-					if b, err := reader.ReadByte(); err != nil {
-						log.Printf("Reader Error inside MagicID On Block: %v\n", blocks)
-						log.Printf("Error: %v\n", err)
-						break
-					} else {
-						m = append(m, b)
-					}
-				}
-			} else {
-				continue
-			}
+		block, err := s.readRawBlock()
+		//A truncated block at the end is the chunk still being written
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return Transaction{}, ErrNotFound
+		} else if err != nil {
+			return Transaction{}, err
 		}
-		if bytes.Compare(m,magic_id) == 0 {
-			var stmp []byte
-			for i := 0; i < 4; i++ {
-				b, err := reader.ReadByte()
-				if err != nil {
-					break
-				}
-				stmp = append(stmp, b)
-			}
-
-			//Temporary place for block in memory
-			var block []byte
-			block = append(block, m...)
-			block = append(block, stmp...)
-			//Parse Size of Block and retrieve it
-			size := utils.ParseLEUint32(stmp)
-
-			for i := 0; i < (int(size)); i++ {
-				b, err := reader.ReadByte()
-				if err != nil {
-					break
-				}
-				block = append(block, b)
-			}
-			s.wg.Add(1)
-			fblock, err := s.ParseBlock(blocks + s.Floor, block)
-			if err != nil && err.Error() != "unexpected EOF" {
-				return Transaction{}, errors.New(fmt.Sprintf("Could not parse block %v:%v\n",blocks, err.Error()))
-			}
-			for _, t := range fblock.Transactions {
-				if h == t.HashString(){
-					return t, nil
-				}
+		height := s.Floor + s.position
+		s.position++
+		s.wg.Add(1)
+		fblock, err := s.ParseBlock(height, block)
+		ReleaseFrame(block)
+		if err != nil {
+			return Transaction{}, fmt.Errorf("Could not parse block %v:%v\n", height, err.Error())
+		}
+		for _, t := range fblock.Transactions {
+			if h == t.HashString() {
+				return t, nil
 			}
-			blocks++
-		} else {
-			s.wg.Wait()
-			return Transaction{}, errors.New("Invlaid MAGICID, bad block")
 		}
 	}
-	s.wg.Wait()
-	return Transaction{}, ErrNotFound
 }
 
-func (s *Stream)SeekBlock(n int) (*Block,error) {
-	if n < s.Floor || n > s.Ceiling {
+func (s *Stream) SeekBlock(n int) (*Block, error) {
+	if s.Index != nil {
+		if loc, ok := s.Index.ByHeight(n); ok {
+			return s.readIndexed(loc)
+		}
+	}
+
+	//Streams only move forward, a block already read means opening its chunk again
+	if s.Stream == nil || n < s.Floor+s.position || n > s.Ceiling {
 		var err error
-		s, err = SeekChunk(n)
+		s, err = SeekChunk(s.Network, n)
 		if err != nil {
-			s.wg.Wait()
 			return &Block{}, err
 		}
+		defer s.Close()
 	}
 
-	reader := s.Stream
-	target := n - s.Floor
-	blocks := 0
-	for {
-		var m []byte
-		if b, err := reader.ReadByte(); err != nil {
-			log.Printf("Reader Error On Block: %v\n", blocks)
-			log.Printf("Error: %v\n", err)
-			break
-		} else {
-			//Check to make sure the first byte in the magic ID chunk is 0xF9
-			//Remember it's encoded in Little Endian so the first byte will end up being the last when encoded
-			if bytes.Compare([]byte{uint8(b)}, []byte{uint8(249)}) == 0 {
-				m = append(m, b)
-				for i := 0; i < 3; i++ {
-					if b, err := reader.ReadByte(); err != nil {
-						log.Printf("Reader Error inside MagicID On Block: %v\n", blocks)
-						log.Printf("Error: %v\n", err)
-						break
-					} else {
-						m = append(m, b)
-					}
-				}
-			} else {
-				continue
-			}
+	for s.Floor+s.position < n {
+		block, err := s.readRawBlock()
+		if err == io.EOF {
+			return &Block{}, ErrNotFound
+		} else if err != nil {
+			return &Block{}, err
 		}
-		if bytes.Compare(m,magic_id) == 0 {
-		//if utils.ParseLEUint32(m) == 3652501241 {
-				var stmp []byte
-				for i := 0; i < 4; i++ {
-					b, err := reader.ReadByte()
-					if err != nil {
-						break
-					}
-					stmp = append(stmp, b)
-				}
+		ReleaseFrame(block)
+		s.position++
+	}
+	b, err := s.ReadBlock()
+	if err == io.EOF {
+		return b, ErrNotFound
+	}
+	return b, err
+}
 
-				//Temporary place for block in memory
-				var block []byte
-				block = append(block, m...)
-				block = append(block, stmp...)
-				//Parse Size of Block and retrieve it
-				size := utils.ParseLEUint32(stmp)
+//Looks a block up by its hash through the block index
+func (s *Stream) SeekBlockHash(h string) (*Block, error) {
+	if s.Index == nil {
+		return &Block{}, errors.New("no block index loaded")
+	}
+	hash, err := hashes.FromString(h)
+	if err != nil {
+		return &Block{}, err
+	}
+	loc, ok := s.Index.ByHash(hash)
+	if !ok {
+		return &Block{}, ErrNotFound
+	}
+	return s.readIndexed(loc)
+}
 
-				for i := 0; i < (int(size)); i++ {
-					b, err := reader.ReadByte()
-					if err != nil {
-						break
-					}
-					block = append(block, b)
-				}
+//Finds a transaction by txid, or by wtxid when the index recorded them, without scanning any block
+func (s *Stream) LookupTransaction(txid string) (Transaction, error) {
+	if s.Index == nil || s.TxIndex == nil {
+		return Transaction{}, errors.New("no transaction index loaded")
+	}
+	hash, err := hashes.FromString(txid)
+	if err != nil {
+		return Transaction{}, err
+	}
+	_, tl, ok := s.TxIndex.Lookup(hash)
+	if !ok {
+		return Transaction{}, ErrNotFound
+	}
+	bl, ok := s.Index.ByHeight(tl.Height)
+	if !ok {
+		return Transaction{}, fmt.Errorf("block %v missing from the block index", tl.Height)
+	}
 
-			if blocks == target {
-				s.wg.Add(1)
+	f, err := os.Open("./data/chunks/" + bl.File)
+	if err != nil {
+		return Transaction{}, err
+	}
+	defer f.Close()
 
-				return s.ParseBlock(n, block)
+	raw := make([]byte, tl.Length)
+	if _, err := f.ReadAt(raw, bl.Offset+int64(tl.Offset)); err != nil {
+		return Transaction{}, err
+	}
+	return NewBlockParser(bytes.NewReader(raw), nil, s.Network).DecodeTrans()
+}
 
-			}
-			blocks++
-		} else {
-			s.wg.Wait()
-			return &Block{}, errors.New("Invlaid MAGICID, bad block")
-		}
+//Reads a single block straight from its chunk file
+func (s *Stream) readIndexed(loc index.BlockLocation) (*Block, error) {
+	f, err := os.Open("./data/chunks/" + loc.File)
+	if err != nil {
+		return &Block{}, err
+	}
+	defer f.Close()
+
+	block := make([]byte, loc.Length)
+	if _, err := f.ReadAt(block, loc.Offset); err != nil {
+		return &Block{}, err
 	}
-	s.wg.Wait()
-	return &Block{}, errors.New("Unknown Error")
+	s.wg.Add(1)
+	return s.ParseBlock(loc.Height, block)
 }
 
-func (s *Stream) Parse(offset, length int) (int,error) {
-	return 0,nil
+//Skips offset blocks and decodes the next length of them, or the rest of the stream when length <= 0,
+//with s.Workers goroutines. Returns how many blocks were decoded and the first error
+func (s *Stream) Parse(offset, length int) (int, error) {
+	for i := 0; i < offset; i++ {
+		raw, err := s.readRawBlock()
+		if err == io.EOF {
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+		ReleaseFrame(raw)
+		s.position++
+	}
 
-	//Open BlockChain File and load it into a buffer
-	reader := s.Stream
-	blocks := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	parsed := 0
-	for {
-		if length > 0 && parsed >= length {
+	var err error
+	blocks := s.DecodeBlocks(ctx, s.Workers)
+	for r := range blocks {
+		if r.Err != nil {
+			err = r.Err
 			break
 		}
-		//Temporary holder for what might be the MagicID
-		var m []byte
-		if b, err := reader.ReadByte(); err != nil {
-			log.Printf("Reader Error On Block: %v\n", blocks)
-			log.Printf("Error: %v\n", err)
+		parsed++
+		if length > 0 && parsed >= length {
 			break
-		} else {
-			//Check to make sure the first byte in the magic ID chunk is 0xF9
-			//Remember it's encoded in Little Endian so the first byte will end up being the last when encoded
-			if uint8(b) == 249 {
-				m = append(m, b)
-				for i := 0; i < 3; i++ {
-					if b, err := reader.ReadByte(); err != nil {
-						log.Printf("Reader Error inside MagicID On Block: %v\n", blocks)
-						log.Printf("Error: %v\n", err)
-						break
-					} else {
-						m = append(m, b)
-					}
-				}
-			} else {
-				log.Printf("Invalid Byte: %v\n", b)
-				continue
-			}
-		}
-		//Check If the MagicID == 0xD9B4BEF9
-		//If so, this is the beginning of a BitCoin BlockChain Block
-		if utils.ParseLEUint32(m) == 3652501241 {
-			var stmp []byte
-			for i := 0; i < 4; i++ {
-				b, err := reader.ReadByte()
-				if err != nil {
-					break
-				}
-				stmp = append(stmp, b)
-			}
-
-			//Temporary place for block in memory
-			var block []byte
-			block = append(block, m...)
-			block = append(block, stmp...)
-			//Parse Size of Block and retrieve it
-			size := utils.ParseLEUint32(stmp)
-
-			for i := 0; i < (int(size)); i++ {
-				b, err := reader.ReadByte()
-				if err != nil {
-					break
-				}
-				block = append(block, b)
-			}
-
-			if offset > 0 && blocks >= offset {
-				//Send the retrieved block to the parser
-				s.wg.Add(1)
-				//log.Println("FOUND")
-				parsed++
-				go s.ParseBlock(blocks, block)
-			}
-			blocks++
-
-		} else {
-			log.Printf("Invalid MagicID Looking for next block")
 		}
 	}
-
-	s.wg.Wait()
-	return parsed, nil
+	cancel()
+	for range blocks {
+	}
+	return parsed, err
 }
 
-func SeekChunk(n int) (*Stream, error) {
+func SeekChunk(net *network.Network, n int) (*Stream, error) {
 	_, err := os.Stat("./data/chunks")
 	if err != nil{
 		return EmptyStream(),err
@@ -408,11 +408,11 @@ func SeekChunk(n int) (*Stream, error) {
 					if err != nil {
 						return EmptyStream(), err
 					}
-					return NewStream(file.Name(),chunk,b,t),nil
+					return NewStream(net,file.Name(),chunk,b,t),nil
 				}
 			}
 		}
 	}
 
-	return EmptyStream(), errors.New("block doesn't exist")
+	return EmptyStream(), ErrNoChunk
 }
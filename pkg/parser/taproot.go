@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"errors"
+
+	"github.com/lirancohen/blockparser/pkg/script"
+)
+
+var ErrNotTaproot = errors.New("output is not a taproot output")
+
+//Interprets the witness of an input spending prevOut, which must be a P2TR output
+func (ti *TransInput) TaprootSpend(prevOut TransOutput) (*script.TaprootSpend, error) {
+	version, program, ok := script.WitnessProgram(prevOut.Script())
+	if !ok || version != 1 || len(program) != 32 {
+		return nil, ErrNotTaproot
+	}
+	return script.ParseTaprootWitness(program, ti.Witness())
+}
+
+//Taproot usage of a block
+type TaprootSummary struct {
+	Height int
+	//P2TR outputs created
+	Outputs          int
+	KeyPathSpends    int
+	ScriptPathSpends int
+	//Spends of either kind carrying an annex
+	AnnexSpends int
+}
+
+//Counts P2TR outputs created and spent in the block, f resolves the outputs the inputs spend
+func (b *Block) TaprootSummary(f PrevOutFetcher) (TaprootSummary, error) {
+	s := TaprootSummary{Height: b.Height}
+	for i := range b.Transactions {
+		t := &b.Transactions[i]
+		for n := range t.Outputs {
+			if t.Outputs[n].ScriptType() == script.WitnessV1Taproot {
+				s.Outputs++
+			}
+		}
+		//Only witness transactions can spend taproot outputs
+		if t.IsCoinBase() || !t.HasWitness() {
+			continue
+		}
+		for n := range t.Inputs {
+			in := &t.Inputs[n]
+			prevOut, err := f.FetchPrevOut(in.OutPoint())
+			if err != nil {
+				return s, err
+			}
+			spend, err := in.TaprootSpend(prevOut)
+			if err == ErrNotTaproot {
+				continue
+			} else if err != nil {
+				return s, err
+			}
+			if spend.Type == script.ScriptPath {
+				s.ScriptPathSpends++
+			} else {
+				s.KeyPathSpends++
+			}
+			if spend.Annex != nil {
+				s.AnnexSpends++
+			}
+		}
+	}
+	return s, nil
+}
@@ -1,17 +1,23 @@
 package parser
 
 import (
-	"fmt"
 	"bytes"
 	"encoding/binary"
 	"log"
-	"crypto/sha256"
 	"time"
+	"github.com/lirancohen/blockparser/pkg/address"
+	"github.com/lirancohen/blockparser/pkg/hashes"
+	"github.com/lirancohen/blockparser/pkg/index"
+	"github.com/lirancohen/blockparser/pkg/network"
+	"github.com/lirancohen/blockparser/pkg/script"
 	"github.com/lirancohen/blockparser/pkg/utils"
 )
 
 type Transaction struct {
 	versionnumber [4]uint8
+	//BIP144 marker (0x00) and flag (0x01), only set for witness transactions
+	marker        uint8
+	flag          uint8
 	inputcount    []uint8
 	Inputs        []TransInput
 	outputcount   []uint8
@@ -19,10 +25,16 @@ type Transaction struct {
 	locktime      [4]uint8
 }
 
-func (t *Transaction) Hash() []uint8 {
+//Builds the wire encoding of the transaction, counts are taken from the inputs, outputs and witness stacks held.
+//With witness false the marker, flag and witness stacks are stripped, which is what the txid commits to.
+func (t *Transaction) encode(witness bool) []uint8 {
+	witness = witness && t.HasWitness()
 	var d []uint8
 	d = append(d, t.versionnumber[:]...)
-	d = append(d, t.inputcount[:]...)
+	if witness {
+		d = append(d, t.marker, t.flag)
+	}
+	d = append(d, utils.CompactSize(len(t.Inputs)).Bytes()...)
 	for _, ti := range t.Inputs {
 		d = append(d, ti.hash[:]...)
 		d = append(d, ti.index[:]...)
@@ -30,26 +42,46 @@ func (t *Transaction) Hash() []uint8 {
 		d = append(d, ti.script[:]...)
 		d = append(d, ti.sequencenumber[:]...)
 	}
-	d = append(d, t.outputcount[:]...)
-	for _, to := range t.Outputs {
-		v := make([]byte, 8)
-		binary.LittleEndian.PutUint64(v, to.value)
-		d = append(d, v[:]...)
-		d = append(d, to.scriptlength[:]...)
-		d = append(d, to.script[:]...)
-	}
-	d = append(d, t.locktime[:]...)
-	h := sha256.New()
-	h.Reset()
-	if _, err := h.Write(d); err != nil {
-		return []byte{}
+	d = append(d, utils.CompactSize(len(t.Outputs)).Bytes()...)
+	for i := range t.Outputs {
+		d = append(d, t.Outputs[i].serialize()...)
 	}
-	tmp := h.Sum(nil)
-	h.Reset()
-	if _, err := h.Write(tmp); err != nil {
-		return []byte{}
+	if witness {
+		for _, ti := range t.Inputs {
+			d = append(d, utils.CompactSize(len(ti.witness)).Bytes()...)
+			for _, item := range ti.witness {
+				d = append(d, item.length[:]...)
+				d = append(d, item.data[:]...)
+			}
+		}
 	}
-	return h.Sum(nil)
+	d = append(d, t.locktime[:]...)
+	return d
+}
+
+//Wire encoding of the transaction, witness data included when present
+func (t *Transaction) Serialize() []uint8 {
+	return t.encode(true)
+}
+
+//Legacy wire encoding with the marker, flag and witness stacks stripped
+func (t *Transaction) SerializeNoWitness() []uint8 {
+	return t.encode(false)
+}
+
+//Transaction ID (txid), witness data is never part of it
+func (t *Transaction) Hash() []uint8 {
+	return hashes.Hash256(t.encode(false))
+}
+
+//Witness Transaction ID (wtxid), equal to the txid for non witness transactions
+func (t *Transaction) WitnessHash() []uint8 {
+	return hashes.Hash256(t.encode(true))
+}
+
+//Reports whether the transaction was serialized with the BIP144 marker and flag
+func (t *Transaction) HasWitness() bool {
+	return t.flag != 0
 }
 
 func (t *Transaction) VersionNumber() uint32 {
@@ -87,13 +119,25 @@ func (t *Transaction) LockTimeFormatted() time.Time {
 }
 
 func (t *Transaction) HashString() string {
-	var temp []byte
-	//Not sure how else to converte little endian to string.
-	th := t.Hash()
-	for i := 0; i < len(t.Hash()); i++ {
-		temp = append([]byte{th[i]}, temp...)
+	return hashes.String(t.Hash())
+}
+
+//Which outputs of the transaction have been spent, keyed by output index
+func (t *Transaction) SpentOutputs(idx *index.SpendIndex) map[int]index.SpendRecord {
+	spent := make(map[int]index.SpendRecord)
+	o := index.OutPoint{}
+	copy(o.Hash[:], t.Hash())
+	for i := range t.Outputs {
+		o.Index = uint32(i)
+		if r, ok := idx.Lookup(o); ok {
+			spent[i] = r
+		}
 	}
-	return fmt.Sprintf("%x", temp[:])
+	return spent
+}
+
+func (t *Transaction) WitnessHashString() string {
+	return hashes.String(t.WitnessHash())
 }
 
 type TransInput struct {
@@ -102,6 +146,22 @@ type TransInput struct {
 	scriptlength   []uint8
 	script         []uint8
 	sequencenumber [4]uint8
+	witnesscount   []uint8
+	witness        []WitnessItem
+}
+
+//Single element of an input's witness stack
+type WitnessItem struct {
+	length []uint8
+	data   []uint8
+}
+
+func (wi *WitnessItem) Length() int {
+	return utils.VarInt(wi.length)
+}
+
+func (wi *WitnessItem) Data() []uint8 {
+	return wi.data
 }
 
 func (ti *TransInput) Hash() [32]uint8 {
@@ -110,12 +170,7 @@ func (ti *TransInput) Hash() [32]uint8 {
 }
 
 func (ti *TransInput) HashString() string {
-	var temp []byte
-	//Not sure how else to converte little endian to string.
-	for i := 0; i < cap(ti.hash); i++ {
-		temp = append([]byte{ti.hash[i]}, temp...)
-	}
-	return fmt.Sprintf("%x", temp[:])
+	return hashes.String(ti.hash[:])
 }
 func (ti *TransInput) Index() uint32 {
 	var v uint32
@@ -126,6 +181,11 @@ func (ti *TransInput) Index() uint32 {
 	return v
 }
 
+//Previous output this input spends
+func (ti *TransInput) OutPoint() index.OutPoint {
+	return index.OutPoint{Hash: ti.hash, Index: ti.Index()}
+}
+
 func (ti *TransInput) ScriptLength() int {
 	return utils.VarInt(ti.scriptlength)
 }
@@ -134,6 +194,11 @@ func (ti *TransInput) Script() []uint8 {
 	return ti.script
 }
 
+//scriptSig in Bitcoin Core's asm format
+func (ti *TransInput) ScriptAsm() string {
+	return script.Disasm(ti.script)
+}
+
 func (ti *TransInput) SequenceNumber() uint32 {
 	var v uint32
 	reader := bytes.NewReader(ti.sequencenumber[:])
@@ -143,12 +208,33 @@ func (ti *TransInput) SequenceNumber() uint32 {
 	return v
 }
 
+func (ti *TransInput) WitnessCount() int {
+	return utils.VarInt(ti.witnesscount)
+}
+
+//Witness stack items in the order they appear on the wire
+func (ti *TransInput) Witness() [][]uint8 {
+	var w [][]uint8
+	for _, item := range ti.witness {
+		w = append(w, item.data)
+	}
+	return w
+}
+
 type TransOutput struct {
 	value        uint64
 	scriptlength []uint8
 	script       []uint8
 }
 
+//Value followed by the length prefixed scriptPubKey, as it appears in a transaction
+func (to *TransOutput) serialize() []uint8 {
+	d := make([]uint8, 8)
+	binary.LittleEndian.PutUint64(d, to.value)
+	d = append(d, to.scriptlength[:]...)
+	return append(d, to.script[:]...)
+}
+
 func (to *TransOutput) Value() uint64 {
 	return to.value
 }
@@ -161,3 +247,22 @@ func (to *TransOutput) Script() []uint8 {
 	return to.script
 }
 
+//scriptPubKey in Bitcoin Core's asm format
+func (to *TransOutput) ScriptAsm() string {
+	return script.Disasm(to.script)
+}
+
+func (to *TransOutput) ScriptType() script.ScriptClass {
+	return script.Classify(to.script).Class
+}
+
+//Address the output pays to on net, address.ErrNoAddress for scripts without one
+func (to *TransOutput) Address(net *network.Network) (string, error) {
+	return address.FromScript(to.script, net)
+}
+
+//Type of the scriptPubKey along with the pubkeys, hash or witness program it pays to
+func (to *TransOutput) Classify() script.Classification {
+	return script.Classify(to.script)
+}
+
@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"errors"
+
+	"github.com/lirancohen/blockparser/pkg/script"
+	"github.com/lirancohen/blockparser/pkg/secp256k1"
+)
+
+var ErrInputIndex = errors.New("input index out of range")
+
+//Checks input i against the output it spends using script.ConsensusFlags.
+//Taproot signatures commit to every spent output, so unless they use SigHashAnyOneCanPay
+//they fail with ErrPrevOutsRequired here, VerifyInputPrevOuts checks them.
+func (t *Transaction) VerifyInput(i int, prevOut TransOutput) error {
+	return t.VerifyInputFlags(i, prevOut, script.ConsensusFlags)
+}
+
+//Checks input i against the output it spends with the given script verification flags
+func (t *Transaction) VerifyInputFlags(i int, prevOut TransOutput, flags script.Flags) error {
+	if i < 0 || i >= len(t.Inputs) {
+		return ErrInputIndex
+	}
+	c := &sigChecker{tx: t, input: i, prevOut: prevOut}
+	return script.VerifyWithWitness(t.Inputs[i].Script(), prevOut.Script(), t.Inputs[i].Witness(), flags, c)
+}
+
+//Checks input i given the outputs spent by every input in order, which any input can be verified with
+func (t *Transaction) VerifyInputPrevOuts(i int, prevOuts []TransOutput, flags script.Flags) error {
+	if i < 0 || i >= len(t.Inputs) {
+		return ErrInputIndex
+	}
+	if len(prevOuts) != len(t.Inputs) {
+		return ErrPrevOutsRequired
+	}
+	c := &sigChecker{tx: t, input: i, prevOut: prevOuts[i], prevOuts: prevOuts}
+	return script.VerifyWithWitness(t.Inputs[i].Script(), prevOuts[i].Script(), t.Inputs[i].Witness(), flags, c)
+}
+
+//Checks signatures and lock times against input of tx
+type sigChecker struct {
+	tx    *Transaction
+	input int
+	//The output spent, BIP143 signatures commit to its value
+	prevOut TransOutput
+	//Outputs spent by every input, nil when only prevOut is known
+	prevOuts []TransOutput
+}
+
+func (c *sigChecker) CheckSig(sig, pubKey, scriptCode []byte) bool {
+	return checkECDSA(sig, pubKey, func(hashType uint32) []byte {
+		return c.tx.legacySigHash(c.input, scriptCode, hashType)
+	})
+}
+
+func (c *sigChecker) CheckWitnessSig(sig, pubKey, scriptCode []byte) bool {
+	return checkECDSA(sig, pubKey, func(hashType uint32) []byte {
+		return c.tx.witnessV0SigHash(c.input, scriptCode, c.prevOut.Value(), hashType)
+	})
+}
+
+//Verifies a DER signature followed by its hash type byte against the digest sigHash returns
+func checkECDSA(sig, pubKey []byte, sigHash func(hashType uint32) []byte) bool {
+	if len(sig) == 0 {
+		return false
+	}
+	pub, err := secp256k1.ParsePubKey(pubKey)
+	if err != nil {
+		return false
+	}
+	s, err := secp256k1.ParseDERSignature(sig[:len(sig)-1])
+	if err != nil {
+		return false
+	}
+	return secp256k1.Verify(pub, sigHash(uint32(sig[len(sig)-1])), s)
+}
+
+func (c *sigChecker) CheckSchnorrSig(sig, pubKey []byte, hashType uint32, tap *script.TapscriptData) error {
+	prevOuts := c.prevOuts
+	if prevOuts == nil {
+		//With ANYONECANPAY only the spent output of this input is committed to
+		if hashType&script.SigHashAnyOneCanPay == 0 {
+			return ErrPrevOutsRequired
+		}
+		prevOuts = make([]TransOutput, len(c.tx.Inputs))
+		prevOuts[c.input] = c.prevOut
+	}
+	var leaf *TapLeafExt
+	if tap != nil {
+		leaf = &TapLeafExt{CodeSepPos: tap.CodeSepPos}
+		copy(leaf.LeafHash[:], tap.LeafHash)
+	}
+	hash, err := c.tx.TaprootSigHash(c.input, prevOuts, hashType, leaf)
+	if err != nil {
+		return err
+	}
+	pub, err := secp256k1.ParseXOnlyPubKey(pubKey)
+	if err != nil {
+		return script.ErrSchnorrSig
+	}
+	s, err := secp256k1.ParseSchnorrSignature(sig)
+	if err != nil || !secp256k1.VerifySchnorr(pub, hash, s) {
+		return script.ErrSchnorrSig
+	}
+	return nil
+}
+
+func (c *sigChecker) CheckLockTime(lockTime int64) bool {
+	txLockTime := int64(c.tx.LockTime())
+	//Heights can only be compared with heights and times with times
+	if (txLockTime < script.LockTimeThreshold) != (lockTime < script.LockTimeThreshold) {
+		return false
+	}
+	if lockTime > txLockTime {
+		return false
+	}
+	//A final input disables the transaction lock time, which would bypass the check
+	return c.tx.Inputs[c.input].SequenceNumber() != 0xffffffff
+}
+
+func (c *sigChecker) CheckSequence(sequence int64) bool {
+	txSequence := int64(c.tx.Inputs[c.input].SequenceNumber())
+	//Relative lock times only apply from version 2 on (BIP68)
+	if c.tx.VersionNumber() < 2 {
+		return false
+	}
+	if txSequence&script.SequenceLockTimeDisableFlag != 0 {
+		return false
+	}
+
+	mask := int64(script.SequenceLockTimeTypeFlag | script.SequenceLockTimeMask)
+	txSequence &= mask
+	sequence &= mask
+	if (txSequence < script.SequenceLockTimeTypeFlag) != (sequence < script.SequenceLockTimeTypeFlag) {
+		return false
+	}
+	return sequence <= txSequence
+}
@@ -0,0 +1,349 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/hashes"
+	"github.com/lirancohen/blockparser/pkg/script"
+	"github.com/lirancohen/blockparser/pkg/secp256k1"
+	"github.com/lirancohen/blockparser/pkg/utils"
+)
+
+func TestVerifyBIP143Inputs(t *testing.T) {
+	tx, err := decodeTx(t, bip143SignedTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2pk, _ := hex.DecodeString("2103c9f4836b9a4f77fc0d81f7bcb01b7f1b35916864b9476c241ce9fc198bd25432ac")
+	p2wpkh, _ := hex.DecodeString("00141d0f172a0ecb48aee1be1f2687d2963ae33f71a1")
+	if err := tx.VerifyInput(0, NewTransOutput(625000000, p2pk)); err != nil {
+		t.Errorf("P2PK input: %v", err)
+	}
+	if err := tx.VerifyInput(1, NewTransOutput(600000000, p2wpkh)); err != nil {
+		t.Errorf("P2WPKH input: %v", err)
+	}
+	//BIP143 signatures commit to the amount
+	if err := tx.VerifyInput(1, NewTransOutput(600000001, p2wpkh)); err == nil {
+		t.Error("P2WPKH input verified against the wrong amount")
+	}
+}
+
+//Private key the witness spends below are signed with
+var testKey = big.NewInt(0x5eed)
+
+func testPubKey() []byte {
+	p := secp256k1.ScalarBaseMult(testKey)
+	return (&secp256k1.PublicKey{Point: *p}).SerializeCompressed()
+}
+
+//ECDSA signature of hash with a low S and SIGHASH_ALL appended
+func testSign(hash []byte) []byte {
+	z := new(big.Int).SetBytes(hash)
+	k := new(big.Int).Add(z, big.NewInt(7))
+	k.Mod(k, secp256k1.N)
+	r := new(big.Int).Mod(secp256k1.ScalarBaseMult(k).X, secp256k1.N)
+	s := new(big.Int).Mul(r, testKey)
+	s.Add(s, z)
+	s.Mul(s, new(big.Int).ModInverse(k, secp256k1.N))
+	s.Mod(s, secp256k1.N)
+	if s.Cmp(new(big.Int).Rsh(secp256k1.N, 1)) > 0 {
+		s.Sub(secp256k1.N, s)
+	}
+	return append((&secp256k1.Signature{R: r, S: s}).Serialize(), script.SigHashAll)
+}
+
+//Single input, single output transaction, in the legacy serialization when witness is empty
+func witnessTx(t *testing.T, scriptSig []byte, witness [][]byte) Transaction {
+	raw := "01000000"
+	if len(witness) > 0 {
+		raw += "0001"
+	}
+	raw += "01" + "1111111111111111111111111111111111111111111111111111111111111111" + "00000000" +
+		hex.EncodeToString(utils.CompactSize(len(scriptSig)).Bytes()) + hex.EncodeToString(scriptSig) + "ffffffff" +
+		"01" + "00e1f50500000000" + "0151"
+	if len(witness) > 0 {
+		raw += hex.EncodeToString(utils.CompactSize(len(witness)).Bytes())
+		for _, item := range witness {
+			raw += hex.EncodeToString(utils.CompactSize(len(item)).Bytes()) + hex.EncodeToString(item)
+		}
+	}
+	tx, err := decodeTx(t, raw+"00000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+//Builds the spend twice, once to find the digest and once with the signature in place
+func signedWitnessTx(t *testing.T, scriptSig []byte, prevOut TransOutput, witness func(sig []byte) [][]byte) Transaction {
+	unsigned := witnessTx(t, scriptSig, witness(nil))
+	hash, err := unsigned.SigHash(0, prevOut.Script(), prevOut.Value(), script.SigHashAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return witnessTx(t, scriptSig, witness(testSign(hash)))
+}
+
+func TestVerifyNestedP2WPKH(t *testing.T) {
+	pub := testPubKey()
+	redeem := append([]byte{script.OP_0, 20}, hashes.Hash160(pub)...)
+	spk := append(append([]byte{script.OP_HASH160, 20}, hashes.Hash160(redeem)...), script.OP_EQUAL)
+	prevOut := NewTransOutput(200000000, spk)
+	scriptSig := script.PushData(redeem)
+
+	tx := signedWitnessTx(t, scriptSig, prevOut, func(sig []byte) [][]byte {
+		return [][]byte{sig, pub}
+	})
+	if err := tx.VerifyInput(0, prevOut); err != nil {
+		t.Fatal(err)
+	}
+
+	//Anything besides the redeem script in scriptSig is malleable
+	padded := witnessTx(t, append([]byte{script.OP_1}, scriptSig...), tx.Inputs[0].Witness())
+	if err := padded.VerifyInput(0, prevOut); err != script.ErrWitnessMalleatedP2SH {
+		t.Errorf("padded scriptSig: %v", err)
+	}
+}
+
+func TestVerifyP2WSH(t *testing.T) {
+	pub := testPubKey()
+	witnessScript := append(script.PushData(pub), script.OP_CHECKSIG)
+	spk := append([]byte{script.OP_0, 32}, hashes.Sha256(witnessScript)...)
+	prevOut := NewTransOutput(300000000, spk)
+
+	tx := signedWitnessTx(t, nil, prevOut, func(sig []byte) [][]byte {
+		return [][]byte{sig, witnessScript}
+	})
+	if err := tx.VerifyInput(0, prevOut); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.VerifyInput(0, NewTransOutput(300000001, spk)); err != script.ErrEvalFalse {
+		t.Errorf("wrong amount: %v", err)
+	}
+
+	other := append(script.PushData(pub), script.OP_CHECKSIGVERIFY, script.OP_1)
+	swapped := witnessTx(t, nil, [][]byte{tx.Inputs[0].Witness()[0], other})
+	if err := swapped.VerifyInput(0, prevOut); err != script.ErrWitnessProgramMismatch {
+		t.Errorf("swapped witness script: %v", err)
+	}
+
+	//A legacy output can't be spent with a witness attached
+	unexpected := witnessTx(t, nil, [][]byte{{1}})
+	if err := unexpected.VerifyInput(0, NewTransOutput(1, []byte{script.OP_1})); err != script.ErrWitnessUnexpected {
+		t.Errorf("witness on a legacy output: %v", err)
+	}
+}
+
+//BIP340 signature of msg by private key d, with the nonce derived from msg
+func testSignSchnorr(d *big.Int, msg []byte) []byte {
+	p := secp256k1.ScalarBaseMult(d)
+	if p.Y.Bit(0) == 1 {
+		d = new(big.Int).Sub(secp256k1.N, d)
+	}
+	k := new(big.Int).SetBytes(hashes.Sha256(msg))
+	k.Mod(k, secp256k1.N)
+	r := secp256k1.ScalarBaseMult(k)
+	if r.Y.Bit(0) == 1 {
+		k.Sub(secp256k1.N, k)
+	}
+	rx := r.X.FillBytes(make([]byte, 32))
+	e := new(big.Int).SetBytes(hashes.TaggedHash("BIP0340/challenge", rx, p.X.FillBytes(make([]byte, 32)), msg))
+	s := e.Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, secp256k1.N)
+	return append(rx, s.FillBytes(make([]byte, 32))...)
+}
+
+func xOnly(d *big.Int) []byte {
+	return (&secp256k1.PublicKey{Point: *secp256k1.ScalarBaseMult(d)}).SerializeXOnly()
+}
+
+func p2tr(outputKey []byte) []byte {
+	return append([]byte{script.OP_1, 32}, outputKey...)
+}
+
+func TestVerifyTaprootKeyPath(t *testing.T) {
+	internal := xOnly(testKey)
+	outputKey, _, err := script.TweakPubKey(internal, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//BIP341's taproot_tweak_seckey with no script tree
+	d := new(big.Int).Set(testKey)
+	if secp256k1.ScalarBaseMult(d).Y.Bit(0) == 1 {
+		d.Sub(secp256k1.N, d)
+	}
+	d.Add(d, new(big.Int).SetBytes(hashes.TaggedHash("TapTweak", internal)))
+	d.Mod(d, secp256k1.N)
+
+	prevOut := NewTransOutput(100000, p2tr(outputKey))
+	prevOuts := []TransOutput{prevOut}
+	sign := func(hashType uint32) []byte {
+		unsigned := witnessTx(t, nil, [][]byte{{0}})
+		hash, err := unsigned.TaprootSigHash(0, prevOuts, hashType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := testSignSchnorr(d, hash)
+		if hashType != script.SigHashDefault {
+			sig = append(sig, uint8(hashType))
+		}
+		return sig
+	}
+
+	tx := witnessTx(t, nil, [][]byte{sign(script.SigHashDefault)})
+	if err := tx.VerifyInputPrevOuts(0, prevOuts, script.ConsensusFlags); err != nil {
+		t.Fatal(err)
+	}
+	//The digest commits to every spent output, which VerifyInput doesn't have
+	if err := tx.VerifyInput(0, prevOut); err != ErrPrevOutsRequired {
+		t.Errorf("without the spent outputs: %v", err)
+	}
+	if err := tx.VerifyInputPrevOuts(0, []TransOutput{NewTransOutput(100001, prevOut.Script())}, script.ConsensusFlags); err != script.ErrSchnorrSig {
+		t.Errorf("wrong amount: %v", err)
+	}
+	//Nodes that predate taproot don't look at the witness
+	garbage := witnessTx(t, nil, [][]byte{{1}})
+	if err := garbage.VerifyInputFlags(0, prevOut, script.ConsensusFlags&^script.VerifyTaproot); err != nil {
+		t.Errorf("taproot disabled: %v", err)
+	}
+
+	acp := witnessTx(t, nil, [][]byte{sign(script.SigHashAll | script.SigHashAnyOneCanPay)})
+	if err := acp.VerifyInput(0, prevOut); err != nil {
+		t.Errorf("ANYONECANPAY: %v", err)
+	}
+
+	sig := sign(script.SigHashDefault)
+	cases := []struct {
+		name    string
+		witness [][]byte
+		want    error
+	}{
+		{"explicit default hash type", [][]byte{append(sig, script.SigHashDefault)}, script.ErrSchnorrSigHashType},
+		{"undefined hash type", [][]byte{append(sig, 0x84)}, script.ErrSchnorrSigHashType},
+		{"short signature", [][]byte{sig[:63]}, script.ErrSchnorrSigSize},
+		//The annex is committed to, so the signature made without one no longer matches
+		{"annex", [][]byte{sig, {script.TaprootAnnexTag}}, script.ErrSchnorrSig},
+	}
+	for _, c := range cases {
+		tx := witnessTx(t, nil, c.witness)
+		if err := tx.VerifyInputPrevOuts(0, prevOuts, script.ConsensusFlags); err != c.want {
+			t.Errorf("%v: %v", c.name, err)
+		}
+	}
+}
+
+//Version 1 programs nested in P2SH aren't taproot, they're left for future soft forks
+func TestVerifyNestedWitnessV1(t *testing.T) {
+	redeem := p2tr(xOnly(testKey))
+	spk := append(append([]byte{script.OP_HASH160, 20}, hashes.Hash160(redeem)...), script.OP_EQUAL)
+	tx := witnessTx(t, script.PushData(redeem), [][]byte{{1}})
+	if err := tx.VerifyInput(0, NewTransOutput(1, spk)); err != nil {
+		t.Error(err)
+	}
+	flags := script.ConsensusFlags | script.VerifyDiscourageUpgradableWitnessProgram
+	if err := tx.VerifyInputFlags(0, NewTransOutput(1, spk), flags); err != script.ErrDiscourageUpgradableWitnessProgram {
+		t.Errorf("discouraged: %v", err)
+	}
+}
+
+func TestVerifyTapscript(t *testing.T) {
+	d1, d2 := testKey, big.NewInt(0x5eed2)
+	pub1, pub2 := script.PushData(xOnly(d1)), script.PushData(xOnly(d2))
+	//Every leaf gets a sibling so the control block carries a path
+	sibling := script.TapLeafHash(script.TaprootLeafTapscript, []byte{script.OP_1})
+
+	//sign signs the spend of the leaf with key d, codeSepPos being the last OP_CODESEPARATOR executed
+	type signer func(d *big.Int, codeSepPos uint32) []byte
+	cases := []struct {
+		name    string
+		version uint8
+		leaf    []byte
+		stack   func(sign signer) [][]byte
+		want    error
+	}{
+		{"checksig", 0xc0, append(pub1, script.OP_CHECKSIG), func(sign signer) [][]byte {
+			return [][]byte{sign(d1, 0xffffffff)}
+		}, nil},
+		{"wrong key", 0xc0, append(pub1, script.OP_CHECKSIG), func(sign signer) [][]byte {
+			return [][]byte{sign(d2, 0xffffffff)}
+		}, script.ErrSchnorrSig},
+		{"empty signature", 0xc0, append(pub1, script.OP_CHECKSIG, script.OP_NOT), func(sign signer) [][]byte {
+			return [][]byte{nil}
+		}, nil},
+		{"checksigadd", 0xc0, append(append(append(pub1, script.OP_CHECKSIG), pub2...), script.OP_CHECKSIGADD, script.OP_2, script.OP_NUMEQUAL), func(sign signer) [][]byte {
+			return [][]byte{sign(d2, 0xffffffff), sign(d1, 0xffffffff)}
+		}, nil},
+		{"checksigadd one signature", 0xc0, append(append(append(pub1, script.OP_CHECKSIG), pub2...), script.OP_CHECKSIGADD, script.OP_2, script.OP_NUMEQUAL), func(sign signer) [][]byte {
+			return [][]byte{nil, sign(d1, 0xffffffff)}
+		}, script.ErrEvalFalse},
+		//The position counts opcodes, pushes included
+		{"codeseparator", 0xc0, append(append([]byte{script.OP_1, script.OP_DROP, script.OP_CODESEPARATOR}, pub1...), script.OP_CHECKSIG), func(sign signer) [][]byte {
+			return [][]byte{sign(d1, 2)}
+		}, nil},
+		{"codeseparator not committed to", 0xc0, append(append([]byte{script.OP_1, script.OP_DROP, script.OP_CODESEPARATOR}, pub1...), script.OP_CHECKSIG), func(sign signer) [][]byte {
+			return [][]byte{sign(d1, 0xffffffff)}
+		}, script.ErrSchnorrSig},
+		{"checkmultisig", 0xc0, []byte{script.OP_0, script.OP_0, script.OP_0, script.OP_CHECKMULTISIG}, func(sign signer) [][]byte {
+			return nil
+		}, script.ErrTapscriptCheckMultiSig},
+		{"minimal if", 0xc0, []byte{script.OP_IF, script.OP_1, script.OP_ENDIF}, func(sign signer) [][]byte {
+			return [][]byte{{2}}
+		}, script.ErrMinimalIf},
+		//OP_SUCCESS80 wins over anything else in the script, even a truncated push
+		{"op_success", 0xc0, []byte{script.OP_RETURN, 0x50, script.OP_PUSHDATA1}, func(sign signer) [][]byte {
+			return nil
+		}, nil},
+		{"unknown leaf version", 0xc2, []byte{script.OP_RETURN}, func(sign signer) [][]byte {
+			return nil
+		}, nil},
+		{"validation weight", 0xc0, append(append(pub1, bytes.Repeat([]byte{script.OP_2DUP, script.OP_CHECKSIGVERIFY}, 10)...), script.OP_CHECKSIG), func(sign signer) [][]byte {
+			return [][]byte{sign(d1, 0xffffffff)}
+		}, script.ErrTapscriptValidationWeight},
+	}
+	for _, c := range cases {
+		leafHash := script.TapLeafHash(c.version, c.leaf)
+		outputKey, odd, err := script.TweakPubKey(xOnly(d1), script.TapBranchHash(leafHash, sibling))
+		if err != nil {
+			t.Fatal(err)
+		}
+		control := append([]byte{c.version}, xOnly(d1)...)
+		if odd {
+			control[0] |= 1
+		}
+		control = append(control, sibling...)
+		prevOuts := []TransOutput{NewTransOutput(50000, p2tr(outputKey))}
+
+		sign := func(d *big.Int, codeSepPos uint32) []byte {
+			unsigned := witnessTx(t, nil, [][]byte{{0}})
+			leaf := &TapLeafExt{CodeSepPos: codeSepPos}
+			copy(leaf.LeafHash[:], leafHash)
+			hash, err := unsigned.TaprootSigHash(0, prevOuts, script.SigHashDefault, leaf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return testSignSchnorr(d, hash)
+		}
+		tx := witnessTx(t, nil, append(c.stack(sign), c.leaf, control))
+		if err := tx.VerifyInputPrevOuts(0, prevOuts, script.ConsensusFlags); err != c.want {
+			t.Errorf("%v: %v", c.name, err)
+		}
+		if c.want != nil {
+			continue
+		}
+
+		//A control block for another tree or with a torn off node doesn't commit to the leaf
+		other := append(append([]byte{}, control[:33]...), leafHash...)
+		tx = witnessTx(t, nil, append(c.stack(sign), c.leaf, other))
+		if err := tx.VerifyInputPrevOuts(0, prevOuts, script.ConsensusFlags); err != script.ErrWitnessProgramMismatch {
+			t.Errorf("%v, wrong path: %v", c.name, err)
+		}
+		tx = witnessTx(t, nil, append(c.stack(sign), c.leaf, control[:len(control)-1]))
+		if err := tx.VerifyInputPrevOuts(0, prevOuts, script.ConsensusFlags); err != script.ErrControlBlockSize {
+			t.Errorf("%v, short control block: %v", c.name, err)
+		}
+	}
+}
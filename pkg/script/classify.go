@@ -0,0 +1,179 @@
+package script
+
+//Kind of output script, following Bitcoin Core's TxoutType
+type ScriptClass int
+
+const (
+	NonStandard ScriptClass = iota
+	PubKey
+	PubKeyHash
+	ScriptHash
+	MultiSig
+	NullData
+	WitnessV0PubKeyHash
+	WitnessV0ScriptHash
+	WitnessV1Taproot
+	WitnessUnknown
+)
+
+var scriptClassNames = map[ScriptClass]string{
+	NonStandard:         "nonstandard",
+	PubKey:              "pubkey",
+	PubKeyHash:          "pubkeyhash",
+	ScriptHash:          "scripthash",
+	MultiSig:            "multisig",
+	NullData:            "nulldata",
+	WitnessV0PubKeyHash: "witness_v0_keyhash",
+	WitnessV0ScriptHash: "witness_v0_scripthash",
+	WitnessV1Taproot:    "witness_v1_taproot",
+	WitnessUnknown:      "witness_unknown",
+}
+
+//Name Bitcoin Core uses for the type in decodescript
+func (c ScriptClass) String() string {
+	if name, ok := scriptClassNames[c]; ok {
+		return name
+	}
+	return "nonstandard"
+}
+
+//Result of Classify
+type Classification struct {
+	Class ScriptClass
+	//Pubkeys for PubKey and MultiSig, the hash for PubKeyHash and ScriptHash,
+	//the witness program for witness outputs and the pushes of a NullData output
+	Data [][]byte
+	//Witness version, -1 for non witness scripts
+	WitnessVersion int
+	//m-of-n for MultiSig
+	Required int
+	Total    int
+}
+
+//Classifies an output script and extracts what it commits to
+func Classify(script []byte) Classification {
+	c := Classification{Class: NonStandard, WitnessVersion: -1}
+
+	if isPayToScriptHash(script) {
+		c.Class = ScriptHash
+		c.Data = [][]byte{script[2:22]}
+		return c
+	}
+
+	if version, program, ok := WitnessProgram(script); ok {
+		c.WitnessVersion = version
+		c.Data = [][]byte{program}
+		switch {
+		case version == 0 && len(program) == 20:
+			c.Class = WitnessV0PubKeyHash
+		case version == 0 && len(program) == 32:
+			c.Class = WitnessV0ScriptHash
+		case version == 0:
+			//Other v0 program sizes can never be spent
+			c.Class = NonStandard
+		case version == 1 && len(program) == 32:
+			c.Class = WitnessV1Taproot
+		default:
+			c.Class = WitnessUnknown
+		}
+		return c
+	}
+
+	if len(script) > 0 && script[0] == OP_RETURN {
+		tokens, err := Parse(script[1:])
+		if err != nil {
+			return c
+		}
+		for _, t := range tokens {
+			if t.Opcode > OP_16 {
+				return c
+			}
+			c.Data = append(c.Data, t.Data)
+		}
+		c.Class = NullData
+		return c
+	}
+
+	tokens, err := Parse(script)
+	if err != nil {
+		return c
+	}
+
+	//<pubkey> OP_CHECKSIG, pushed directly
+	if len(tokens) == 2 && tokens[1].Opcode == OP_CHECKSIG && tokens[0].Opcode == byte(len(tokens[0].Data)) && isPubKey(tokens[0]) {
+		c.Class = PubKey
+		c.Data = [][]byte{tokens[0].Data}
+		return c
+	}
+
+	//OP_DUP OP_HASH160 <20 bytes> OP_EQUALVERIFY OP_CHECKSIG
+	if len(script) == 25 && script[0] == OP_DUP && script[1] == OP_HASH160 && script[2] == 20 &&
+		script[23] == OP_EQUALVERIFY && script[24] == OP_CHECKSIG {
+		c.Class = PubKeyHash
+		c.Data = [][]byte{script[3:23]}
+		return c
+	}
+
+	//OP_m <pubkey>... OP_n OP_CHECKMULTISIG
+	if len(tokens) >= 4 && tokens[len(tokens)-1].Opcode == OP_CHECKMULTISIG {
+		m, okm := smallInt(tokens[0].Opcode)
+		n, okn := smallInt(tokens[len(tokens)-2].Opcode)
+		keys := tokens[1 : len(tokens)-2]
+		if !okm || !okn || m < 1 || m > n || n != len(keys) {
+			return c
+		}
+		for _, k := range keys {
+			if !isPubKey(k) {
+				return c
+			}
+			c.Data = append(c.Data, k.Data)
+		}
+		c.Class = MultiSig
+		c.Required = m
+		c.Total = n
+		return c
+	}
+	return c
+}
+
+//Decodes a witness program: a version opcode followed by a single 2 to 40 byte push
+func WitnessProgram(script []byte) (int, []byte, bool) {
+	if len(script) < 4 || len(script) > 42 {
+		return 0, nil, false
+	}
+	if script[0] != OP_0 && (script[0] < OP_1 || script[0] > OP_16) {
+		return 0, nil, false
+	}
+	if int(script[1])+2 != len(script) {
+		return 0, nil, false
+	}
+	version, _ := smallInt(script[0])
+	return version, script[2:], true
+}
+
+//OP_HASH160 <20 bytes> OP_EQUAL
+func isPayToScriptHash(script []byte) bool {
+	return len(script) == 23 && script[0] == OP_HASH160 && script[1] == 20 && script[22] == OP_EQUAL
+}
+
+//Size and prefix check of a serialized public key, the point itself isn't validated
+func isPubKey(t Token) bool {
+	switch len(t.Data) {
+	case 33:
+		return t.Data[0] == 0x02 || t.Data[0] == 0x03
+	case 65:
+		return t.Data[0] == 0x04 || t.Data[0] == 0x06 || t.Data[0] == 0x07
+	}
+	return false
+}
+
+//Value of OP_0 and OP_1 through OP_16
+func smallInt(op byte) (int, bool) {
+	if op == OP_0 {
+		return 0, true
+	}
+	if op >= OP_1 && op <= OP_16 {
+		return int(op-OP_1) + 1, true
+	}
+	return 0, false
+}
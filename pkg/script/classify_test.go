@@ -0,0 +1,112 @@
+package script
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	hash20 := strings.Repeat("ab", 20)
+	hash32 := strings.Repeat("cd", 32)
+	key33 := "02" + strings.Repeat("11", 32)
+	key65 := "04" + strings.Repeat("22", 64)
+	cases := []struct {
+		name    string
+		script  string
+		class   ScriptClass
+		version int
+		data    []string
+	}{
+		{"p2pk compressed", "21" + key33 + "ac", PubKey, -1, []string{key33}},
+		{"p2pk uncompressed", "41" + key65 + "ac", PubKey, -1, []string{key65}},
+		{"p2pkh", "76a914" + hash20 + "88ac", PubKeyHash, -1, []string{hash20}},
+		{"p2sh", "a914" + hash20 + "87", ScriptHash, -1, []string{hash20}},
+		{"1-of-2 multisig", "51" + "21" + key33 + "41" + key65 + "52ae", MultiSig, -1, []string{key33, key65}},
+		{"op_return", "6a0b68656c6c6f20776f726c64", NullData, -1, []string{"68656c6c6f20776f726c64"}},
+		{"bare op_return", "6a", NullData, -1, nil},
+		{"p2wpkh", "0014" + hash20, WitnessV0PubKeyHash, 0, []string{hash20}},
+		{"p2wsh", "0020" + hash32, WitnessV0ScriptHash, 0, []string{hash32}},
+		{"p2tr", "5120" + hash32, WitnessV1Taproot, 1, []string{hash32}},
+		{"v1 short program", "5114" + hash20, WitnessUnknown, 1, []string{hash20}},
+		{"v16 program", "6002abcd", WitnessUnknown, 16, []string{"abcd"}},
+		{"empty", "", NonStandard, -1, nil},
+	}
+	for _, c := range cases {
+		got := Classify(fromHex(t, c.script))
+		if got.Class != c.class || got.WitnessVersion != c.version || len(got.Data) != len(c.data) {
+			t.Errorf("%v: %v v%v with %v items", c.name, got.Class, got.WitnessVersion, len(got.Data))
+			continue
+		}
+		for n, d := range c.data {
+			if !bytes.Equal(got.Data[n], fromHex(t, d)) {
+				t.Errorf("%v: item %v is %x", c.name, n, got.Data[n])
+			}
+		}
+	}
+
+	if c := Classify(fromHex(t, "52"+"21"+key33+"21"+key33+"21"+key33+"53ae")); c.Required != 2 || c.Total != 3 {
+		t.Errorf("multisig %v-of-%v", c.Required, c.Total)
+	}
+}
+
+func TestClassifyNonStandard(t *testing.T) {
+	hash20 := strings.Repeat("ab", 20)
+	key33 := "02" + strings.Repeat("11", 32)
+	cases := []struct{ name, script string }{
+		//Core only matches keys pushed with the opcode equal to their size
+		{"p2pk pushdata1", "4c21" + key33 + "ac"},
+		{"p2pk bad prefix", "21" + "05" + strings.Repeat("11", 32) + "ac"},
+		{"p2pk short key", "20" + strings.Repeat("11", 32) + "ac"},
+		{"p2pkh trailing byte", "76a914" + hash20 + "88ac00"},
+		{"p2sh pushdata1", "a94c14" + hash20 + "87"},
+		{"v0 program of 21 bytes", "0015" + hash20 + "ab"},
+		{"v0 program of 2 bytes", "0002abcd"},
+		{"program of 41 bytes", "5129" + hash20 + hash20 + "ab"},
+		{"program of 1 byte", "5101ab"},
+		{"multisig m > n", "53" + "21" + key33 + "21" + key33 + "52ae"},
+		{"multisig m = 0", "00" + "21" + key33 + "51ae"},
+		{"multisig n != keys", "51" + "21" + key33 + "21" + key33 + "53ae"},
+		{"multisig bad key", "51" + "21" + key33 + "20" + strings.Repeat("11", 32) + "52ae"},
+		{"multisig non small int", "0101" + "21" + key33 + "51ae"},
+		{"op_return then opcode", "6a0101" + "76"},
+		{"op_return then checksig", "6aac"},
+		{"op_return truncated push", "6a05abcd"},
+		{"truncated push", "4c"},
+		{"checksig", "ac"},
+	}
+	for _, c := range cases {
+		if got := Classify(fromHex(t, c.script)); got.Class != NonStandard {
+			t.Errorf("%v: %v", c.name, got.Class)
+		}
+	}
+
+	//A v0 program of the wrong size is still a witness program
+	if c := Classify(fromHex(t, "0015"+hash20+"ab")); c.WitnessVersion != 0 {
+		t.Errorf("v0 program of 21 bytes: version %v", c.WitnessVersion)
+	}
+	//OP_RESERVED and the small numbers count as pushes, like Core's IsPushOnly
+	if c := Classify(fromHex(t, "6a00506051")); c.Class != NullData || len(c.Data) != 4 {
+		t.Errorf("op_return with push opcodes: %v with %v items", c.Class, len(c.Data))
+	}
+}
+
+func TestScriptClassString(t *testing.T) {
+	for class, name := range map[ScriptClass]string{
+		NonStandard:         "nonstandard",
+		PubKey:              "pubkey",
+		PubKeyHash:          "pubkeyhash",
+		ScriptHash:          "scripthash",
+		MultiSig:            "multisig",
+		NullData:            "nulldata",
+		WitnessV0PubKeyHash: "witness_v0_keyhash",
+		WitnessV0ScriptHash: "witness_v0_scripthash",
+		WitnessV1Taproot:    "witness_v1_taproot",
+		WitnessUnknown:      "witness_unknown",
+		ScriptClass(99):     "nonstandard",
+	} {
+		if s := class.String(); s != name {
+			t.Errorf("%d: %v", class, s)
+		}
+	}
+}
@@ -0,0 +1,1098 @@
+package script
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/lirancohen/blockparser/pkg/hashes"
+)
+
+//Script verification flags, named after Bitcoin Core's SCRIPT_VERIFY_* flags
+type Flags uint32
+
+const VerifyNone Flags = 0
+
+const (
+	//Evaluate P2SH redeem scripts (BIP16)
+	VerifyP2SH Flags = 1 << iota
+	//Signatures must be strict DER with a defined hash type, pubkeys compressed or uncompressed
+	VerifyStrictEnc
+	//Signatures must be strict DER (BIP66)
+	VerifyDERSig
+	//S must be in the lower half of the curve order (BIP62)
+	VerifyLowS
+	//The extra CHECKMULTISIG argument must be empty (BIP147)
+	VerifyNullDummy
+	//scriptSig may only push data
+	VerifySigPushOnly
+	//Pushes and numbers must use their smallest encoding
+	VerifyMinimalData
+	//Fail on OP_NOP1 and OP_NOP4-10, reserved for soft forks
+	VerifyDiscourageUpgradableNops
+	//Exactly one item must be left on the stack, requires VerifyP2SH
+	VerifyCleanStack
+	//Enable OP_CHECKLOCKTIMEVERIFY (BIP65)
+	VerifyCheckLockTimeVerify
+	//Enable OP_CHECKSEQUENCEVERIFY (BIP112)
+	VerifyCheckSequenceVerify
+	//Failed signature checks must be given empty signatures
+	VerifyNullFail
+	//Fail on OP_CODESEPARATOR and on signatures found in the scriptCode
+	VerifyConstScriptCode
+	//Check witness programs instead of treating them as anyone can spend (BIP141)
+	VerifyWitness
+	//OP_IF and OP_NOTIF in witness scripts only take an empty item or 0x01
+	VerifyMinimalIf
+	//Witness scripts may only use compressed public keys
+	VerifyWitnessPubKeyType
+	//Fail on witness versions reserved for soft forks instead of letting them pass
+	VerifyDiscourageUpgradableWitnessProgram
+	//Check witness v1 programs as taproot outputs (BIP341, BIP342), requires VerifyWitness
+	VerifyTaproot
+)
+
+//Rules every block since the last script soft fork enforces.
+//Early blocks predate some of them, use fewer flags to check those.
+const ConsensusFlags = VerifyP2SH | VerifyDERSig | VerifyNullDummy | VerifyCheckLockTimeVerify |
+	VerifyCheckSequenceVerify | VerifyWitness | VerifyTaproot
+
+const (
+	MaxScriptSize         = 10000
+	MaxScriptElementSize  = 520
+	MaxOpsPerScript       = 201
+	MaxPubKeysPerMultiSig = 20
+	MaxStackSize          = 1000
+
+	//Lock times below this are block heights, above it unix timestamps
+	LockTimeThreshold = 500000000
+
+	//BIP68 relative lock time fields of the input sequence number
+	SequenceLockTimeDisableFlag = 1 << 31
+	SequenceLockTimeTypeFlag    = 1 << 22
+	SequenceLockTimeMask        = 0x0000ffff
+)
+
+var (
+	ErrEvalFalse                = errors.New("script evaluated without error but finished with a false/empty top stack element")
+	ErrOpReturn                 = errors.New("OP_RETURN was encountered")
+	ErrScriptSize               = errors.New("script is too big")
+	ErrPushSize                 = errors.New("push value size limit exceeded")
+	ErrOpCount                  = errors.New("operation limit exceeded")
+	ErrStackSize                = errors.New("stack size limit exceeded")
+	ErrSigCount                 = errors.New("signature count negative or greater than pubkey count")
+	ErrPubKeyCount              = errors.New("pubkey count negative or limit exceeded")
+	ErrVerify                   = errors.New("script failed an OP_VERIFY operation")
+	ErrEqualVerify              = errors.New("script failed an OP_EQUALVERIFY operation")
+	ErrCheckSigVerify           = errors.New("script failed an OP_CHECKSIGVERIFY operation")
+	ErrCheckMultiSigVerify      = errors.New("script failed an OP_CHECKMULTISIGVERIFY operation")
+	ErrNumEqualVerify           = errors.New("script failed an OP_NUMEQUALVERIFY operation")
+	ErrBadOpcode                = errors.New("opcode missing or not understood")
+	ErrDisabledOpcode           = errors.New("attempted to use a disabled opcode")
+	ErrInvalidStackOperation    = errors.New("operation not valid with the current stack size")
+	ErrInvalidAltstackOperation = errors.New("operation not valid with the current altstack size")
+	ErrUnbalancedConditional    = errors.New("invalid OP_IF construction")
+	ErrNegativeLockTime         = errors.New("negative locktime")
+	ErrUnsatisfiedLockTime      = errors.New("locktime requirement not satisfied")
+	ErrSigHashType              = errors.New("signature hash type missing or not understood")
+	ErrSigDER                   = errors.New("non-canonical DER signature")
+	ErrMinimalData              = errors.New("data push larger than necessary")
+	ErrSigPushOnly              = errors.New("only push operators allowed in signatures")
+	ErrSigHighS                 = errors.New("non-canonical signature: S value is unnecessarily high")
+	ErrSigNullDummy             = errors.New("dummy CHECKMULTISIG argument must be zero")
+	ErrPubKeyType               = errors.New("public key is neither compressed or uncompressed")
+	ErrCleanStack               = errors.New("stack size must be exactly one after execution")
+	ErrNullFail                 = errors.New("signature must be zero for failed CHECK(MULTI)SIG operation")
+	ErrDiscourageUpgradableNops = errors.New("NOPx reserved for soft-fork upgrades")
+	ErrOpCodeSeparator          = errors.New("using OP_CODESEPARATOR in non-witness script")
+	ErrSigFindAndDelete         = errors.New("signature is found in scriptCode")
+	ErrNumOverflow              = errors.New("script number overflow")
+)
+
+//BIP141 witness program failures and the rules witness scripts add
+var (
+	ErrWitnessProgramWrongLength          = errors.New("witness program has incorrect length")
+	ErrWitnessProgramMismatch             = errors.New("witness program hash mismatch")
+	ErrWitnessMalleated                   = errors.New("witness requires empty scriptSig")
+	ErrWitnessMalleatedP2SH               = errors.New("witness requires only-redeemscript scriptSig")
+	ErrWitnessUnexpected                  = errors.New("witness provided for non-witness script")
+	ErrWitnessPubKeyType                  = errors.New("using non-compressed keys in segwit")
+	ErrMinimalIf                          = errors.New("OP_IF/NOTIF argument must be minimal")
+	ErrDiscourageUpgradableWitnessProgram = errors.New("witness version reserved for soft-fork upgrades")
+)
+
+//Which signature hash a script's signatures commit to
+type sigVersion int
+
+const (
+	sigVersionBase sigVersion = iota
+	//BIP143, scripts run from a witness v0 program
+	sigVersionWitnessV0
+	//BIP342, leaf scripts of a taproot script path spend
+	sigVersionTapscript
+)
+
+//What the engine needs from the spending transaction
+type SigChecker interface {
+	//sig still ends with its hash type byte, scriptCode is the script signatures commit to
+	CheckSig(sig, pubKey, scriptCode []byte) bool
+	//Like CheckSig for scripts run from a witness v0 program, which sign the BIP143 digest
+	CheckWitnessSig(sig, pubKey, scriptCode []byte) bool
+	//OP_CHECKLOCKTIMEVERIFY against the transaction lock time
+	CheckLockTime(lockTime int64) bool
+	//OP_CHECKSEQUENCEVERIFY against the input sequence number
+	CheckSequence(sequence int64) bool
+	//BIP340 signature of a taproot spend, sig is the 64 bytes without a hash type and hashType
+	//is already known to be defined. tap is nil for key path spends. Returns ErrSchnorrSig for
+	//a wrong signature, other errors when the signature hash can't be computed.
+	CheckSchnorrSig(sig, pubKey []byte, hashType uint32, tap *TapscriptData) error
+}
+
+//Checks that scriptSig satisfies scriptPubKey, nil when the spend is valid
+func Verify(scriptSig, scriptPubKey []byte, flags Flags, checker SigChecker) error {
+	return VerifyWithWitness(scriptSig, scriptPubKey, nil, flags, checker)
+}
+
+//Like Verify for an input with a witness, which VerifyWitness checks against witness programs
+func VerifyWithWitness(scriptSig, scriptPubKey []byte, witness [][]byte, flags Flags, checker SigChecker) error {
+	if flags&VerifySigPushOnly != 0 && !IsPushOnly(scriptSig) {
+		return ErrSigPushOnly
+	}
+
+	var s stack
+	if err := eval(&s, scriptSig, flags, checker, sigVersionBase); err != nil {
+		return err
+	}
+	//The redeem script runs against what scriptSig left, not what scriptPubKey left
+	saved := append(stack{}, s...)
+	if err := eval(&s, scriptPubKey, flags, checker, sigVersionBase); err != nil {
+		return err
+	}
+	if len(s) == 0 || !asBool(s.peek(0)) {
+		return ErrEvalFalse
+	}
+
+	hadWitness := false
+	if flags&VerifyWitness != 0 {
+		if version, program, ok := WitnessProgram(scriptPubKey); ok {
+			hadWitness = true
+			//Anything in scriptSig would be malleable, the witness isn't covered by the txid
+			if len(scriptSig) != 0 {
+				return ErrWitnessMalleated
+			}
+			if err := verifyWitnessProgram(witness, version, program, false, flags, checker); err != nil {
+				return err
+			}
+			//The program left its own clean stack, the one element here only has to pass CleanStack
+			s = s[:1]
+		}
+	}
+
+	if flags&VerifyP2SH != 0 && isPayToScriptHash(scriptPubKey) {
+		if !IsPushOnly(scriptSig) {
+			return ErrSigPushOnly
+		}
+		s = saved
+		//scriptSig is push only and scriptPubKey succeeded, so the stack can't be empty
+		redeem := s.pop()
+		if err := eval(&s, redeem, flags, checker, sigVersionBase); err != nil {
+			return err
+		}
+		if len(s) == 0 || !asBool(s.peek(0)) {
+			return ErrEvalFalse
+		}
+		if flags&VerifyWitness != 0 {
+			if version, program, ok := WitnessProgram(redeem); ok {
+				hadWitness = true
+				if !bytes.Equal(scriptSig, pushBytes(redeem)) {
+					return ErrWitnessMalleatedP2SH
+				}
+				if err := verifyWitnessProgram(witness, version, program, true, flags, checker); err != nil {
+					return err
+				}
+				s = s[:1]
+			}
+		}
+	}
+
+	if flags&VerifyCleanStack != 0 && flags&VerifyP2SH != 0 && len(s) != 1 {
+		return ErrCleanStack
+	}
+	if flags&VerifyWitness != 0 && !hadWitness && len(witness) != 0 {
+		return ErrWitnessUnexpected
+	}
+	return nil
+}
+
+//Runs a witness program (BIP141). Version 0 programs are P2WPKH or P2WSH, version 1 programs
+//of 32 bytes are taproot outputs unless nested in P2SH. Other programs are left for future
+//soft forks and pass unless VerifyDiscourageUpgradableWitnessProgram is set.
+func verifyWitnessProgram(witness [][]byte, version int, program []byte, p2sh bool, flags Flags, checker SigChecker) error {
+	if version == 1 && len(program) == 32 && !p2sh {
+		//Nodes that predate the soft fork let taproot spends through
+		if flags&VerifyTaproot == 0 {
+			return nil
+		}
+		return verifyTaproot(witness, program, flags, checker)
+	}
+	if version != 0 {
+		if flags&VerifyDiscourageUpgradableWitnessProgram != 0 {
+			return ErrDiscourageUpgradableWitnessProgram
+		}
+		return nil
+	}
+
+	var s stack
+	var script []byte
+	switch len(program) {
+	case 20:
+		//P2WPKH runs the P2PKH script against <sig> <pubkey>
+		if len(witness) != 2 {
+			return ErrWitnessProgramMismatch
+		}
+		script = append([]byte{OP_DUP, OP_HASH160, 20}, program...)
+		script = append(script, OP_EQUALVERIFY, OP_CHECKSIG)
+		s = stack{witness[0], witness[1]}
+	case 32:
+		//P2WSH runs the last witness item against the others
+		if len(witness) == 0 {
+			return ErrWitnessEmpty
+		}
+		script = witness[len(witness)-1]
+		if !bytes.Equal(hashes.Sha256(script), program) {
+			return ErrWitnessProgramMismatch
+		}
+		s = append(stack{}, witness[:len(witness)-1]...)
+	default:
+		return ErrWitnessProgramWrongLength
+	}
+	return executeWitnessScript(s, &engine{script: script, flags: flags, checker: checker, sigVersion: sigVersionWitnessV0})
+}
+
+//Runs the script of e against the witness items in s, which must leave exactly one true element behind
+func executeWitnessScript(s stack, e *engine) error {
+	for _, item := range s {
+		if len(item) > MaxScriptElementSize {
+			return ErrPushSize
+		}
+	}
+	e.stack = &s
+	if err := e.run(); err != nil {
+		return err
+	}
+	//Witness scripts must leave exactly one element behind, whatever the flags
+	if len(s) != 1 {
+		return ErrCleanStack
+	}
+	if !asBool(s.peek(0)) {
+		return ErrEvalFalse
+	}
+	return nil
+}
+
+//Reports whether the script only pushes data, false for malformed scripts
+func IsPushOnly(script []byte) bool {
+	t := NewTokenizer(script)
+	for t.Next() {
+		//OP_RESERVED counts as a push here, as it does in Bitcoin Core
+		if t.Token().Opcode > OP_16 {
+			return false
+		}
+	}
+	return t.Err() == nil
+}
+
+func isDisabled(op byte) bool {
+	switch op {
+	case OP_CAT, OP_SUBSTR, OP_LEFT, OP_RIGHT, OP_INVERT, OP_AND, OP_OR, OP_XOR,
+		OP_2MUL, OP_2DIV, OP_MUL, OP_DIV, OP_MOD, OP_LSHIFT, OP_RSHIFT:
+		return true
+	}
+	return false
+}
+
+//Whether a push uses the smallest opcode able to push its data
+func isMinimalPush(op byte, data []byte) bool {
+	switch n := len(data); {
+	case n == 0:
+		return op == OP_0
+	case n == 1 && data[0] >= 1 && data[0] <= 16:
+		return op == OP_1+data[0]-1
+	case n == 1 && data[0] == 0x81:
+		return op == OP_1NEGATE
+	case n < OP_PUSHDATA1:
+		return int(op) == n
+	case n <= 0xff:
+		return op == OP_PUSHDATA1
+	case n <= 0xffff:
+		return op == OP_PUSHDATA2
+	}
+	return true
+}
+
+//How CScript() << data encodes a push, which is what FindAndDelete searches for
+func pushBytes(data []byte) []byte {
+	n := len(data)
+	var s []byte
+	switch {
+	case n < OP_PUSHDATA1:
+		s = []byte{byte(n)}
+	case n <= 0xff:
+		s = []byte{OP_PUSHDATA1, byte(n)}
+	case n <= 0xffff:
+		s = []byte{OP_PUSHDATA2, byte(n), byte(n >> 8)}
+	default:
+		s = []byte{OP_PUSHDATA4, byte(n), byte(n >> 8), byte(n >> 16), byte(n >> 24)}
+	}
+	return append(s, data...)
+}
+
+//Removes every occurrence of pattern that starts on an opcode boundary, returning the script and how many were removed
+func findAndDelete(script, pattern []byte) ([]byte, int) {
+	if len(pattern) == 0 {
+		return script, 0
+	}
+	var out []byte
+	found, pos, last := 0, 0, 0
+	for {
+		out = append(out, script[last:pos]...)
+		for bytes.HasPrefix(script[pos:], pattern) {
+			pos += len(pattern)
+			found++
+		}
+		last = pos
+
+		t := NewTokenizer(script[pos:])
+		if !t.Next() {
+			break
+		}
+		pos += t.offset
+	}
+	if found == 0 {
+		return script, 0
+	}
+	return append(out, script[last:]...), found
+}
+
+//State of a single script execution
+type engine struct {
+	stack *stack
+	alt   stack
+	//One entry per open IF, false when the branch isn't executing
+	cond    []bool
+	opCount int
+	script  []byte
+	//Signatures commit to the script after the last executed OP_CODESEPARATOR
+	codeStart  int
+	flags      Flags
+	checker    SigChecker
+	sigVersion sigVersion
+	//Position of the opcode being executed, counting pushes
+	opIndex int
+	//Tapscript only, what its signatures commit to and the validation weight left for them
+	tap        *TapscriptData
+	weightLeft int
+}
+
+//Runs script against the stack
+func eval(s *stack, script []byte, flags Flags, checker SigChecker, sv sigVersion) error {
+	e := &engine{stack: s, script: script, flags: flags, checker: checker, sigVersion: sv}
+	return e.run()
+}
+
+func (e *engine) run() error {
+	s, script, flags, sv := e.stack, e.script, e.flags, e.sigVersion
+	//Tapscript drops the script size and opcode limits, the validation weight replaces them
+	if len(script) > MaxScriptSize && sv != sigVersionTapscript {
+		return ErrScriptSize
+	}
+
+	t := NewTokenizer(script)
+	for e.opIndex = 0; t.Next(); e.opIndex++ {
+		tok := t.Token()
+		op := tok.Opcode
+		exec := e.executing()
+
+		if len(tok.Data) > MaxScriptElementSize {
+			return ErrPushSize
+		}
+		if op > OP_16 && sv != sigVersionTapscript {
+			if e.opCount++; e.opCount > MaxOpsPerScript {
+				return ErrOpCount
+			}
+		}
+		//Disabled opcodes fail even in a branch that isn't executing
+		if isDisabled(op) {
+			return ErrDisabledOpcode
+		}
+		if op == OP_CODESEPARATOR && flags&VerifyConstScriptCode != 0 && sv == sigVersionBase {
+			return ErrOpCodeSeparator
+		}
+
+		if exec && tok.IsPush() {
+			if e.minimal() && !isMinimalPush(op, tok.Data) {
+				return ErrMinimalData
+			}
+			s.push(append([]byte{}, tok.Data...))
+		} else if exec || (op >= OP_IF && op <= OP_ENDIF) {
+			if err := e.step(tok, exec); err != nil {
+				return err
+			}
+		}
+
+		if len(*s)+len(e.alt) > MaxStackSize {
+			return ErrStackSize
+		}
+	}
+	if t.Err() != nil {
+		return ErrBadOpcode
+	}
+	if len(e.cond) != 0 {
+		return ErrUnbalancedConditional
+	}
+	return nil
+}
+
+func (e *engine) executing() bool {
+	for _, c := range e.cond {
+		if !c {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *engine) minimal() bool {
+	return e.flags&VerifyMinimalData != 0
+}
+
+func (e *engine) need(n int) error {
+	if len(*e.stack) < n {
+		return ErrInvalidStackOperation
+	}
+	return nil
+}
+
+//Executes a single non push opcode
+func (e *engine) step(tok Token, exec bool) error {
+	s := e.stack
+	op := tok.Opcode
+	minimal := e.minimal()
+
+	switch op {
+	case OP_1NEGATE, OP_1, OP_2, OP_3, OP_4, OP_5, OP_6, OP_7, OP_8,
+		OP_9, OP_10, OP_11, OP_12, OP_13, OP_14, OP_15, OP_16:
+		s.pushNum(int64(op) - int64(OP_1-1))
+
+	case OP_NOP:
+
+	case OP_CHECKLOCKTIMEVERIFY:
+		if e.flags&VerifyCheckLockTimeVerify == 0 {
+			//Not enabled, behaves as OP_NOP2
+			return discourageNop(e.flags)
+		}
+		if err := e.need(1); err != nil {
+			return err
+		}
+		//Lock times go up to 2^32-1, so 5 byte numbers are allowed here
+		n, err := s.num(0, minimal, 5)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return ErrNegativeLockTime
+		}
+		if !e.checker.CheckLockTime(n) {
+			return ErrUnsatisfiedLockTime
+		}
+
+	case OP_CHECKSEQUENCEVERIFY:
+		if e.flags&VerifyCheckSequenceVerify == 0 {
+			//Not enabled, behaves as OP_NOP3
+			return discourageNop(e.flags)
+		}
+		if err := e.need(1); err != nil {
+			return err
+		}
+		n, err := s.num(0, minimal, 5)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return ErrNegativeLockTime
+		}
+		//With the disable flag set the opcode behaves as a NOP
+		if n&SequenceLockTimeDisableFlag == 0 && !e.checker.CheckSequence(n) {
+			return ErrUnsatisfiedLockTime
+		}
+
+	case OP_NOP1, OP_NOP4, OP_NOP5, OP_NOP6, OP_NOP7, OP_NOP8, OP_NOP9, OP_NOP10:
+		return discourageNop(e.flags)
+
+	case OP_IF, OP_NOTIF:
+		v := false
+		if exec {
+			if err := e.need(1); err != nil {
+				return ErrUnbalancedConditional
+			}
+			//Policy for witness v0 scripts, consensus for tapscript
+			if e.sigVersion == sigVersionTapscript || (e.sigVersion == sigVersionWitnessV0 && e.flags&VerifyMinimalIf != 0) {
+				if top := s.peek(0); len(top) > 1 || (len(top) == 1 && top[0] != 1) {
+					return ErrMinimalIf
+				}
+			}
+			v = s.popBool()
+			if op == OP_NOTIF {
+				v = !v
+			}
+		}
+		e.cond = append(e.cond, v)
+
+	case OP_ELSE:
+		if len(e.cond) == 0 {
+			return ErrUnbalancedConditional
+		}
+		(e.cond)[len(e.cond)-1] = !(e.cond)[len(e.cond)-1]
+
+	case OP_ENDIF:
+		if len(e.cond) == 0 {
+			return ErrUnbalancedConditional
+		}
+		e.cond = (e.cond)[:len(e.cond)-1]
+
+	case OP_VERIFY:
+		if err := e.need(1); err != nil {
+			return err
+		}
+		if !s.popBool() {
+			return ErrVerify
+		}
+
+	case OP_RETURN:
+		return ErrOpReturn
+
+	case OP_TOALTSTACK:
+		if err := e.need(1); err != nil {
+			return err
+		}
+		e.alt.push(s.pop())
+
+	case OP_FROMALTSTACK:
+		if len(e.alt) < 1 {
+			return ErrInvalidAltstackOperation
+		}
+		s.push(e.alt.pop())
+
+	case OP_2DROP:
+		if err := e.need(2); err != nil {
+			return err
+		}
+		s.pop()
+		s.pop()
+
+	case OP_2DUP:
+		if err := e.need(2); err != nil {
+			return err
+		}
+		a, b := s.peek(1), s.peek(0)
+		s.push(a)
+		s.push(b)
+
+	case OP_3DUP:
+		if err := e.need(3); err != nil {
+			return err
+		}
+		a, b, c := s.peek(2), s.peek(1), s.peek(0)
+		s.push(a)
+		s.push(b)
+		s.push(c)
+
+	case OP_2OVER:
+		if err := e.need(4); err != nil {
+			return err
+		}
+		a, b := s.peek(3), s.peek(2)
+		s.push(a)
+		s.push(b)
+
+	case OP_2ROT:
+		if err := e.need(6); err != nil {
+			return err
+		}
+		a := s.remove(5)
+		b := s.remove(4)
+		s.push(a)
+		s.push(b)
+
+	case OP_2SWAP:
+		if err := e.need(4); err != nil {
+			return err
+		}
+		n := len(*s)
+		(*s)[n-4], (*s)[n-2] = (*s)[n-2], (*s)[n-4]
+		(*s)[n-3], (*s)[n-1] = (*s)[n-1], (*s)[n-3]
+
+	case OP_IFDUP:
+		if err := e.need(1); err != nil {
+			return err
+		}
+		if asBool(s.peek(0)) {
+			s.push(s.peek(0))
+		}
+
+	case OP_DEPTH:
+		s.pushNum(int64(len(*s)))
+
+	case OP_DROP:
+		if err := e.need(1); err != nil {
+			return err
+		}
+		s.pop()
+
+	case OP_DUP:
+		if err := e.need(1); err != nil {
+			return err
+		}
+		s.push(s.peek(0))
+
+	case OP_NIP:
+		if err := e.need(2); err != nil {
+			return err
+		}
+		s.remove(1)
+
+	case OP_OVER:
+		if err := e.need(2); err != nil {
+			return err
+		}
+		s.push(s.peek(1))
+
+	case OP_PICK, OP_ROLL:
+		if err := e.need(2); err != nil {
+			return err
+		}
+		n, err := s.popNum(minimal)
+		if err != nil {
+			return err
+		}
+		if n < 0 || n >= int64(len(*s)) {
+			return ErrInvalidStackOperation
+		}
+		v := s.peek(int(n))
+		if op == OP_ROLL {
+			s.remove(int(n))
+		}
+		s.push(v)
+
+	case OP_ROT:
+		if err := e.need(3); err != nil {
+			return err
+		}
+		s.push(s.remove(2))
+
+	case OP_SWAP:
+		if err := e.need(2); err != nil {
+			return err
+		}
+		n := len(*s)
+		(*s)[n-2], (*s)[n-1] = (*s)[n-1], (*s)[n-2]
+
+	case OP_TUCK:
+		if err := e.need(2); err != nil {
+			return err
+		}
+		top := s.peek(0)
+		n := len(*s)
+		*s = append((*s)[:n-2], top, (*s)[n-2], (*s)[n-1])
+
+	case OP_SIZE:
+		if err := e.need(1); err != nil {
+			return err
+		}
+		s.pushNum(int64(len(s.peek(0))))
+
+	case OP_EQUAL, OP_EQUALVERIFY:
+		if err := e.need(2); err != nil {
+			return err
+		}
+		equal := bytes.Equal(s.pop(), s.pop())
+		if op == OP_EQUALVERIFY {
+			if !equal {
+				return ErrEqualVerify
+			}
+		} else {
+			s.pushBool(equal)
+		}
+
+	case OP_1ADD, OP_1SUB, OP_NEGATE, OP_ABS, OP_NOT, OP_0NOTEQUAL:
+		if err := e.need(1); err != nil {
+			return err
+		}
+		n, err := s.popNum(minimal)
+		if err != nil {
+			return err
+		}
+		switch op {
+		case OP_1ADD:
+			n++
+		case OP_1SUB:
+			n--
+		case OP_NEGATE:
+			n = -n
+		case OP_ABS:
+			if n < 0 {
+				n = -n
+			}
+		case OP_NOT:
+			n = boolNum(n == 0)
+		case OP_0NOTEQUAL:
+			n = boolNum(n != 0)
+		}
+		s.pushNum(n)
+
+	case OP_ADD, OP_SUB, OP_BOOLAND, OP_BOOLOR, OP_NUMEQUAL, OP_NUMEQUALVERIFY, OP_NUMNOTEQUAL,
+		OP_LESSTHAN, OP_GREATERTHAN, OP_LESSTHANOREQUAL, OP_GREATERTHANOREQUAL, OP_MIN, OP_MAX:
+		if err := e.need(2); err != nil {
+			return err
+		}
+		a, err := s.num(1, minimal, maxNumSize)
+		if err != nil {
+			return err
+		}
+		b, err := s.num(0, minimal, maxNumSize)
+		if err != nil {
+			return err
+		}
+		s.pop()
+		s.pop()
+
+		var n int64
+		switch op {
+		case OP_ADD:
+			n = a + b
+		case OP_SUB:
+			n = a - b
+		case OP_BOOLAND:
+			n = boolNum(a != 0 && b != 0)
+		case OP_BOOLOR:
+			n = boolNum(a != 0 || b != 0)
+		case OP_NUMEQUAL, OP_NUMEQUALVERIFY:
+			n = boolNum(a == b)
+		case OP_NUMNOTEQUAL:
+			n = boolNum(a != b)
+		case OP_LESSTHAN:
+			n = boolNum(a < b)
+		case OP_GREATERTHAN:
+			n = boolNum(a > b)
+		case OP_LESSTHANOREQUAL:
+			n = boolNum(a <= b)
+		case OP_GREATERTHANOREQUAL:
+			n = boolNum(a >= b)
+		case OP_MIN:
+			n = a
+			if b < a {
+				n = b
+			}
+		case OP_MAX:
+			n = a
+			if b > a {
+				n = b
+			}
+		}
+		if op == OP_NUMEQUALVERIFY {
+			if n == 0 {
+				return ErrNumEqualVerify
+			}
+		} else {
+			s.pushNum(n)
+		}
+
+	case OP_WITHIN:
+		if err := e.need(3); err != nil {
+			return err
+		}
+		x, err := s.num(2, minimal, maxNumSize)
+		if err != nil {
+			return err
+		}
+		min, err := s.num(1, minimal, maxNumSize)
+		if err != nil {
+			return err
+		}
+		max, err := s.num(0, minimal, maxNumSize)
+		if err != nil {
+			return err
+		}
+		s.pop()
+		s.pop()
+		s.pop()
+		s.pushBool(min <= x && x < max)
+
+	case OP_RIPEMD160, OP_SHA1, OP_SHA256, OP_HASH160, OP_HASH256:
+		if err := e.need(1); err != nil {
+			return err
+		}
+		v := s.pop()
+		switch op {
+		case OP_RIPEMD160:
+			s.push(hashes.Ripemd160(v))
+		case OP_SHA1:
+			s.push(hashes.Sha1(v))
+		case OP_SHA256:
+			s.push(hashes.Sha256(v))
+		case OP_HASH160:
+			s.push(hashes.Hash160(v))
+		case OP_HASH256:
+			s.push(hashes.Hash256(v))
+		}
+
+	case OP_CODESEPARATOR:
+		e.codeStart = tok.Offset + 1
+		if e.tap != nil {
+			e.tap.CodeSepPos = uint32(e.opIndex)
+		}
+
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		if err := e.need(2); err != nil {
+			return err
+		}
+		sig, pubKey := s.peek(1), s.peek(0)
+		if e.sigVersion == sigVersionTapscript {
+			ok, err := e.checkSigTapscript(sig, pubKey)
+			if err != nil {
+				return err
+			}
+			s.pop()
+			s.pop()
+			if op == OP_CHECKSIGVERIFY {
+				if !ok {
+					return ErrCheckSigVerify
+				}
+			} else {
+				s.pushBool(ok)
+			}
+			break
+		}
+
+		scriptCode, err := e.scriptCode(e.script[e.codeStart:], [][]byte{sig})
+		if err != nil {
+			return err
+		}
+		if err := checkSignatureEncoding(sig, e.flags); err != nil {
+			return err
+		}
+		if err := checkPubKeyEncoding(pubKey, e.flags, e.sigVersion); err != nil {
+			return err
+		}
+		ok := len(sig) > 0 && e.checkSig(sig, pubKey, scriptCode)
+		if !ok && e.flags&VerifyNullFail != 0 && len(sig) > 0 {
+			return ErrNullFail
+		}
+
+		s.pop()
+		s.pop()
+		if op == OP_CHECKSIGVERIFY {
+			if !ok {
+				return ErrCheckSigVerify
+			}
+		} else {
+			s.pushBool(ok)
+		}
+
+	case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+		//OP_CHECKSIGADD replaces it in tapscript
+		if e.sigVersion == sigVersionTapscript {
+			return ErrTapscriptCheckMultiSig
+		}
+		return e.checkMultiSig(tok)
+
+	case OP_CHECKSIGADD:
+		//Adds 1 to n for a valid signature: <sig> <n> <pubkey> -> <n+ok>
+		if e.sigVersion != sigVersionTapscript {
+			return ErrBadOpcode
+		}
+		if err := e.need(3); err != nil {
+			return err
+		}
+		sig, pubKey := s.peek(2), s.peek(0)
+		n, err := s.num(1, minimal, maxNumSize)
+		if err != nil {
+			return err
+		}
+		ok, err := e.checkSigTapscript(sig, pubKey)
+		if err != nil {
+			return err
+		}
+		s.pop()
+		s.pop()
+		s.pop()
+		s.pushNum(n + boolNum(ok))
+
+	default:
+		return ErrBadOpcode
+	}
+	return nil
+}
+
+//OP_CHECKMULTISIG pops <dummy> <sig>... <m> <pubkey>... <n>.
+//Signatures must match the pubkeys in order, each pubkey is tried once.
+func (e *engine) checkMultiSig(tok Token) error {
+	s := e.stack
+	minimal := e.minimal()
+
+	i := 1
+	if err := e.need(i); err != nil {
+		return err
+	}
+	keys, err := s.num(i-1, minimal, maxNumSize)
+	if err != nil {
+		return err
+	}
+	if keys < 0 || keys > MaxPubKeysPerMultiSig {
+		return ErrPubKeyCount
+	}
+	//Every pubkey counts against the opcode limit
+	if e.opCount += int(keys); e.opCount > MaxOpsPerScript {
+		return ErrOpCount
+	}
+	i++
+	ikey := i
+	//Pubkeys left to clean up for NULLFAIL purposes
+	ikey2 := int(keys) + 2
+	i += int(keys)
+	if err := e.need(i); err != nil {
+		return err
+	}
+
+	sigs, err := s.num(i-1, minimal, maxNumSize)
+	if err != nil {
+		return err
+	}
+	if sigs < 0 || sigs > keys {
+		return ErrSigCount
+	}
+	i++
+	isig := i
+	i += int(sigs)
+	if err := e.need(i); err != nil {
+		return err
+	}
+
+	sigList := make([][]byte, sigs)
+	for k := range sigList {
+		sigList[k] = s.peek(isig + k - 1)
+	}
+	scriptCode, err := e.scriptCode(e.script[e.codeStart:], sigList)
+	if err != nil {
+		return err
+	}
+
+	success := true
+	for success && sigs > 0 {
+		sig := s.peek(isig - 1)
+		pubKey := s.peek(ikey - 1)
+		if err := checkSignatureEncoding(sig, e.flags); err != nil {
+			return err
+		}
+		if err := checkPubKeyEncoding(pubKey, e.flags, e.sigVersion); err != nil {
+			return err
+		}
+		if len(sig) > 0 && e.checkSig(sig, pubKey, scriptCode) {
+			isig++
+			sigs--
+		}
+		ikey++
+		keys--
+		//More signatures left than keys to match them means failure
+		if sigs > keys {
+			success = false
+		}
+	}
+
+	for ; i > 1; i-- {
+		//Only the signatures are left once ikey2 reaches zero
+		if !success && e.flags&VerifyNullFail != 0 && ikey2 == 0 && len(s.peek(0)) > 0 {
+			return ErrNullFail
+		}
+		if ikey2 > 0 {
+			ikey2--
+		}
+		s.pop()
+	}
+
+	//An off by one in the original implementation pops one item too many
+	if err := e.need(1); err != nil {
+		return err
+	}
+	if e.flags&VerifyNullDummy != 0 && len(s.peek(0)) > 0 {
+		return ErrSigNullDummy
+	}
+	s.pop()
+
+	if tok.Opcode == OP_CHECKMULTISIGVERIFY {
+		if !success {
+			return ErrCheckMultiSigVerify
+		}
+	} else {
+		s.pushBool(success)
+	}
+	return nil
+}
+
+//Script the signatures commit to. Legacy scripts drop the signatures themselves
+//from it, witness v0 scripts sign the script as is.
+func (e *engine) scriptCode(code []byte, sigs [][]byte) ([]byte, error) {
+	if e.sigVersion != sigVersionBase {
+		return code, nil
+	}
+	for _, sig := range sigs {
+		var found int
+		code, found = findAndDelete(code, pushBytes(sig))
+		if found > 0 && e.flags&VerifyConstScriptCode != 0 {
+			return nil, ErrSigFindAndDelete
+		}
+	}
+	return code, nil
+}
+
+func (e *engine) checkSig(sig, pubKey, scriptCode []byte) bool {
+	if e.sigVersion == sigVersionWitnessV0 {
+		return e.checker.CheckWitnessSig(sig, pubKey, scriptCode)
+	}
+	return e.checker.CheckSig(sig, pubKey, scriptCode)
+}
+
+//BIP342 signature check, an empty signature fails without using up validation weight
+func (e *engine) checkSigTapscript(sig, pubKey []byte) (bool, error) {
+	if len(sig) > 0 {
+		if e.weightLeft -= TaprootValidationWeightPerSigOp; e.weightLeft < 0 {
+			return false, ErrTapscriptValidationWeight
+		}
+	}
+	switch len(pubKey) {
+	case 0:
+		return false, ErrPubKeyType
+	case 32:
+		if len(sig) == 0 {
+			return false, nil
+		}
+		if err := checkSchnorr(sig, pubKey, e.tap, e.checker); err != nil {
+			return false, err
+		}
+	}
+	//Other key sizes are left for future soft forks, their signatures are valid
+	return len(sig) > 0, nil
+}
+
+func discourageNop(flags Flags) error {
+	if flags&VerifyDiscourageUpgradableNops != 0 {
+		return ErrDiscourageUpgradableNops
+	}
+	return nil
+}
+
+func boolNum(v bool) int64 {
+	if v {
+		return 1
+	}
+	return 0
+}
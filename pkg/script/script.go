@@ -0,0 +1,277 @@
+package script
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrMalformedPush = errors.New("push runs past the end of the script")
+
+//Single opcode, Data holds the pushed bytes for push opcodes
+type Token struct {
+	Opcode byte
+	Data   []byte
+	//Position of the opcode in the script
+	Offset int
+}
+
+//Reports whether the token pushes data, OP_0 and the OP_PUSHDATA family included
+func (t Token) IsPush() bool {
+	return t.Opcode <= OP_PUSHDATA4
+}
+
+//Walks a script one opcode at a time
+type Tokenizer struct {
+	script []byte
+	offset int
+	token  Token
+	err    error
+}
+
+func NewTokenizer(script []byte) *Tokenizer {
+	return &Tokenizer{script: script}
+}
+
+//Advances to the next opcode, false at the end of the script or on a malformed push
+func (t *Tokenizer) Next() bool {
+	if t.err != nil || t.offset >= len(t.script) {
+		return false
+	}
+
+	start := t.offset
+	op := t.script[t.offset]
+	t.offset++
+
+	var n int
+	switch {
+	case op > 0 && op < OP_PUSHDATA1:
+		n = int(op)
+	case op == OP_PUSHDATA1:
+		if t.offset+1 > len(t.script) {
+			return t.fail()
+		}
+		n = int(t.script[t.offset])
+		t.offset++
+	case op == OP_PUSHDATA2:
+		if t.offset+2 > len(t.script) {
+			return t.fail()
+		}
+		n = int(binary.LittleEndian.Uint16(t.script[t.offset:]))
+		t.offset += 2
+	case op == OP_PUSHDATA4:
+		if t.offset+4 > len(t.script) {
+			return t.fail()
+		}
+		n = int(binary.LittleEndian.Uint32(t.script[t.offset:]))
+		t.offset += 4
+	}
+	if n < 0 || n > len(t.script)-t.offset {
+		return t.fail()
+	}
+
+	t.token = Token{Opcode: op, Offset: start}
+	if op <= OP_PUSHDATA4 {
+		t.token.Data = t.script[t.offset : t.offset+n]
+	}
+	t.offset += n
+	return true
+}
+
+func (t *Tokenizer) fail() bool {
+	t.err = ErrMalformedPush
+	t.offset = len(t.script)
+	return false
+}
+
+func (t *Tokenizer) Token() Token {
+	return t.token
+}
+
+//Error that stopped the tokenizer, nil when it reached the end of the script
+func (t *Tokenizer) Err() error {
+	return t.err
+}
+
+//Splits a script into tokens, returning the tokens read before a malformed push along with the error
+func Parse(script []byte) ([]Token, error) {
+	var tokens []Token
+	t := NewTokenizer(script)
+	for t.Next() {
+		tokens = append(tokens, t.Token())
+	}
+	return tokens, t.Err()
+}
+
+//Name of an opcode as Bitcoin Core prints it
+func OpcodeName(op byte) string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	return "OP_UNKNOWN"
+}
+
+//Renders a script the way Bitcoin Core's decodescript prints "asm".
+//Pushes of up to four bytes are shown as numbers, longer ones as hex,
+//and a malformed push ends the output with [error].
+func Disasm(script []byte) string {
+	var parts []string
+	t := NewTokenizer(script)
+	for t.Next() {
+		tok := t.Token()
+		if !tok.IsPush() {
+			parts = append(parts, OpcodeName(tok.Opcode))
+		} else if len(tok.Data) <= 4 {
+			parts = append(parts, strconv.FormatInt(decodeNum(tok.Data), 10))
+		} else {
+			parts = append(parts, hex.EncodeToString(tok.Data))
+		}
+	}
+	if t.Err() != nil {
+		parts = append(parts, "[error]")
+	}
+	return strings.Join(parts, " ")
+}
+
+//Parses the output of Disasm back into a script.
+//Opcodes may be written with or without the OP_ prefix. Numbers that fit a
+//four byte script number are pushed with the smallest encoding, other tokens
+//are read as hex data. Non-minimal pushes don't survive the trip through ASM.
+func ParseAsm(asm string) ([]byte, error) {
+	var script []byte
+	for _, word := range strings.Fields(asm) {
+		if op, ok := opcodeByName(word); ok {
+			script = append(script, op)
+			continue
+		}
+		if n, err := strconv.ParseInt(word, 10, 64); err == nil && isCanonicalNum(word, n) {
+			script = append(script, PushNum(n)...)
+			continue
+		}
+		data, err := hex.DecodeString(word)
+		if err != nil {
+			return nil, fmt.Errorf("invalid asm token: %v", word)
+		}
+		script = append(script, PushData(data)...)
+	}
+	return script, nil
+}
+
+//Smallest script pushing data
+func PushData(data []byte) []byte {
+	n := len(data)
+	var s []byte
+	switch {
+	case n == 0:
+		return []byte{OP_0}
+	case n == 1 && data[0] >= 1 && data[0] <= 16:
+		return []byte{OP_1 + data[0] - 1}
+	case n == 1 && data[0] == 0x81:
+		return []byte{OP_1NEGATE}
+	case n < OP_PUSHDATA1:
+		s = []byte{byte(n)}
+	case n <= 0xff:
+		s = []byte{OP_PUSHDATA1, byte(n)}
+	case n <= 0xffff:
+		s = []byte{OP_PUSHDATA2, 0, 0}
+		binary.LittleEndian.PutUint16(s[1:], uint16(n))
+	default:
+		s = []byte{OP_PUSHDATA4, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(s[1:], uint32(n))
+	}
+	return append(s, data...)
+}
+
+//Smallest script pushing the number n
+func PushNum(n int64) []byte {
+	switch {
+	case n == 0:
+		return []byte{OP_0}
+	case n == -1:
+		return []byte{OP_1NEGATE}
+	case n >= 1 && n <= 16:
+		return []byte{byte(OP_1 + n - 1)}
+	}
+	return PushData(encodeNum(n))
+}
+
+//Decimal tokens are numbers only when Disasm would have printed them that way
+func isCanonicalNum(word string, n int64) bool {
+	if n > 0x7fffffff || n < -0x7fffffff {
+		return false
+	}
+	return strconv.FormatInt(n, 10) == word
+}
+
+func opcodeByName(word string) (byte, bool) {
+	if !strings.HasPrefix(word, "OP_") {
+		word = "OP_" + word
+	}
+	if op, ok := opcodeValues[word]; ok {
+		return op, true
+	}
+	return 0, false
+}
+
+var opcodeValues = func() map[string]byte {
+	m := map[string]byte{
+		"OP_FALSE": OP_0,
+		"OP_TRUE":  OP_1,
+		"OP_NOP2":  OP_NOP2,
+		"OP_NOP3":  OP_NOP3,
+	}
+	for op, name := range opcodeNames {
+		if op == OP_0 || op == OP_1NEGATE || (op >= OP_1 && op <= OP_16) {
+			//Printed as numbers, ParseAsm handles them through PushNum
+			continue
+		}
+		m[name] = op
+	}
+	return m
+}()
+
+//Script number encoding: little endian sign and magnitude, minimal length
+func encodeNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+	negative := n < 0
+	abs := uint64(n)
+	if negative {
+		abs = uint64(-n)
+	}
+
+	var b []byte
+	for abs > 0 {
+		b = append(b, byte(abs&0xff))
+		abs >>= 8
+	}
+	if b[len(b)-1]&0x80 != 0 {
+		if negative {
+			b = append(b, 0x80)
+		} else {
+			b = append(b, 0x00)
+		}
+	} else if negative {
+		b[len(b)-1] |= 0x80
+	}
+	return b
+}
+
+func decodeNum(b []byte) int64 {
+	if len(b) == 0 {
+		return 0
+	}
+	var n int64
+	for i := range b {
+		n |= int64(b[i]) << (8 * uint(i))
+	}
+	if b[len(b)-1]&0x80 != 0 {
+		n &= ^(int64(0x80) << (8 * uint(len(b)-1)))
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,140 @@
+package script
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestDisasmRoundTrip(t *testing.T) {
+	cases := []struct{ script, asm string }{
+		{"76a91489abcdefabbaabbaabbaabbaabbaabbaabbaabba88ac", "OP_DUP OP_HASH160 89abcdefabbaabbaabbaabbaabbaabbaabbaabba OP_EQUALVERIFY OP_CHECKSIG"},
+		{"a914b472a266d0bd89c13706a4132ccfb16f7c3b9fcb87", "OP_HASH160 b472a266d0bd89c13706a4132ccfb16f7c3b9fcb OP_EQUAL"},
+		{"0014751e76e8199196d454941c45d1b3a323f1433bd6", "0 751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"6a0b68656c6c6f20776f726c64", "OP_RETURN 68656c6c6f20776f726c64"},
+		{"4f00516060", "-1 0 1 16 16"},
+		//Pushes of up to four bytes are numbers, sign bit included
+		{"0111021027038096980481969800", "17 10000 -1611392 10000001"},
+		{"03ffffff04ffffff7f", "-8388607 2147483647"},
+		{"b1b2ac", "OP_CHECKLOCKTIMEVERIFY OP_CHECKSEQUENCEVERIFY OP_CHECKSIG"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		s, _ := hex.DecodeString(c.script)
+		if asm := Disasm(s); asm != c.asm {
+			t.Errorf("Disasm(%v) = %q, want %q", c.script, asm, c.asm)
+		}
+		back, err := ParseAsm(c.asm)
+		if err != nil || !bytes.Equal(back, s) {
+			t.Errorf("ParseAsm(%q) = %x, %v", c.asm, back, err)
+		}
+	}
+}
+
+func TestParseAsm(t *testing.T) {
+	cases := []struct{ asm, script string }{
+		//Opcodes with or without their prefix, and Core's aliases
+		{"DUP OP_HASH160 OP_FALSE TRUE NOP2 CHECKSEQUENCEVERIFY", "76a90051b1b2"},
+		//Non-minimal pushes come back minimal
+		{"-1 0 5", "4f0055"},
+		//Numbers past four bytes are read as hex
+		{"2147483648", "05" + "2147483648"},
+		{"007f", "02007f"},
+	}
+	for _, c := range cases {
+		s, err := ParseAsm(c.asm)
+		if err != nil || hex.EncodeToString(s) != c.script {
+			t.Errorf("ParseAsm(%q) = %x, %v, want %v", c.asm, s, err, c.script)
+		}
+	}
+	for _, asm := range []string{"OP_NOTANOPCODE", "abc", "0xff", "[error]"} {
+		if s, err := ParseAsm(asm); err == nil {
+			t.Errorf("ParseAsm(%q) = %x", asm, s)
+		}
+	}
+
+	//A push of 0x81 prints as -1, which parses back as OP_1NEGATE
+	if s, _ := ParseAsm(Disasm([]byte{1, 0x81})); !bytes.Equal(s, []byte{OP_1NEGATE}) {
+		t.Errorf("non-minimal push came back as %x", s)
+	}
+}
+
+//PushData switches encodings at 76, 256 and 65536 bytes and the tokenizer reads each one back
+func TestPushDataBoundaries(t *testing.T) {
+	cases := []struct {
+		n      int
+		op     byte
+		header int
+	}{
+		{2, 2, 1},
+		{75, 75, 1},
+		{76, OP_PUSHDATA1, 2},
+		{255, OP_PUSHDATA1, 2},
+		{256, OP_PUSHDATA2, 3},
+		{65535, OP_PUSHDATA2, 3},
+		{65536, OP_PUSHDATA4, 5},
+	}
+	for _, c := range cases {
+		data := bytes.Repeat([]byte{0xab}, c.n)
+		s := PushData(data)
+		if s[0] != c.op || len(s) != c.header+c.n {
+			t.Errorf("%v bytes: opcode %#x, script of %v bytes", c.n, s[0], len(s))
+			continue
+		}
+		s = append(s, OP_CHECKSIG)
+		tokens, err := Parse(s)
+		if err != nil || len(tokens) != 2 {
+			t.Fatalf("%v bytes: %v tokens, %v", c.n, len(tokens), err)
+		}
+		if tokens[0].Opcode != c.op || !bytes.Equal(tokens[0].Data, data) || !tokens[0].IsPush() {
+			t.Errorf("%v bytes: read %#x with %v bytes", c.n, tokens[0].Opcode, len(tokens[0].Data))
+		}
+		if tokens[1].Opcode != OP_CHECKSIG || tokens[1].Offset != c.header+c.n || tokens[1].IsPush() {
+			t.Errorf("%v bytes: next token %#x at %v", c.n, tokens[1].Opcode, tokens[1].Offset)
+		}
+	}
+
+	//Small pushes use the number opcodes
+	for b, op := range map[byte]byte{0: 1, 1: OP_1, 16: OP_16, 17: 1, 0x81: OP_1NEGATE} {
+		if s := PushData([]byte{b}); s[0] != op {
+			t.Errorf("push %#x: %x", b, s)
+		}
+	}
+	if s := PushData(nil); !bytes.Equal(s, []byte{OP_0}) {
+		t.Errorf("empty push: %x", s)
+	}
+}
+
+func TestTruncatedPush(t *testing.T) {
+	cases := []string{
+		"01",
+		"4b" + "00",
+		"4c",
+		"4c01",
+		"4c02" + "00",
+		"4d00",
+		"4d0100",
+		"4d0001" + "00",
+		"4e000000",
+		"4e01000000",
+		"4effffffff" + "00",
+	}
+	for _, c := range cases {
+		s, _ := hex.DecodeString("51" + c)
+		tokens, err := Parse(s)
+		if err != ErrMalformedPush || len(tokens) != 1 || tokens[0].Opcode != OP_1 {
+			t.Errorf("%v: %v tokens, %v", c, len(tokens), err)
+		}
+		if asm := Disasm(s); asm != "1 [error]" {
+			t.Errorf("%v: Disasm %q", c, asm)
+		}
+
+		//The tokenizer stays stopped after the error
+		tk := NewTokenizer(s)
+		for tk.Next() {
+		}
+		if tk.Next() || tk.Err() != ErrMalformedPush {
+			t.Errorf("%v: tokenizer resumed, %v", c, tk.Err())
+		}
+	}
+}
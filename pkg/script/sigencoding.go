@@ -0,0 +1,60 @@
+package script
+
+import "github.com/lirancohen/blockparser/pkg/secp256k1"
+
+//Signature hash types, the last byte of a signature
+const (
+	//Taproot only, commits to the same data as SigHashAll
+	SigHashDefault      = 0x00
+	SigHashAll          = 0x01
+	SigHashNone         = 0x02
+	SigHashSingle       = 0x03
+	SigHashAnyOneCanPay = 0x80
+)
+
+//Strict DER with a trailing hash type byte, as BIP66 requires
+func isValidSignatureEncoding(sig []byte) bool {
+	return len(sig) > 0 && secp256k1.IsStrictDER(sig[:len(sig)-1])
+}
+
+func isLowDERSignature(sig []byte) bool {
+	s, err := secp256k1.ParseDERSignature(sig[:len(sig)-1])
+	if err != nil {
+		return false
+	}
+	return s.IsLowS()
+}
+
+func isDefinedHashType(sig []byte) bool {
+	ht := sig[len(sig)-1] &^ SigHashAnyOneCanPay
+	return ht >= SigHashAll && ht <= SigHashSingle
+}
+
+func checkSignatureEncoding(sig []byte, flags Flags) error {
+	//An empty signature is a valid way to fail CHECKSIG
+	if len(sig) == 0 {
+		return nil
+	}
+	if flags&(VerifyDERSig|VerifyLowS|VerifyStrictEnc) != 0 && !isValidSignatureEncoding(sig) {
+		return ErrSigDER
+	}
+	if flags&VerifyLowS != 0 && !isLowDERSignature(sig) {
+		return ErrSigHighS
+	}
+	if flags&VerifyStrictEnc != 0 && !isDefinedHashType(sig) {
+		return ErrSigHashType
+	}
+	return nil
+}
+
+func checkPubKeyEncoding(pubKey []byte, flags Flags, sv sigVersion) error {
+	compressed := len(pubKey) == 33 && (pubKey[0] == 0x02 || pubKey[0] == 0x03)
+	uncompressed := len(pubKey) == 65 && pubKey[0] == 0x04
+	if flags&VerifyStrictEnc != 0 && !compressed && !uncompressed {
+		return ErrPubKeyType
+	}
+	if flags&VerifyWitnessPubKeyType != 0 && sv == sigVersionWitnessV0 && !compressed {
+		return ErrWitnessPubKeyType
+	}
+	return nil
+}
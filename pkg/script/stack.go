@@ -0,0 +1,83 @@
+package script
+
+//Largest number arithmetic opcodes accept, script numbers are at most 4 bytes
+const maxNumSize = 4
+
+type stack [][]byte
+
+func (s *stack) push(b []byte) {
+	*s = append(*s, b)
+}
+
+func (s *stack) pushBool(v bool) {
+	if v {
+		s.push([]byte{1})
+	} else {
+		s.push(nil)
+	}
+}
+
+func (s *stack) pushNum(n int64) {
+	s.push(encodeNum(n))
+}
+
+//Item i from the top, 0 being the top itself
+func (s stack) peek(i int) []byte {
+	return s[len(s)-1-i]
+}
+
+func (s *stack) pop() []byte {
+	b := s.peek(0)
+	*s = (*s)[:len(*s)-1]
+	return b
+}
+
+//Removes item i from the top and returns it
+func (s *stack) remove(i int) []byte {
+	n := len(*s) - 1 - i
+	b := (*s)[n]
+	*s = append((*s)[:n], (*s)[n+1:]...)
+	return b
+}
+
+//Reads item i from the top as a script number
+func (s stack) num(i int, minimal bool, maxSize int) (int64, error) {
+	return makeNum(s.peek(i), minimal, maxSize)
+}
+
+func (s *stack) popNum(minimal bool) (int64, error) {
+	n, err := s.num(0, minimal, maxNumSize)
+	if err != nil {
+		return 0, err
+	}
+	s.pop()
+	return n, nil
+}
+
+func (s *stack) popBool() bool {
+	return asBool(s.pop())
+}
+
+//Script number from a stack item, enforcing the size limit and optionally minimal encoding
+func makeNum(b []byte, minimal bool, maxSize int) (int64, error) {
+	if len(b) > maxSize {
+		return 0, ErrNumOverflow
+	}
+	if minimal && len(b) > 0 && b[len(b)-1]&0x7f == 0 {
+		//Only a sign bit that couldn't fit in the byte before justifies a trailing 0x00 or 0x80
+		if len(b) == 1 || b[len(b)-2]&0x80 == 0 {
+			return 0, ErrMinimalData
+		}
+	}
+	return decodeNum(b), nil
+}
+
+//Any non zero byte is true, except a lone sign bit on the last byte (negative zero)
+func asBool(b []byte) bool {
+	for i := range b {
+		if b[i] != 0 {
+			return !(i == len(b)-1 && b[i] == 0x80)
+		}
+	}
+	return false
+}
@@ -0,0 +1,273 @@
+package script
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+
+	"github.com/lirancohen/blockparser/pkg/hashes"
+	"github.com/lirancohen/blockparser/pkg/secp256k1"
+	"github.com/lirancohen/blockparser/pkg/utils"
+)
+
+const (
+	//First byte of an annex, the last witness item when there are at least two
+	TaprootAnnexTag = 0x50
+	//Leaf version bits of the control block's first byte, the low bit is the output key parity
+	TaprootLeafMask        = 0xfe
+	TaprootLeafTapscript   = 0xc0
+	TaprootControlBaseSize = 33
+	TaprootControlNodeSize = 32
+	TaprootControlMaxNodes = 128
+	TaprootControlMaxSize  = TaprootControlBaseSize + TaprootControlNodeSize*TaprootControlMaxNodes
+
+	//Tapscript signature budget: every non empty signature checked uses up 50 of the
+	//serialized witness size plus 50
+	TaprootValidationWeightPerSigOp = 50
+	TaprootValidationWeightOffset   = 50
+)
+
+var (
+	ErrWitnessEmpty              = errors.New("witness program was passed an empty witness")
+	ErrControlBlockSize          = errors.New("invalid taproot control block size")
+	ErrTweak                     = errors.New("taproot tweak is out of range")
+	ErrSchnorrSigSize            = errors.New("invalid Schnorr signature size")
+	ErrSchnorrSigHashType        = errors.New("invalid Schnorr signature hash type")
+	ErrSchnorrSig                = errors.New("invalid Schnorr signature")
+	ErrTapscriptValidationWeight = errors.New("too much signature validation relative to witness weight")
+	ErrTapscriptCheckMultiSig    = errors.New("OP_CHECKMULTISIG(VERIFY) is not available in tapscript")
+)
+
+//Script path data BIP342 signatures commit to besides the transaction
+type TapscriptData struct {
+	LeafHash []byte
+	//Opcode position of the last executed OP_CODESEPARATOR, 0xffffffff for none
+	CodeSepPos uint32
+}
+
+type TaprootSpendType int
+
+const (
+	KeyPath TaprootSpendType = iota
+	ScriptPath
+)
+
+func (t TaprootSpendType) String() string {
+	if t == ScriptPath {
+		return "script path"
+	}
+	return "key path"
+}
+
+//Last witness item of a script path spend, proves the leaf is committed to by the output key
+type ControlBlock struct {
+	LeafVersion uint8
+	//Parity of the output key's y coordinate
+	OutputKeyOdd bool
+	//x-only key the output key is a tweak of
+	InternalKey []byte
+	//Merkle path from the leaf to the root, 32 bytes per node
+	Path [][]byte
+}
+
+func ParseControlBlock(b []byte) (*ControlBlock, error) {
+	if len(b) < TaprootControlBaseSize || len(b) > TaprootControlMaxSize ||
+		(len(b)-TaprootControlBaseSize)%TaprootControlNodeSize != 0 {
+		return nil, ErrControlBlockSize
+	}
+	c := &ControlBlock{
+		LeafVersion:  b[0] & TaprootLeafMask,
+		OutputKeyOdd: b[0]&1 == 1,
+		InternalKey:  b[1:TaprootControlBaseSize],
+	}
+	for n := TaprootControlBaseSize; n < len(b); n += TaprootControlNodeSize {
+		c.Path = append(c.Path, b[n:n+TaprootControlNodeSize])
+	}
+	return c, nil
+}
+
+//Hash of the leaf a script path spend executes
+func TapLeafHash(leafVersion uint8, script []byte) []byte {
+	return hashes.TaggedHash("TapLeaf", []byte{leafVersion}, utils.CompactSize(len(script)).Bytes(), script)
+}
+
+//Hash of a script tree node, children are ordered so the tree needs no left/right flags
+func TapBranchHash(a, b []byte) []byte {
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	return hashes.TaggedHash("TapBranch", a, b)
+}
+
+//Script tree root reached by following the path up from leafHash
+func (c *ControlBlock) RootHash(leafHash []byte) []byte {
+	k := leafHash
+	for _, node := range c.Path {
+		k = TapBranchHash(k, node)
+	}
+	return k
+}
+
+//Derives the output key for internalKey committing to root, nil root for key path only outputs.
+//Returns the x-only output key and the parity of its y coordinate.
+func TweakPubKey(internalKey, root []byte) ([]byte, bool, error) {
+	p, err := secp256k1.ParseXOnlyPubKey(internalKey)
+	if err != nil {
+		return nil, false, err
+	}
+	t := new(big.Int).SetBytes(hashes.TaggedHash("TapTweak", internalKey, root))
+	if t.Cmp(secp256k1.N) >= 0 {
+		return nil, false, ErrTweak
+	}
+	q := secp256k1.MulAdd(t, &p.Point, big.NewInt(1))
+	if q.IsInfinity() {
+		return nil, false, ErrTweak
+	}
+	k := secp256k1.PublicKey{Point: *q}
+	return k.SerializeXOnly(), q.Y.Bit(0) == 1, nil
+}
+
+//Checks that outputKey commits to script through the control block, BIP341's VerifyTaprootCommitment
+func (c *ControlBlock) VerifyCommitment(outputKey, script []byte) bool {
+	root := c.RootHash(TapLeafHash(c.LeafVersion, script))
+	q, odd, err := TweakPubKey(c.InternalKey, root)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(q, outputKey) && odd == c.OutputKeyOdd
+}
+
+//Witness of a P2TR input broken into its parts
+type TaprootSpend struct {
+	Type TaprootSpendType
+	//x-only key of the output being spent
+	OutputKey []byte
+	//nil when the witness has none
+	Annex []byte
+	//Key path: the BIP340 signature, with a trailing hash type when it's 65 bytes
+	Signature []byte
+	//Script path: the leaf script, its hash, the control block and the items the script runs against
+	Script       []byte
+	LeafHash     []byte
+	ControlBlock *ControlBlock
+	Stack        [][]byte
+}
+
+//Splits the witness of an input spending the taproot output key outputKey, following BIP341
+func ParseTaprootWitness(outputKey []byte, witness [][]byte) (*TaprootSpend, error) {
+	if len(witness) == 0 {
+		return nil, ErrWitnessEmpty
+	}
+	s := &TaprootSpend{OutputKey: outputKey}
+
+	if len(witness) >= 2 {
+		if last := witness[len(witness)-1]; len(last) > 0 && last[0] == TaprootAnnexTag {
+			s.Annex = last
+			witness = witness[:len(witness)-1]
+		}
+	}
+
+	if len(witness) == 1 {
+		s.Type = KeyPath
+		s.Signature = witness[0]
+		return s, nil
+	}
+
+	s.Type = ScriptPath
+	c, err := ParseControlBlock(witness[len(witness)-1])
+	if err != nil {
+		return nil, err
+	}
+	s.ControlBlock = c
+	s.Script = witness[len(witness)-2]
+	s.LeafHash = TapLeafHash(c.LeafVersion, s.Script)
+	s.Stack = witness[:len(witness)-2]
+	return s, nil
+}
+
+//Reports whether the output key commits to the leaf being executed.
+//Key path spends have nothing to check without a signature verification, so they always pass.
+func (s *TaprootSpend) CheckOutputKey() bool {
+	if s.Type == KeyPath {
+		return true
+	}
+	return s.ControlBlock.VerifyCommitment(s.OutputKey, s.Script)
+}
+
+//Runs a taproot witness program, BIP341's key path and script path rules
+func verifyTaproot(witness [][]byte, outputKey []byte, flags Flags, checker SigChecker) error {
+	spend, err := ParseTaprootWitness(outputKey, witness)
+	if err != nil {
+		return err
+	}
+	if spend.Type == KeyPath {
+		return checkSchnorr(spend.Signature, outputKey, nil, checker)
+	}
+	if !spend.CheckOutputKey() {
+		return ErrWitnessProgramMismatch
+	}
+	//Unknown leaf versions are left for future soft forks
+	if spend.ControlBlock.LeafVersion != TaprootLeafTapscript {
+		return nil
+	}
+
+	//An OP_SUCCESSx anywhere makes the spend valid, even ahead of malformed pushes
+	t := NewTokenizer(spend.Script)
+	for t.Next() {
+		if isOpSuccess(t.Token().Opcode) {
+			return nil
+		}
+	}
+	if t.Err() != nil {
+		return ErrBadOpcode
+	}
+	if len(spend.Stack) > MaxStackSize {
+		return ErrStackSize
+	}
+
+	e := &engine{
+		script:     spend.Script,
+		flags:      flags,
+		checker:    checker,
+		sigVersion: sigVersionTapscript,
+		tap:        &TapscriptData{LeafHash: spend.LeafHash, CodeSepPos: 0xffffffff},
+		weightLeft: witnessSize(witness) + TaprootValidationWeightOffset,
+	}
+	return executeWitnessScript(append(stack{}, spend.Stack...), e)
+}
+
+//Checks a key path or tapscript signature, 64 bytes or 65 with an explicit hash type
+func checkSchnorr(sig, pubKey []byte, tap *TapscriptData, checker SigChecker) error {
+	hashType := uint32(SigHashDefault)
+	if len(sig) == 65 {
+		hashType = uint32(sig[64])
+		//An explicit 0x00 would let the same signature be encoded two ways
+		if hashType == SigHashDefault {
+			return ErrSchnorrSigHashType
+		}
+		sig = sig[:64]
+	}
+	if len(sig) != 64 {
+		return ErrSchnorrSigSize
+	}
+	if ht := hashType &^ SigHashAnyOneCanPay; hashType != SigHashDefault && (ht < SigHashAll || ht > SigHashSingle) {
+		return ErrSchnorrSigHashType
+	}
+	return checker.CheckSchnorrSig(sig, pubKey, hashType, tap)
+}
+
+//Opcodes BIP342 reserves for future soft forks, scripts containing one always succeed
+func isOpSuccess(op byte) bool {
+	return op == 80 || op == 98 || (op >= 126 && op <= 129) || (op >= 131 && op <= 134) ||
+		(op >= 137 && op <= 138) || (op >= 141 && op <= 142) || (op >= 149 && op <= 153) ||
+		(op >= 187 && op <= 254)
+}
+
+//Serialized size of a witness, item count included
+func witnessSize(witness [][]byte) int {
+	n := len(utils.CompactSize(len(witness)).Bytes())
+	for _, item := range witness {
+		n += len(utils.CompactSize(len(item)).Bytes()) + len(item)
+	}
+	return n
+}
@@ -0,0 +1,179 @@
+package script
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/hashes"
+)
+
+func fromHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+//BIP341 wallet test vectors, scriptPubKey section
+func TestTaprootWalletVectors(t *testing.T) {
+	cases := []struct {
+		internalKey string
+		leafScript  string
+		leafHash    string
+		tweak       string
+		outputKey   string
+		control     string
+	}{
+		{
+			internalKey: "d6889cb081036e0faefa3a35157ad71086b123b2b144b649798b494c300a961d",
+			tweak:       "b86e7be8f39bab32a6f2c0443abbc210f0edac0e2c53d501b36b64437d9c6c70",
+			outputKey:   "53a1f6e454df1aa2776a2814a721372d6258050de330b3c6d10ee8f4e0dda343",
+		},
+		{
+			internalKey: "187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27",
+			leafScript:  "20d85a959b0290bf19bb89ed43c916be835475d013da4b362117393e25a48229b8ac",
+			leafHash:    "5b75adecf53548f3ec6ad7d78383bf84cc57b55a3127c72b9a2481752dd88b21",
+			tweak:       "cbd8679ba636c1110ea247542cfbd964131a6be84f873f7f3b62a777528ed001",
+			outputKey:   "147c9c57132f6e7ecddba9800bb0c4449251c92a1e60371ee77557b6620f3ea3",
+			control:     "c1187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27",
+		},
+	}
+	for n, c := range cases {
+		internal := fromHex(t, c.internalKey)
+		var root []byte
+		if c.leafScript != "" {
+			root = TapLeafHash(TaprootLeafTapscript, fromHex(t, c.leafScript))
+			if h := hex.EncodeToString(root); h != c.leafHash {
+				t.Errorf("vector %v: leaf hash %v", n, h)
+			}
+		}
+		if tweak := hex.EncodeToString(hashes.TaggedHash("TapTweak", internal, root)); tweak != c.tweak {
+			t.Errorf("vector %v: tweak %v", n, tweak)
+		}
+		q, odd, err := TweakPubKey(internal, root)
+		if err != nil || hex.EncodeToString(q) != c.outputKey {
+			t.Fatalf("vector %v: output key %x, %v", n, q, err)
+		}
+
+		spk := append([]byte{OP_1, 32}, q...)
+		if cl := Classify(spk); cl.Class != WitnessV1Taproot || !bytes.Equal(cl.Data[0], q) {
+			t.Errorf("vector %v: classified as %v", n, cl.Class)
+		}
+
+		if c.control == "" {
+			continue
+		}
+		cb, err := ParseControlBlock(fromHex(t, c.control))
+		if err != nil {
+			t.Fatalf("vector %v: %v", n, err)
+		}
+		if cb.OutputKeyOdd != odd || cb.LeafVersion != TaprootLeafTapscript || !bytes.Equal(cb.InternalKey, internal) {
+			t.Errorf("vector %v: control block %+v", n, cb)
+		}
+		if !cb.VerifyCommitment(q, fromHex(t, c.leafScript)) {
+			t.Errorf("vector %v: commitment doesn't verify", n)
+		}
+		if cb.VerifyCommitment(q, []byte{OP_1}) {
+			t.Errorf("vector %v: another script verifies", n)
+		}
+	}
+}
+
+//A two leaf tree proves either leaf with the other's hash as its path
+func TestControlBlockPath(t *testing.T) {
+	internal := fromHex(t, "187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27")
+	a, b := []byte{OP_1}, []byte{OP_2}
+	ha, hb := TapLeafHash(TaprootLeafTapscript, a), TapLeafHash(TaprootLeafTapscript, b)
+	root := TapBranchHash(ha, hb)
+	if !bytes.Equal(root, TapBranchHash(hb, ha)) {
+		t.Fatal("branch hash depends on child order")
+	}
+	q, odd, err := TweakPubKey(internal, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := byte(TaprootLeafTapscript)
+	if odd {
+		first |= 1
+	}
+	for _, leaf := range []struct{ script, sibling []byte }{{a, hb}, {b, ha}} {
+		control := append(append([]byte{first}, internal...), leaf.sibling...)
+		cb, err := ParseControlBlock(control)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cb.Path) != 1 || !cb.VerifyCommitment(q, leaf.script) {
+			t.Errorf("leaf %x doesn't verify", leaf.script)
+		}
+		//The parity bit is part of the commitment
+		control[0] ^= 1
+		if cb, _ := ParseControlBlock(control); cb.VerifyCommitment(q, leaf.script) {
+			t.Errorf("leaf %x verifies with the wrong parity", leaf.script)
+		}
+	}
+}
+
+func TestParseControlBlockSize(t *testing.T) {
+	for _, l := range []int{0, 32, 34, 64, 33 + 31, 33 + 33, TaprootControlMaxSize + 32, TaprootControlMaxSize + 1} {
+		if _, err := ParseControlBlock(make([]byte, l)); err != ErrControlBlockSize {
+			t.Errorf("%v bytes: %v", l, err)
+		}
+	}
+	for _, l := range []int{33, 65, TaprootControlMaxSize} {
+		cb, err := ParseControlBlock(make([]byte, l))
+		if err != nil || len(cb.Path) != (l-33)/32 {
+			t.Errorf("%v bytes: %v", l, err)
+		}
+	}
+}
+
+func TestParseTaprootWitness(t *testing.T) {
+	key := make([]byte, 32)
+	sig := bytes.Repeat([]byte{1}, 64)
+	annex := []byte{TaprootAnnexTag, 0xff}
+	control := make([]byte, 33)
+	control[0] = TaprootLeafTapscript
+	leaf := []byte{OP_1}
+
+	if _, err := ParseTaprootWitness(key, nil); err != ErrWitnessEmpty {
+		t.Errorf("empty witness: %v", err)
+	}
+
+	//A single item is always the signature, even when it looks like an annex
+	s, err := ParseTaprootWitness(key, [][]byte{annex})
+	if err != nil || s.Type != KeyPath || s.Annex != nil || !bytes.Equal(s.Signature, annex) {
+		t.Errorf("single 0x50 item: %+v, %v", s, err)
+	}
+
+	s, err = ParseTaprootWitness(key, [][]byte{sig, annex})
+	if err != nil || s.Type != KeyPath || !bytes.Equal(s.Annex, annex) || !bytes.Equal(s.Signature, sig) {
+		t.Errorf("key path with annex: %+v, %v", s, err)
+	}
+
+	s, err = ParseTaprootWitness(key, [][]byte{{2}, {3}, leaf, control, annex})
+	if err != nil || s.Type != ScriptPath || !bytes.Equal(s.Annex, annex) || !bytes.Equal(s.Script, leaf) || len(s.Stack) != 2 {
+		t.Errorf("script path with annex: %+v, %v", s, err)
+	}
+	if !bytes.Equal(s.LeafHash, TapLeafHash(TaprootLeafTapscript, leaf)) {
+		t.Errorf("leaf hash %x", s.LeafHash)
+	}
+
+	s, err = ParseTaprootWitness(key, [][]byte{leaf, control})
+	if err != nil || s.Type != ScriptPath || s.Annex != nil || len(s.Stack) != 0 {
+		t.Errorf("script path: %+v, %v", s, err)
+	}
+
+	//Only the last item can be an annex, removing it must leave a valid control block
+	for _, w := range [][][]byte{
+		{leaf, control[:32], annex},
+		{leaf, annex, control[:32]},
+		{leaf, append(control, 0)},
+	} {
+		if _, err := ParseTaprootWitness(key, w); err != ErrControlBlockSize {
+			t.Errorf("%x: %v", w, err)
+		}
+	}
+}
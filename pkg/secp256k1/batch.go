@@ -0,0 +1,154 @@
+package secp256k1
+
+import (
+	"crypto/rand"
+	"math/big"
+	"runtime"
+	"sync"
+)
+
+//Collects signatures to check them all at once
+type Batch struct {
+	items []batchItem
+}
+
+type batchItem struct {
+	pub     *PublicKey
+	msg     []byte
+	ecdsa   *Signature
+	schnorr *SchnorrSignature
+}
+
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+func (b *Batch) AddECDSA(pub *PublicKey, hash []byte, sig *Signature) {
+	b.items = append(b.items, batchItem{pub: pub, msg: hash, ecdsa: sig})
+}
+
+func (b *Batch) AddSchnorr(pub *PublicKey, msg []byte, sig *SchnorrSignature) {
+	b.items = append(b.items, batchItem{pub: pub, msg: msg, schnorr: sig})
+}
+
+func (b *Batch) Len() int {
+	return len(b.items)
+}
+
+//Reports whether every signature in the batch is valid.
+//Schnorr signatures are checked together with BIP340 batch verification,
+//ECDSA signatures can't be combined and are checked in parallel instead.
+func (b *Batch) Verify() bool {
+	var schnorr []batchItem
+	var ecdsa []int
+	for i, item := range b.items {
+		if item.schnorr != nil {
+			schnorr = append(schnorr, item)
+		} else {
+			ecdsa = append(ecdsa, i)
+		}
+	}
+	if len(schnorr) > 0 && !verifySchnorrBatch(schnorr) {
+		return false
+	}
+	return len(b.verifyEach(ecdsa)) == 0
+}
+
+//Indexes of the invalid signatures in the order they were added, checked one by one
+func (b *Batch) Invalid() []int {
+	all := make([]int, len(b.items))
+	for i := range all {
+		all[i] = i
+	}
+	return b.verifyEach(all)
+}
+
+func (item batchItem) verify() bool {
+	if item.schnorr != nil {
+		return VerifySchnorr(item.pub, item.msg, item.schnorr)
+	}
+	return Verify(item.pub, item.msg, item.ecdsa)
+}
+
+//Checks the items at idx across all CPUs, returning the invalid ones in order
+func (b *Batch) verifyEach(idx []int) []int {
+	valid := make([]bool, len(idx))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range next {
+				valid[n] = b.items[idx[n]].verify()
+			}
+		}()
+	}
+	for n := range idx {
+		next <- n
+	}
+	close(next)
+	wg.Wait()
+
+	var invalid []int
+	for n, ok := range valid {
+		if !ok {
+			invalid = append(invalid, idx[n])
+		}
+	}
+	return invalid
+}
+
+//BIP340 batch verification: with random a_1 = 1, a_2..a_u checks
+//(sum a_i*s_i)*G == sum a_i*R_i + sum a_i*e_i*P_i
+func verifySchnorrBatch(items []batchItem) bool {
+	coeffs := make([]*big.Int, len(items))
+	coeffs[0] = big.NewInt(1)
+	for i := 1; i < len(items); i++ {
+		a, err := randScalar()
+		if err != nil {
+			//Without randomness the batch isn't sound, check them alone
+			for _, item := range items {
+				if !item.verify() {
+					return false
+				}
+			}
+			return true
+		}
+		coeffs[i] = a
+	}
+
+	lhs := big.NewInt(0)
+	rhs := toJacobian(&Point{})
+	for i, item := range items {
+		sig := item.schnorr
+		if item.pub == nil || !item.pub.IsOnCurve() || sig.R.Cmp(P) >= 0 || sig.S.Cmp(N) >= 0 {
+			return false
+		}
+		p, ok := liftX(item.pub.X, false)
+		if !ok {
+			return false
+		}
+		r, ok := liftX(sig.R, false)
+		if !ok {
+			return false
+		}
+
+		a := coeffs[i]
+		lhs.Add(lhs, new(big.Int).Mul(a, sig.S)).Mod(lhs, N)
+		e := schnorrChallenge(sig.R, item.pub, item.msg)
+		rhs = rhs.add(toJacobian(ScalarMult(r, a))).add(toJacobian(ScalarMult(p, e.Mul(e, a))))
+	}
+	//rhs - lhs*G must be the point at infinity
+	return rhs.add(toJacobian(ScalarBaseMult(new(big.Int).Sub(N, lhs)))).isInfinity()
+}
+
+//Uniform scalar in [1, n-1]
+func randScalar() (*big.Int, error) {
+	max := new(big.Int).Sub(N, big.NewInt(1))
+	k, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return nil, err
+	}
+	return k.Add(k, big.NewInt(1)), nil
+}
@@ -0,0 +1,89 @@
+package secp256k1
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"testing"
+)
+
+//ECDSA signature of hash with key d and nonce k
+func signECDSA(d *big.Int, hash []byte, k *big.Int) *Signature {
+	r := new(big.Int).Mod(ScalarBaseMult(k).X, N)
+	s := new(big.Int).Mul(r, d)
+	s.Add(s, new(big.Int).SetBytes(hash))
+	s.Mul(s, new(big.Int).ModInverse(k, N))
+	s.Mod(s, N)
+	return &Signature{R: r, S: s}
+}
+
+//BIP340 signature of msg with key d and nonce k, both negated as needed for even y
+func signSchnorr(d *big.Int, msg []byte, k *big.Int) *SchnorrSignature {
+	p := ScalarBaseMult(d)
+	if p.Y.Bit(0) == 1 {
+		d = new(big.Int).Sub(N, d)
+	}
+	r := ScalarBaseMult(k)
+	if r.Y.Bit(0) == 1 {
+		k = new(big.Int).Sub(N, k)
+	}
+	e := schnorrChallenge(r.X, &PublicKey{Point: *p}, msg)
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, N)
+	return &SchnorrSignature{R: r.X, S: s}
+}
+
+//Batch of valid ECDSA and Schnorr signatures followed by the BIP340 vectors
+func validBatch(t *testing.T) *Batch {
+	b := NewBatch()
+	for i := 1; i <= 4; i++ {
+		d := big.NewInt(int64(1000 + i*7919))
+		pub := &PublicKey{Point: *ScalarBaseMult(d)}
+		msg := sha256.Sum256([]byte{byte(i)})
+		b.AddECDSA(pub, msg[:], signECDSA(d, msg[:], big.NewInt(int64(99+i))))
+		b.AddSchnorr(pub, msg[:], signSchnorr(d, msg[:], big.NewInt(int64(555+i))))
+	}
+	for _, v := range bip340Vectors {
+		if !v.valid {
+			continue
+		}
+		pub, err := ParseXOnlyPubKey(mustHex(v.pub))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, _ := ParseSchnorrSignature(mustHex(v.sig))
+		b.AddSchnorr(pub, mustHex(v.msg), sig)
+	}
+	return b
+}
+
+func TestBatchVerify(t *testing.T) {
+	b := validBatch(t)
+	if !b.Verify() || len(b.Invalid()) != 0 {
+		t.Fatalf("valid batch of %v rejected, invalid %v", b.Len(), b.Invalid())
+	}
+}
+
+func TestBatchInvalid(t *testing.T) {
+	//One bad signature of each kind, placed among valid ones
+	for _, bad := range []int{2, 5} {
+		b := validBatch(t)
+		item := b.items[bad]
+		msg := append([]byte{}, item.msg...)
+		msg[0] ^= 1
+		if item.schnorr != nil {
+			b.AddSchnorr(item.pub, msg, item.schnorr)
+		} else {
+			b.AddECDSA(item.pub, msg, item.ecdsa)
+		}
+		last := b.Len() - 1
+		b.items[bad], b.items[last] = b.items[last], b.items[bad]
+
+		if b.Verify() {
+			t.Errorf("item %v: batch with an invalid signature verified", bad)
+		}
+		if invalid := b.Invalid(); len(invalid) != 1 || invalid[0] != bad {
+			t.Errorf("item %v: invalid %v", bad, invalid)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package secp256k1
+
+import "math/big"
+
+func fromHex(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("secp256k1: bad constant " + s)
+	}
+	return n
+}
+
+var (
+	//Field prime
+	P = fromHex("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f")
+	//Order of the generator
+	N = fromHex("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141")
+	//Coefficient b of y^2 = x^3 + 7
+	B = big.NewInt(7)
+	//Generator
+	G = &Point{
+		X: fromHex("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"),
+		Y: fromHex("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"),
+	}
+
+	halfN = new(big.Int).Rsh(N, 1)
+	//Square roots mod P are a single exponentiation since P = 3 mod 4
+	sqrtExp = new(big.Int).Rsh(new(big.Int).Add(P, big.NewInt(1)), 2)
+)
+
+//Affine curve point, nil coordinates are the point at infinity
+type Point struct {
+	X, Y *big.Int
+}
+
+func (p *Point) IsInfinity() bool {
+	return p == nil || p.X == nil || p.Y == nil
+}
+
+//Reports whether p satisfies the curve equation with coordinates in the field
+func (p *Point) IsOnCurve() bool {
+	if p.IsInfinity() {
+		return false
+	}
+	if p.X.Sign() < 0 || p.X.Cmp(P) >= 0 || p.Y.Sign() < 0 || p.Y.Cmp(P) >= 0 {
+		return false
+	}
+	lhs := new(big.Int).Mul(p.Y, p.Y)
+	lhs.Mod(lhs, P)
+	return lhs.Cmp(curveY2(p.X)) == 0
+}
+
+//x^3 + 7 mod P
+func curveY2(x *big.Int) *big.Int {
+	y2 := new(big.Int).Mul(x, x)
+	y2.Mul(y2, x)
+	y2.Add(y2, B)
+	return y2.Mod(y2, P)
+}
+
+//Point with the given x and y parity, false when x isn't on the curve
+func liftX(x *big.Int, odd bool) (*Point, bool) {
+	if x.Cmp(P) >= 0 {
+		return nil, false
+	}
+	y2 := curveY2(x)
+	y := new(big.Int).Exp(y2, sqrtExp, P)
+	if new(big.Int).Mod(new(big.Int).Mul(y, y), P).Cmp(y2) != 0 {
+		return nil, false
+	}
+	if (y.Bit(0) == 1) != odd {
+		y.Sub(P, y)
+	}
+	return &Point{X: new(big.Int).Set(x), Y: y}, true
+}
+
+//Point in Jacobian coordinates (X/Z^2, Y/Z^3), Z = 0 is infinity
+type jacobian struct {
+	x, y, z *big.Int
+}
+
+func toJacobian(p *Point) *jacobian {
+	if p.IsInfinity() {
+		return &jacobian{x: big.NewInt(0), y: big.NewInt(0), z: big.NewInt(0)}
+	}
+	return &jacobian{x: new(big.Int).Set(p.X), y: new(big.Int).Set(p.Y), z: big.NewInt(1)}
+}
+
+func (j *jacobian) isInfinity() bool {
+	return j.z.Sign() == 0
+}
+
+func (j *jacobian) toAffine() *Point {
+	if j.isInfinity() {
+		return &Point{}
+	}
+	zinv := new(big.Int).ModInverse(j.z, P)
+	zinv2 := new(big.Int).Mul(zinv, zinv)
+	x := new(big.Int).Mul(j.x, zinv2)
+	x.Mod(x, P)
+	y := zinv2.Mul(zinv2, zinv)
+	y.Mul(y, j.y)
+	y.Mod(y, P)
+	return &Point{X: x, Y: y}
+}
+
+func mod(n *big.Int) *big.Int {
+	return n.Mod(n, P)
+}
+
+//dbl-2009-l, the curve has a = 0
+func (j *jacobian) double() *jacobian {
+	if j.isInfinity() || j.y.Sign() == 0 {
+		return &jacobian{x: big.NewInt(0), y: big.NewInt(0), z: big.NewInt(0)}
+	}
+	a := mod(new(big.Int).Mul(j.x, j.x))
+	b := mod(new(big.Int).Mul(j.y, j.y))
+	c := mod(new(big.Int).Mul(b, b))
+	d := new(big.Int).Add(j.x, b)
+	d = mod(d.Mul(d, d))
+	d.Sub(d, a)
+	d.Sub(d, c)
+	d = mod(d.Lsh(d, 1))
+	e := mod(new(big.Int).Mul(a, big.NewInt(3)))
+	f := mod(new(big.Int).Mul(e, e))
+
+	x3 := new(big.Int).Sub(f, new(big.Int).Lsh(d, 1))
+	mod(x3)
+	y3 := new(big.Int).Sub(d, x3)
+	y3.Mul(y3, e)
+	y3.Sub(y3, new(big.Int).Lsh(c, 3))
+	mod(y3)
+	z3 := new(big.Int).Mul(j.y, j.z)
+	z3 = mod(z3.Lsh(z3, 1))
+	return &jacobian{x: x3, y: y3, z: z3}
+}
+
+//add-2007-bl
+func (j *jacobian) add(o *jacobian) *jacobian {
+	if j.isInfinity() {
+		return o
+	}
+	if o.isInfinity() {
+		return j
+	}
+	z1z1 := mod(new(big.Int).Mul(j.z, j.z))
+	z2z2 := mod(new(big.Int).Mul(o.z, o.z))
+	u1 := mod(new(big.Int).Mul(j.x, z2z2))
+	u2 := mod(new(big.Int).Mul(o.x, z1z1))
+	s1 := new(big.Int).Mul(j.y, o.z)
+	s1 = mod(s1.Mul(s1, z2z2))
+	s2 := new(big.Int).Mul(o.y, j.z)
+	s2 = mod(s2.Mul(s2, z1z1))
+
+	if u1.Cmp(u2) == 0 {
+		if s1.Cmp(s2) == 0 {
+			return j.double()
+		}
+		return &jacobian{x: big.NewInt(0), y: big.NewInt(0), z: big.NewInt(0)}
+	}
+
+	h := mod(new(big.Int).Sub(u2, u1))
+	r := mod(new(big.Int).Sub(s2, s1))
+	h2 := mod(new(big.Int).Mul(h, h))
+	h3 := mod(new(big.Int).Mul(h2, h))
+	u1h2 := mod(new(big.Int).Mul(u1, h2))
+
+	x3 := new(big.Int).Mul(r, r)
+	x3.Sub(x3, h3)
+	x3.Sub(x3, new(big.Int).Lsh(u1h2, 1))
+	mod(x3)
+	y3 := new(big.Int).Sub(u1h2, x3)
+	y3.Mul(y3, r)
+	y3.Sub(y3, new(big.Int).Mul(s1, h3))
+	mod(y3)
+	z3 := new(big.Int).Mul(j.z, o.z)
+	z3 = mod(z3.Mul(z3, h))
+	return &jacobian{x: x3, y: y3, z: z3}
+}
+
+func Add(p, q *Point) *Point {
+	return toJacobian(p).add(toJacobian(q)).toAffine()
+}
+
+//Point negated, y replaced by P - y
+func Negate(p *Point) *Point {
+	if p.IsInfinity() {
+		return &Point{}
+	}
+	return &Point{X: new(big.Int).Set(p.X), Y: mod(new(big.Int).Sub(P, p.Y))}
+}
+
+//k * p
+func ScalarMult(p *Point, k *big.Int) *Point {
+	return MulAdd(big.NewInt(0), p, k)
+}
+
+//k * G
+func ScalarBaseMult(k *big.Int) *Point {
+	return MulAdd(k, &Point{}, big.NewInt(0))
+}
+
+//u1 * G + u2 * q in a single double and add pass (Shamir's trick)
+func MulAdd(u1 *big.Int, q *Point, u2 *big.Int) *Point {
+	u1 = new(big.Int).Mod(u1, N)
+	u2 = new(big.Int).Mod(u2, N)
+	g := toJacobian(G)
+	jq := toJacobian(q)
+	both := g.add(jq)
+
+	acc := toJacobian(&Point{})
+	for i := max(u1.BitLen(), u2.BitLen()) - 1; i >= 0; i-- {
+		acc = acc.double()
+		switch {
+		case u1.Bit(i) == 1 && u2.Bit(i) == 1:
+			acc = acc.add(both)
+		case u1.Bit(i) == 1:
+			acc = acc.add(g)
+		case u2.Bit(i) == 1:
+			acc = acc.add(jq)
+		}
+	}
+	return acc.toAffine()
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,27 @@
+package secp256k1
+
+import "math/big"
+
+//Checks an ECDSA signature over a 32 byte message hash
+func Verify(pub *PublicKey, hash []byte, sig *Signature) bool {
+	if pub == nil || sig == nil || !pub.IsOnCurve() {
+		return false
+	}
+	if sig.R.Sign() <= 0 || sig.R.Cmp(N) >= 0 || sig.S.Sign() <= 0 || sig.S.Cmp(N) >= 0 {
+		return false
+	}
+
+	e := new(big.Int).SetBytes(hash)
+	w := new(big.Int).ModInverse(sig.S, N)
+	u1 := new(big.Int).Mul(e, w)
+	u1.Mod(u1, N)
+	u2 := new(big.Int).Mul(sig.R, w)
+	u2.Mod(u2, N)
+
+	r := MulAdd(u1, &pub.Point, u2)
+	if r.IsInfinity() {
+		return false
+	}
+	x := new(big.Int).Mod(r.X, N)
+	return x.Cmp(sig.R) == 0
+}
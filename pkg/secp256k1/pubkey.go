@@ -0,0 +1,71 @@
+package secp256k1
+
+import (
+	"errors"
+	"math/big"
+)
+
+var ErrBadPubKey = errors.New("malformed public key")
+
+type PublicKey struct {
+	Point
+}
+
+//Parses a SEC1 encoded key: 33 byte compressed, 65 byte uncompressed or
+//65 byte hybrid (0x06/0x07 prefix), which OpenSSL accepted and consensus still does
+func ParsePubKey(b []byte) (*PublicKey, error) {
+	switch {
+	case len(b) == 33 && (b[0] == 0x02 || b[0] == 0x03):
+		p, ok := liftX(new(big.Int).SetBytes(b[1:]), b[0] == 0x03)
+		if !ok {
+			return nil, ErrBadPubKey
+		}
+		return &PublicKey{Point: *p}, nil
+	case len(b) == 65 && (b[0] == 0x04 || b[0] == 0x06 || b[0] == 0x07):
+		p := Point{X: new(big.Int).SetBytes(b[1:33]), Y: new(big.Int).SetBytes(b[33:])}
+		if !p.IsOnCurve() {
+			return nil, ErrBadPubKey
+		}
+		if b[0] != 0x04 && (p.Y.Bit(0) == 1) != (b[0] == 0x07) {
+			return nil, ErrBadPubKey
+		}
+		return &PublicKey{Point: p}, nil
+	}
+	return nil, ErrBadPubKey
+}
+
+//33 byte encoding, 0x02 or 0x03 for the parity of y followed by x
+func (k *PublicKey) SerializeCompressed() []byte {
+	b := make([]byte, 33)
+	b[0] = 0x02 + byte(k.Y.Bit(0))
+	k.X.FillBytes(b[1:])
+	return b
+}
+
+//65 byte encoding, 0x04 followed by x and y
+func (k *PublicKey) SerializeUncompressed() []byte {
+	b := make([]byte, 65)
+	b[0] = 0x04
+	k.X.FillBytes(b[1:33])
+	k.Y.FillBytes(b[33:])
+	return b
+}
+
+//Parses a BIP340 32 byte x-only key, the point with that x and an even y
+func ParseXOnlyPubKey(b []byte) (*PublicKey, error) {
+	if len(b) != 32 {
+		return nil, ErrBadPubKey
+	}
+	p, ok := liftX(new(big.Int).SetBytes(b), false)
+	if !ok {
+		return nil, ErrBadPubKey
+	}
+	return &PublicKey{Point: *p}, nil
+}
+
+//32 byte BIP340 encoding, x alone
+func (k *PublicKey) SerializeXOnly() []byte {
+	b := make([]byte, 32)
+	k.X.FillBytes(b)
+	return b
+}
@@ -0,0 +1,68 @@
+package secp256k1
+
+import (
+	"crypto/sha256"
+	"math/big"
+)
+
+//BIP340 signature: the x coordinate of R and the scalar s
+type SchnorrSignature struct {
+	R *big.Int
+	S *big.Int
+}
+
+//Parses a 64 byte BIP340 signature, range checks are left to VerifySchnorr
+func ParseSchnorrSignature(b []byte) (*SchnorrSignature, error) {
+	if len(b) != 64 {
+		return nil, ErrBadSignature
+	}
+	return &SchnorrSignature{
+		R: new(big.Int).SetBytes(b[:32]),
+		S: new(big.Int).SetBytes(b[32:]),
+	}, nil
+}
+
+func (s *SchnorrSignature) Serialize() []byte {
+	b := make([]byte, 64)
+	s.R.FillBytes(b[:32])
+	s.S.FillBytes(b[32:])
+	return b
+}
+
+//e = H_BIP0340/challenge(r || P || m) mod n
+func schnorrChallenge(r *big.Int, pub *PublicKey, msg []byte) *big.Int {
+	tag := sha256.Sum256([]byte("BIP0340/challenge"))
+	h := sha256.New()
+	h.Write(tag[:])
+	h.Write(tag[:])
+	rb := make([]byte, 32)
+	r.FillBytes(rb)
+	h.Write(rb)
+	h.Write(pub.SerializeXOnly())
+	h.Write(msg)
+	e := new(big.Int).SetBytes(h.Sum(nil))
+	return e.Mod(e, N)
+}
+
+//Checks a BIP340 signature over msg. Only the x coordinate of pub is used,
+//as keys are x-only in BIP340.
+func VerifySchnorr(pub *PublicKey, msg []byte, sig *SchnorrSignature) bool {
+	if pub == nil || sig == nil || !pub.IsOnCurve() {
+		return false
+	}
+	if sig.R.Cmp(P) >= 0 || sig.S.Cmp(N) >= 0 {
+		return false
+	}
+	p, ok := liftX(pub.X, false)
+	if !ok {
+		return false
+	}
+	e := schnorrChallenge(sig.R, pub, msg)
+
+	//R = s*G - e*P
+	r := MulAdd(sig.S, p, new(big.Int).Sub(N, e))
+	if r.IsInfinity() || r.Y.Bit(0) == 1 {
+		return false
+	}
+	return r.X.Cmp(sig.R) == 0
+}
@@ -0,0 +1,66 @@
+package secp256k1
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+//Test vectors 0 to 6 of BIP340
+var bip340Vectors = []struct {
+	pub, msg, sig string
+	valid         bool
+}{
+	{"F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9", "0000000000000000000000000000000000000000000000000000000000000000", "E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0", true},
+	{"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659", "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89", "6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A", true},
+	{"DD308AFEC5777E13121FA72B9CC1B7CC0139715309B086C960E18FD969774EB8", "7E2D58D8B3BCDF1ABADEC7829054F90DDA9805AAB56C77333024B9D0A508B75C", "5831AAEED7B44BB74E5EAB94BA9D4294C49BCF2A60728D8B4C200F50DD313C1BAB745879A5AD954A72C45A91C3A51D3C7ADEA98D82F8481E0E1E03674A6F3FB7", true},
+	{"25D1DFF95105F5253C4022F628A996AD3A0D95FBF21D468A1B33F8C160D8F517", "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF", "7EB0509757E246F19449885651611CB965ECC1A187DD51B64FDA1EDC9637D5EC97582B9CB13DB3933705B32BA982AF5AF25FD78881EBB32771FC5922EFC66EA3", true},
+	{"D69C3509BB99E412E68B0FE8544E72837DFA30746D8BE2AA65975F29D22DC7B9", "4DF3C3F68FCC83B27E9D42C90431A72499F17875C81A599B566C9889B9696703", "00000000000000000000003B78CE563F89A0ED9414F5AA28AD0D96D6795F9C6376AFB1548AF603B3EB45C9F8207DEE1060CB71C04E80F593060B07D28308D7F4", true},
+	//Public key not on the curve
+	{"EEFDEA4CDB677750A420FEE807EACF21EB9898AE79B9768766E4FAA04A2D4A34", "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89", "6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E17776969E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B", false},
+	//R has an odd y
+	{"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659", "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89", "FFF97BD5755EEEA420453A14355235D382F6472F8568A18B2F057A14602975563CC27944640AC607CD107AE10923D9EF7A73C643E166BE5EBEAFA34B1AC553E2", false},
+}
+
+func TestVerifySchnorrBIP340(t *testing.T) {
+	for n, v := range bip340Vectors {
+		pub, err := ParseXOnlyPubKey(mustHex(v.pub))
+		if err != nil {
+			if v.valid {
+				t.Errorf("vector %v: %v", n, err)
+			}
+			continue
+		}
+		sig, err := ParseSchnorrSignature(mustHex(v.sig))
+		if err != nil {
+			t.Fatalf("vector %v: %v", n, err)
+		}
+		msg := mustHex(v.msg)
+		if VerifySchnorr(pub, msg, sig) != v.valid {
+			t.Errorf("vector %v: want valid %v", n, v.valid)
+		}
+		if !v.valid {
+			continue
+		}
+
+		//Any change to the message or signature must break it
+		msg[0] ^= 1
+		if VerifySchnorr(pub, msg, sig) {
+			t.Errorf("vector %v: verified a changed message", n)
+		}
+		msg[0] ^= 1
+		b := sig.Serialize()
+		b[63] ^= 1
+		sig, _ = ParseSchnorrSignature(b)
+		if VerifySchnorr(pub, msg, sig) {
+			t.Errorf("vector %v: verified a changed signature", n)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package secp256k1
+
+import (
+	"errors"
+	"math/big"
+)
+
+var ErrBadSignature = errors.New("malformed signature")
+
+type Signature struct {
+	R, S *big.Int
+}
+
+//Parses a DER signature as leniently as consensus does.
+//Pre-BIP66 signatures were checked by OpenSSL, so this follows libsecp256k1's
+//ecdsa_signature_parse_der_lax: lengths may be padded and the outer length is
+//ignored. R or S wider than 256 bits parse as zero, which never verifies.
+func ParseDERSignature(sig []byte) (*Signature, error) {
+	pos := 0
+	if pos >= len(sig) || sig[pos] != 0x30 {
+		return nil, ErrBadSignature
+	}
+	pos++
+
+	if pos >= len(sig) {
+		return nil, ErrBadSignature
+	}
+	lenbyte := int(sig[pos])
+	pos++
+	if lenbyte&0x80 != 0 {
+		lenbyte -= 0x80
+		if lenbyte > len(sig)-pos {
+			return nil, ErrBadSignature
+		}
+		pos += lenbyte
+	}
+
+	r, pos, err := parseLaxInteger(sig, pos)
+	if err != nil {
+		return nil, err
+	}
+	s, _, err := parseLaxInteger(sig, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	rb, sb := trimZeros(r), trimZeros(s)
+	if len(rb) > 32 || len(sb) > 32 {
+		return &Signature{R: big.NewInt(0), S: big.NewInt(0)}, nil
+	}
+	return &Signature{R: new(big.Int).SetBytes(rb), S: new(big.Int).SetBytes(sb)}, nil
+}
+
+//Reads a 0x02 tagged integer at pos, returning its bytes and the position after it
+func parseLaxInteger(sig []byte, pos int) ([]byte, int, error) {
+	if pos >= len(sig) || sig[pos] != 0x02 {
+		return nil, 0, ErrBadSignature
+	}
+	pos++
+	if pos >= len(sig) {
+		return nil, 0, ErrBadSignature
+	}
+	lenbyte := int(sig[pos])
+	pos++
+
+	var n int
+	if lenbyte&0x80 != 0 {
+		lenbyte -= 0x80
+		if lenbyte > len(sig)-pos {
+			return nil, 0, ErrBadSignature
+		}
+		for lenbyte > 0 && sig[pos] == 0 {
+			pos++
+			lenbyte--
+		}
+		if lenbyte >= 8 {
+			return nil, 0, ErrBadSignature
+		}
+		for lenbyte > 0 {
+			n = n<<8 + int(sig[pos])
+			pos++
+			lenbyte--
+		}
+	} else {
+		n = lenbyte
+	}
+	if n > len(sig)-pos {
+		return nil, 0, ErrBadSignature
+	}
+	return sig[pos : pos+n], pos + n, nil
+}
+
+func trimZeros(b []byte) []byte {
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+//Reports whether S is at most half the curve order, see BIP62
+func (s *Signature) IsLowS() bool {
+	return s.S.Cmp(halfN) <= 0
+}
+
+//Strict DER encoding of the signature
+func (s *Signature) Serialize() []byte {
+	r := derInteger(s.R)
+	sv := derInteger(s.S)
+	b := []byte{0x30, byte(4 + len(r) + len(sv)), 0x02, byte(len(r))}
+	b = append(b, r...)
+	b = append(b, 0x02, byte(len(sv)))
+	return append(b, sv...)
+}
+
+//Big endian bytes with a leading zero when the high bit is set, DER integers are signed
+func derInteger(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) == 0 || b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+//Reports whether sig is strict DER as BIP66 requires: minimal lengths, no padding
+//and positive integers. sig must not carry a hash type byte.
+func IsStrictDER(sig []byte) bool {
+	//30 len 02 lenR R 02 lenS S
+	if len(sig) < 8 || len(sig) > 72 {
+		return false
+	}
+	if sig[0] != 0x30 || int(sig[1]) != len(sig)-2 {
+		return false
+	}
+
+	lenR := int(sig[3])
+	if 5+lenR >= len(sig) {
+		return false
+	}
+	lenS := int(sig[5+lenR])
+	if lenR+lenS+6 != len(sig) {
+		return false
+	}
+
+	if sig[2] != 0x02 || lenR == 0 || sig[4]&0x80 != 0 {
+		return false
+	}
+	//No padding unless it's needed to keep R positive
+	if lenR > 1 && sig[4] == 0 && sig[5]&0x80 == 0 {
+		return false
+	}
+
+	if sig[lenR+4] != 0x02 || lenS == 0 || sig[lenR+6]&0x80 != 0 {
+		return false
+	}
+	if lenS > 1 && sig[lenR+6] == 0 && sig[lenR+7]&0x80 == 0 {
+		return false
+	}
+	return true
+}
+
+//Parses a signature that must be strict DER, see IsStrictDER
+func ParseStrictDERSignature(sig []byte) (*Signature, error) {
+	if !IsStrictDER(sig) {
+		return nil, ErrBadSignature
+	}
+	return ParseDERSignature(sig)
+}
@@ -0,0 +1,247 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/lirancohen/blockparser/pkg/network"
+	"github.com/lirancohen/blockparser/pkg/parser"
+)
+
+var ErrNoBlockFiles = errors.New("no blk*.dat files found")
+
+//Where a raw block was found, Offset points at its MagicID
+type Location struct {
+	File   string
+	Offset int64
+	//Length of the block without the 8 byte MagicID and length prefix
+	Length uint32
+}
+
+//Framed block as stored on disk: MagicID, length and payload
+type RawBlock struct {
+	Location
+	Data []byte
+}
+
+//Iterates the blk*.dat files of a Bitcoin Core blocks directory in file order.
+//Blocks come out in the order they were written, which is not height order.
+//The zero padding Core preallocates at the end of each file is skipped, and
+//files obfuscated with blocks/xor.dat are read transparently.
+type BlkFileSource struct {
+	Dir     string
+	Network *network.Network
+
+	files   []string
+	current int
+	file    *os.File
+	reader  *bufio.Reader
+	offset  int64
+	key     []byte
+	//Unread part of the last block handed out by Read
+	pending []byte
+	//File kept open for ReadAt
+	readFile *os.File
+}
+
+func NewBlkFileSource(dir string, net *network.Network) (*BlkFileSource, error) {
+	if net == nil {
+		net = network.MainNet
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "blk*.dat"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, ErrNoBlockFiles
+	}
+	//blkNNNNN.dat is zero padded so lexical order is file order
+	sort.Strings(files)
+
+	key, err := os.ReadFile(filepath.Join(dir, "xor.dat"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(key) > 0 && bytes.Equal(key, make([]byte, len(key))) {
+		key = nil
+	}
+
+	return &BlkFileSource{
+		Dir:     dir,
+		Network: net,
+		files:   files,
+		current: -1,
+		key:     key,
+	}, nil
+}
+
+//Files the source walks through, in order
+func (s *BlkFileSource) Files() []string {
+	return s.files
+}
+
+//Returns the next block, io.EOF once every file has been read
+func (s *BlkFileSource) Next() (RawBlock, error) {
+	for {
+		if s.reader == nil {
+			if err := s.openNext(); err != nil {
+				return RawBlock{}, err
+			}
+		}
+
+		b, err := s.nextInFile()
+		if err == io.EOF {
+			s.closeFile()
+			continue
+		}
+		return b, err
+	}
+}
+
+//Reads the framed blocks back to back so the source can be handed to
+//anything that expects a bootstrap.dat style io.Reader
+func (s *BlkFileSource) Read(p []byte) (int, error) {
+	if len(s.pending) == 0 {
+		b, err := s.Next()
+		if err != nil {
+			return 0, err
+		}
+		s.pending = b.Data
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *BlkFileSource) Close() error {
+	if s.readFile != nil {
+		s.readFile.Close()
+		s.readFile = nil
+	}
+	return s.closeFile()
+}
+
+func (s *BlkFileSource) openNext() error {
+	s.current++
+	if s.current >= len(s.files) {
+		return io.EOF
+	}
+	f, err := os.Open(s.files[s.current])
+	if err != nil {
+		return err
+	}
+	s.file = f
+	s.offset = 0
+	if len(s.key) > 0 {
+		s.reader = bufio.NewReader(&xorReader{r: f, key: s.key})
+	} else {
+		s.reader = bufio.NewReader(f)
+	}
+	return nil
+}
+
+func (s *BlkFileSource) closeFile() error {
+	s.reader = nil
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+//Skips padding up to the next MagicID and reads the block behind it
+func (s *BlkFileSource) nextInFile() (RawBlock, error) {
+	magic := s.Network.Magic[:]
+	for {
+		b, err := s.reader.ReadByte()
+		if err != nil {
+			return RawBlock{}, err
+		}
+		s.offset++
+		if b != magic[0] {
+			continue
+		}
+		p, err := s.reader.Peek(len(magic) - 1)
+		if err != nil {
+			//Not enough bytes left for a block, the rest is padding
+			return RawBlock{}, io.EOF
+		}
+		if bytes.Equal(p, magic[1:]) {
+			break
+		}
+	}
+
+	loc := Location{File: s.files[s.current], Offset: s.offset - 1}
+	header := make([]byte, 8)
+	header[0] = magic[0]
+	if _, err := io.ReadFull(s.reader, header[1:]); err != nil {
+		return RawBlock{}, io.EOF
+	}
+	s.offset += 7
+	loc.Length = binary.LittleEndian.Uint32(header[4:])
+	if loc.Length > parser.MaxFrameSize {
+		//Scanning resumes after the bogus header
+		return RawBlock{}, parser.ErrFrameSize
+	}
+
+	data := make([]byte, 8+int(loc.Length))
+	copy(data, header)
+	n, err := io.ReadFull(s.reader, data[8:])
+	s.offset += int64(n)
+	if err != nil {
+		//Core can crash mid write, a truncated block at the end of a file is dropped
+		return RawBlock{}, io.EOF
+	}
+	return RawBlock{Location: loc, Data: data}, nil
+}
+
+//Undoes the XOR obfuscation Bitcoin Core applies to block files
+type xorReader struct {
+	r   io.Reader
+	key []byte
+	pos int64
+}
+
+func (x *xorReader) Read(p []byte) (int, error) {
+	n, err := x.r.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= x.key[(x.pos+int64(i))%int64(len(x.key))]
+	}
+	x.pos += int64(n)
+	return n, err
+}
+
+//Reads the block at loc again, loc must come from this source
+func (s *BlkFileSource) ReadAt(loc Location) (RawBlock, error) {
+	if s.readFile == nil || s.readFile.Name() != loc.File {
+		if s.readFile != nil {
+			s.readFile.Close()
+		}
+		f, err := os.Open(loc.File)
+		if err != nil {
+			return RawBlock{}, err
+		}
+		s.readFile = f
+	}
+
+	if loc.Length > parser.MaxFrameSize {
+		return RawBlock{}, parser.ErrFrameSize
+	}
+	data := make([]byte, 8+int(loc.Length))
+	if _, err := s.readFile.ReadAt(data, loc.Offset); err != nil {
+		return RawBlock{}, err
+	}
+	if len(s.key) > 0 {
+		for i := range data {
+			data[i] ^= s.key[(loc.Offset+int64(i))%int64(len(s.key))]
+		}
+	}
+	return RawBlock{Location: loc, Data: data}, nil
+}
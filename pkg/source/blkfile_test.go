@@ -0,0 +1,46 @@
+package source
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/network"
+	"github.com/lirancohen/blockparser/pkg/parser"
+)
+
+func frame(size uint32, payload []byte) []byte {
+	d := append([]byte{}, network.MainNet.Magic[:]...)
+	d = binary.LittleEndian.AppendUint32(d, size)
+	return append(d, payload...)
+}
+
+func TestBlkFileLengthTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	good := frame(4, []byte{1, 2, 3, 4})
+	data := append(frame(parser.MaxFrameSize+1, nil), good...)
+	if err := os.WriteFile(filepath.Join(dir, "blk00000.dat"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewBlkFileSource(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.Next(); err != parser.ErrFrameSize {
+		t.Fatalf("oversized length: got %v", err)
+	}
+	b, err := s.Next()
+	if err != nil || b.Offset != 8 || string(b.Data) != string(good) {
+		t.Fatalf("block after the bad header: %+v %v", b.Location, err)
+	}
+	if _, err := s.ReadAt(Location{File: b.File, Offset: 0, Length: parser.MaxFrameSize + 1}); err != parser.ErrFrameSize {
+		t.Errorf("ReadAt oversized length: got %v", err)
+	}
+	if _, err := s.Next(); err != io.EOF {
+		t.Errorf("end: got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrNonCanonical = errors.New("non-canonical CompactSize encoding")
+
+//Bitcoin's variable length integer (CompactSize)
+//Values below 0xFD are a single byte, larger values are prefixed with
+//0xFD, 0xFE or 0xFF followed by 2, 4 or 8 little endian bytes
+type CompactSize uint64
+
+//Reads a CompactSize from r, rejecting encodings that are longer than necessary
+func ReadCompactSize(r io.Reader) (CompactSize, error) {
+	var p [9]byte
+	if _, err := io.ReadFull(r, p[:1]); err != nil {
+		return 0, err
+	}
+
+	n := compactPayloadLength(p[0])
+	if n == 0 {
+		return CompactSize(p[0]), nil
+	}
+	if _, err := io.ReadFull(r, p[1:1+n]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	return ParseCompactSize(p[:1+n])
+}
+
+//Decodes a complete CompactSize encoding, prefix included
+func ParseCompactSize(b []byte) (CompactSize, error) {
+	if len(b) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := compactPayloadLength(b[0])
+	if len(b) != 1+n {
+		return 0, fmt.Errorf("CompactSize prefix %#x expects %v bytes, got %v", b[0], 1+n, len(b))
+	}
+
+	var v CompactSize
+	switch n {
+	case 0:
+		v = CompactSize(b[0])
+	case 2:
+		v = CompactSize(binary.LittleEndian.Uint16(b[1:]))
+	case 4:
+		v = CompactSize(binary.LittleEndian.Uint32(b[1:]))
+	case 8:
+		v = CompactSize(binary.LittleEndian.Uint64(b[1:]))
+	}
+	if v.Len() != len(b) {
+		return v, ErrNonCanonical
+	}
+	return v, nil
+}
+
+//Number of bytes needed to encode the value, prefix included
+func (c CompactSize) Len() int {
+	switch {
+	case c < 0xFD:
+		return 1
+	case c <= 0xFFFF:
+		return 3
+	case c <= 0xFFFFFFFF:
+		return 5
+	}
+	return 9
+}
+
+//Canonical wire encoding of the value
+func (c CompactSize) Bytes() []byte {
+	b := make([]byte, c.Len())
+	switch len(b) {
+	case 1:
+		b[0] = uint8(c)
+	case 3:
+		b[0] = 0xFD
+		binary.LittleEndian.PutUint16(b[1:], uint16(c))
+	case 5:
+		b[0] = 0xFE
+		binary.LittleEndian.PutUint32(b[1:], uint32(c))
+	case 9:
+		b[0] = 0xFF
+		binary.LittleEndian.PutUint64(b[1:], uint64(c))
+	}
+	return b
+}
+
+//Writes the canonical encoding of the value to w
+func (c CompactSize) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(c.Bytes())
+	return int64(n), err
+}
+
+func compactPayloadLength(prefix byte) int {
+	switch prefix {
+	case 0xFD:
+		return 2
+	case 0xFE:
+		return 4
+	case 0xFF:
+		return 8
+	}
+	return 0
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+func TestCompactSizeBoundaries(t *testing.T) {
+	cases := []struct {
+		v   CompactSize
+		enc string
+	}{
+		{0, "00"},
+		{0xFC, "fc"},
+		{0xFD, "fdfd00"},
+		{0xFFFF, "fdffff"},
+		{0x10000, "fe00000100"},
+		{0xFFFFFFFF, "feffffffff"},
+		{0x100000000, "ff0000000001000000"},
+		{0xFFFFFFFFFFFFFFFF, "ffffffffffffffffff"},
+	}
+	for _, c := range cases {
+		enc := c.v.Bytes()
+		if hex.EncodeToString(enc) != c.enc {
+			t.Errorf("%#x: encoded %x, want %v", uint64(c.v), enc, c.enc)
+		}
+		if c.v.Len() != len(enc) {
+			t.Errorf("%#x: Len %v, encoding is %v bytes", uint64(c.v), c.v.Len(), len(enc))
+		}
+
+		v, err := ReadCompactSize(bytes.NewReader(enc))
+		if err != nil || v != c.v {
+			t.Errorf("%v: read %#x, %v", c.enc, uint64(v), err)
+		}
+		v, err = ParseCompactSize(enc)
+		if err != nil || v != c.v {
+			t.Errorf("%v: parsed %#x, %v", c.enc, uint64(v), err)
+		}
+
+		var buf bytes.Buffer
+		if n, err := c.v.WriteTo(&buf); err != nil || n != int64(len(enc)) || !bytes.Equal(buf.Bytes(), enc) {
+			t.Errorf("%#x: WriteTo wrote %x, %v", uint64(c.v), buf.Bytes(), err)
+		}
+	}
+}
+
+func TestCompactSizeNonCanonical(t *testing.T) {
+	for _, enc := range []string{"fdfc00", "feffff0000", "ffffffffff00000000", "fd0000", "ff0000000000000000"} {
+		b, _ := hex.DecodeString(enc)
+		if _, err := ReadCompactSize(bytes.NewReader(b)); err != ErrNonCanonical {
+			t.Errorf("%v: got %v", enc, err)
+		}
+	}
+}
+
+func TestCompactSizeTruncated(t *testing.T) {
+	if _, err := ReadCompactSize(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("empty: got %v", err)
+	}
+	for _, enc := range []string{"fd", "fdff", "feffffff", "ffffffffffffffff"} {
+		b, _ := hex.DecodeString(enc)
+		if _, err := ReadCompactSize(bytes.NewReader(b)); err != io.ErrUnexpectedEOF {
+			t.Errorf("%v: got %v", enc, err)
+		}
+	}
+	if _, err := ParseCompactSize([]byte{0xFD, 0x00}); err == nil {
+		t.Error("short encoding parsed")
+	}
+}
@@ -7,38 +7,17 @@ import (
 )
 
 //Helper Function To Convert VariableInt to Int
+//input is the full CompactSize encoding, prefix included
 func VarInt(input []byte) int {
-	var v int
-	switch len(input) {
-	case 1:
-		v = int(input[0])
-	case 3:
-		r := bytes.NewReader(input[:2])
-		var i uint16
-		if err := binary.Read(r, binary.BigEndian, &i); err != nil {
-			log.Printf("VariableInt Count Error 3: %v\n", err)
-			break
-		}
-		v = int(i)
-	case 5:
-		r := bytes.NewReader(input[:4])
-		var i uint32
-		if err := binary.Read(r, binary.BigEndian, &i); err != nil {
-			log.Printf("VariableInt Count Error 5: %v\n", err)
-			break
-		}
-		v = int(i)
-	case 9:
-		r := bytes.NewReader(input[:8])
-		var i uint64
-		if err := binary.Read(r, binary.BigEndian, &i); err != nil {
-			log.Printf("VariableInt Count Error 9: %v\n", err)
-			break
-		}
-		v = int(i)
+	if len(input) == 0 {
+		return 0
 	}
-
-	return v
+	v, err := ParseCompactSize(input)
+	if err != nil {
+		log.Printf("VariableInt Count Error %v: %v\n", len(input), err)
+		return 0
+	}
+	return int(v)
 }
 
 func ParseLEUint32(b []byte) uint32 {
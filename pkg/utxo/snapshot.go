@@ -0,0 +1,223 @@
+package utxo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lirancohen/blockparser/pkg/index"
+	"github.com/lirancohen/blockparser/pkg/parser"
+	"github.com/lirancohen/blockparser/pkg/utils"
+)
+
+const DefaultUndoDir = "./data/undo"
+
+var ErrBadSnapshot = errors.New("not a utxo snapshot")
+var ErrBadUndo = errors.New("not an undo file")
+var ErrBadCoin = errors.New("coin script larger than a block")
+
+var snapshotMagic = [4]byte{'B', 'P', 'U', 'S'}
+var undoMagic = [4]byte{'B', 'P', 'U', 'U'}
+
+const snapshotVersion = 1
+
+//Writes the whole set to path, creating its directory when needed
+func (s *Set) Snapshot(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	w.Write(snapshotMagic[:])
+	binary.Write(w, binary.LittleEndian, uint32(snapshotVersion))
+	binary.Write(w, binary.LittleEndian, int32(s.Height))
+	binary.Write(w, binary.LittleEndian, uint64(len(s.coins)))
+	//Coins are written in outpoint order so the same set always produces the same file
+	outpoints := make([]index.OutPoint, 0, len(s.coins))
+	for o := range s.coins {
+		outpoints = append(outpoints, o)
+	}
+	sort.Slice(outpoints, func(a, b int) bool {
+		if c := bytes.Compare(outpoints[a].Hash[:], outpoints[b].Hash[:]); c != 0 {
+			return c < 0
+		}
+		return outpoints[a].Index < outpoints[b].Index
+	})
+	for _, o := range outpoints {
+		writeCoin(w, o, s.coins[o])
+	}
+	return w.Flush()
+}
+
+func LoadSnapshot(path string) (*Set, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var header struct {
+		Magic   [4]byte
+		Version uint32
+		Height  int32
+		Count   uint64
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Magic != snapshotMagic {
+		return nil, ErrBadSnapshot
+	}
+	if header.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %v", header.Version)
+	}
+
+	s := New()
+	s.Height = int(header.Height)
+	for n := uint64(0); n < header.Count; n++ {
+		o, e, err := readCoin(r)
+		if err != nil {
+			return nil, err
+		}
+		s.coins[o] = e
+	}
+	return s, nil
+}
+
+//Path of the undo file for height inside dir
+func UndoPath(dir string, height int) string {
+	return filepath.Join(dir, fmt.Sprintf("%v.undo", height))
+}
+
+//Writes the undo data to dir, one file per height
+func (u *Undo) Save(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(UndoPath(dir, u.Height))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	w.Write(undoMagic[:])
+	binary.Write(w, binary.LittleEndian, int32(u.Height))
+	binary.Write(w, binary.LittleEndian, uint32(len(u.Spent)))
+	for _, c := range u.Spent {
+		writeCoin(w, c.OutPoint, c.Entry)
+	}
+	return w.Flush()
+}
+
+//Removes the undo files in dir below height, they are never deleted otherwise.
+//Blocks below height can't be rolled back with RollbackSaved afterwards.
+func PruneUndo(dir string, height int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".undo") {
+			continue
+		}
+		h, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".undo"))
+		if err != nil || h >= height {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func LoadUndo(dir string, height int) (*Undo, error) {
+	f, err := os.Open(UndoPath(dir, height))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var header struct {
+		Magic  [4]byte
+		Height int32
+		Count  uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Magic != undoMagic {
+		return nil, ErrBadUndo
+	}
+
+	u := &Undo{Height: int(header.Height)}
+	for n := uint32(0); n < header.Count; n++ {
+		o, e, err := readCoin(r)
+		if err != nil {
+			return nil, err
+		}
+		u.Spent = append(u.Spent, SpentCoin{OutPoint: o, Entry: e})
+	}
+	return u, nil
+}
+
+func writeCoin(w io.Writer, o index.OutPoint, e Entry) {
+	var coinbase uint8
+	if e.Coinbase {
+		coinbase = 1
+	}
+	w.Write(o.Hash[:])
+	binary.Write(w, binary.LittleEndian, o.Index)
+	binary.Write(w, binary.LittleEndian, int32(e.Height))
+	binary.Write(w, binary.LittleEndian, coinbase)
+	binary.Write(w, binary.LittleEndian, e.Value)
+	utils.CompactSize(len(e.Script)).WriteTo(w)
+	w.Write(e.Script)
+}
+
+func readCoin(r io.Reader) (index.OutPoint, Entry, error) {
+	var rec struct {
+		Hash     [32]byte
+		Index    uint32
+		Height   int32
+		Coinbase uint8
+		Value    uint64
+	}
+	if err := binary.Read(r, binary.LittleEndian, &rec); err != nil {
+		return index.OutPoint{}, Entry{}, err
+	}
+	l, err := utils.ReadCompactSize(r)
+	if err != nil {
+		return index.OutPoint{}, Entry{}, err
+	}
+	//A length from a corrupt file must not decide how much gets allocated
+	if l > parser.MaxFrameSize {
+		return index.OutPoint{}, Entry{}, fmt.Errorf("%w: %v bytes", ErrBadCoin, l)
+	}
+	script := make([]byte, l)
+	if _, err := io.ReadFull(r, script); err != nil {
+		return index.OutPoint{}, Entry{}, err
+	}
+	return index.OutPoint{Hash: rec.Hash, Index: rec.Index}, Entry{
+		Value:    rec.Value,
+		Script:   script,
+		Height:   int(rec.Height),
+		Coinbase: rec.Coinbase == 1,
+	}, nil
+}
@@ -0,0 +1,217 @@
+package utxo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lirancohen/blockparser/pkg/hashes"
+	"github.com/lirancohen/blockparser/pkg/index"
+	"github.com/lirancohen/blockparser/pkg/parser"
+)
+
+var ErrWrongHeight = errors.New("block doesn't follow the set's height")
+
+//Unspent transaction output
+type Entry struct {
+	Value    uint64
+	Script   []byte
+	Height   int
+	Coinbase bool
+}
+
+//Coin spent by a block, kept so the block can be rolled back
+type SpentCoin struct {
+	OutPoint index.OutPoint
+	Entry    Entry
+}
+
+//Undo data for a single block
+type Undo struct {
+	Height int
+	Spent  []SpentCoin
+	//Built on first use by FetchPrevOut
+	byOutPoint map[index.OutPoint]Entry
+}
+
+//Set of unspent outputs after applying every block up to Height
+type Set struct {
+	//Height of the last applied block, -1 for an empty set
+	Height int
+	//Where ApplyStream saves the undo data of every block it applies, nothing is saved when empty.
+	//Files are kept until PruneUndo removes them.
+	UndoDir string
+	coins   map[index.OutPoint]Entry
+}
+
+func New() *Set {
+	return &Set{
+		Height:  -1,
+		UndoDir: DefaultUndoDir,
+		coins:   make(map[index.OutPoint]Entry),
+	}
+}
+
+func (s *Set) Get(o index.OutPoint) (Entry, bool) {
+	e, ok := s.coins[o]
+	return e, ok
+}
+
+func (s *Set) Len() int {
+	return len(s.coins)
+}
+
+//Sum of every unspent output paying to script
+func (s *Set) Balance(script []byte) uint64 {
+	var total uint64
+	for _, e := range s.coins {
+		if string(e.Script) == string(script) {
+			total += e.Value
+		}
+	}
+	return total
+}
+
+//Removes the outpoints b spends and adds its outputs.
+//The returned undo data restores the set to the previous height.
+func (s *Set) ApplyBlock(b *parser.Block) (*Undo, error) {
+	if b.Height != s.Height+1 {
+		return nil, fmt.Errorf("%w: set at %v, block at %v", ErrWrongHeight, s.Height, b.Height)
+	}
+
+	undo := &Undo{Height: b.Height}
+	for i := range b.Transactions {
+		t := &b.Transactions[i]
+		coinbase := i == 0
+		if !coinbase {
+			for n := range t.Inputs {
+				o := t.Inputs[n].OutPoint()
+				e, ok := s.coins[o]
+				if !ok {
+					//Put the set back the way it was before failing
+					s.undo(b, i, undo)
+					return nil, fmt.Errorf("block %v: input %v of %v spends missing output %v:%v",
+						b.Height, n, t.HashString(), hashes.String(o.Hash[:]), o.Index)
+				}
+				undo.Spent = append(undo.Spent, SpentCoin{OutPoint: o, Entry: e})
+				delete(s.coins, o)
+			}
+		}
+		s.addOutputs(t, b.Height, coinbase)
+	}
+	s.Height = b.Height
+	return undo, nil
+}
+
+//Reverses ApplyBlock for the last applied block
+func (s *Set) Rollback(b *parser.Block, u *Undo) error {
+	if b.Height != s.Height || u.Height != b.Height {
+		return fmt.Errorf("%w: set at %v, block at %v, undo at %v", ErrWrongHeight, s.Height, b.Height, u.Height)
+	}
+	s.undo(b, len(b.Transactions), u)
+	s.Height = b.Height - 1
+	return nil
+}
+
+//Reverses the last applied block with the undo data ApplyStream saved to UndoDir
+func (s *Set) RollbackSaved(b *parser.Block) error {
+	u, err := LoadUndo(s.UndoDir, b.Height)
+	if err != nil {
+		return err
+	}
+	return s.Rollback(b, u)
+}
+
+//Restores what the first n transactions of b spent and removes their outputs.
+//Spent coins go back first so outputs created and spent inside the block end up removed.
+func (s *Set) undo(b *parser.Block, n int, u *Undo) {
+	for _, c := range u.Spent {
+		s.coins[c.OutPoint] = c.Entry
+	}
+	for i := n - 1; i >= 0; i-- {
+		t := &b.Transactions[i]
+		o := index.OutPoint{}
+		copy(o.Hash[:], t.Hash())
+		for v := range t.Outputs {
+			o.Index = uint32(v)
+			delete(s.coins, o)
+		}
+	}
+}
+
+func (s *Set) addOutputs(t *parser.Transaction, height int, coinbase bool) {
+	o := index.OutPoint{}
+	copy(o.Hash[:], t.Hash())
+	for v := range t.Outputs {
+		out := &t.Outputs[v]
+		//OP_RETURN outputs can never be spent
+		if script := out.Script(); len(script) > 0 && script[0] == 0x6a {
+			continue
+		}
+		o.Index = uint32(v)
+		s.coins[o] = Entry{
+			Value:    out.Value(),
+			Script:   out.Script(),
+			Height:   height,
+			Coinbase: coinbase,
+		}
+	}
+}
+
+//Applies blocks from st, following the chunk sequence with Next, until height is reached.
+//Blocks at or below the set's height are skipped so a set loaded from a snapshot resumes where it left off.
+//A negative height applies everything.
+func (s *Set) ApplyStream(st *parser.Stream, height int) error {
+	for height < 0 || s.Height < height {
+		b, err := st.ReadBlock()
+		if err == io.EOF {
+			if st, err = st.Next(); err == parser.ErrEOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if b.Height <= s.Height {
+			continue
+		}
+		undo, err := s.ApplyBlock(b)
+		if err != nil {
+			return err
+		}
+		if s.UndoDir != "" {
+			if err := undo.Save(s.UndoDir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+
+//Resolves previous outputs from the unspent set
+func (s *Set) FetchPrevOut(o index.OutPoint) (parser.TransOutput, error) {
+	e, ok := s.coins[o]
+	if !ok {
+		return parser.TransOutput{}, fmt.Errorf("output %v:%v is not in the unspent set", hashes.String(o.Hash[:]), o.Index)
+	}
+	return parser.NewTransOutput(e.Value, e.Script), nil
+}
+
+//Resolves the outputs spent by the block the undo data belongs to,
+//which is exactly what Block.FeeSummary needs for an applied block
+func (u *Undo) FetchPrevOut(o index.OutPoint) (parser.TransOutput, error) {
+	if u.byOutPoint == nil {
+		u.byOutPoint = make(map[index.OutPoint]Entry, len(u.Spent))
+		for _, c := range u.Spent {
+			u.byOutPoint[c.OutPoint] = c.Entry
+		}
+	}
+	if e, ok := u.byOutPoint[o]; ok {
+		return parser.NewTransOutput(e.Value, e.Script), nil
+	}
+	return parser.TransOutput{}, fmt.Errorf("output %v:%v is not spent by block %v", hashes.String(o.Hash[:]), o.Index, u.Height)
+}
@@ -0,0 +1,190 @@
+package utxo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lirancohen/blockparser/pkg/hashes"
+	"github.com/lirancohen/blockparser/pkg/index"
+	"github.com/lirancohen/blockparser/pkg/network"
+	"github.com/lirancohen/blockparser/pkg/parser"
+)
+
+//Coinbase paying 50 BTC to OP_1, tag keeps the txids of different blocks apart
+func coinbaseTx(tag byte) []byte {
+	return mustHex("01000000" + "01" + "0000000000000000000000000000000000000000000000000000000000000000" + "ffffffff" +
+		"02" + hex.EncodeToString([]byte{tag, tag}) + "ffffffff" +
+		"01" + "00f2052a01000000" + "01" + "51" + "00000000")
+}
+
+//Spends output 0 of prev into a single OP_2 output of value
+func spendTx(prev []byte, value uint64) []byte {
+	v := make([]byte, 8)
+	binary.LittleEndian.PutUint64(v, value)
+	return mustHex("01000000" + "01" + hex.EncodeToString(prev) + "00000000" + "00" + "ffffffff" +
+		"01" + hex.EncodeToString(v) + "01" + "52" + "00000000")
+}
+
+//Frames a block holding txs, the header is only there to be skipped
+func frameBlock(txs ...[]byte) []byte {
+	payload := make([]byte, 80)
+	payload = append(payload, byte(len(txs)))
+	for _, t := range txs {
+		payload = append(payload, t...)
+	}
+	frame := append([]byte{}, network.MainNet.Magic[:]...)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestApplyStreamRollbackSnapshot(t *testing.T) {
+	cb0 := coinbaseTx(0)
+	t1 := spendTx(hashes.Hash256(cb0), 100000000)
+	t2 := spendTx(hashes.Hash256(t1), 50000000)
+	frames := append(frameBlock(cb0), frameBlock(coinbaseTx(1), t1, t2)...)
+
+	dir := t.TempDir()
+	s := New()
+	s.UndoDir = filepath.Join(dir, "undo")
+	st := parser.NewStream(network.MainNet, "0_1.dat", bytes.NewReader(frames), 0, 1)
+	if err := s.ApplyStream(st, 1); err != nil {
+		t.Fatal(err)
+	}
+	//Block 1 spent the genesis coinbase and t1, leaving its own coinbase and t2
+	if s.Height != 1 || s.Len() != 2 || s.Balance([]byte{0x51}) != 5000000000 || s.Balance([]byte{0x52}) != 50000000 {
+		t.Fatalf("after apply: height %v coins %v", s.Height, s.Len())
+	}
+	for h := 0; h <= 1; h++ {
+		if _, err := os.Stat(UndoPath(s.UndoDir, h)); err != nil {
+			t.Fatalf("undo data for block %v: %v", h, err)
+		}
+	}
+
+	snap := filepath.Join(dir, "utxo.snap")
+	if err := s.Snapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSnapshot(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Height != s.Height || loaded.Len() != s.Len() || loaded.Balance([]byte{0x52}) != 50000000 {
+		t.Fatalf("snapshot: height %v coins %v", loaded.Height, loaded.Len())
+	}
+
+	b1, err := parser.NewBlockParser(bytes.NewReader(frameBlock(coinbaseTx(1), t1, t2)), nil, nil).Decode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.UndoDir = s.UndoDir
+	if err := loaded.RollbackSaved(b1); err != nil {
+		t.Fatal(err)
+	}
+	o := index.OutPoint{}
+	copy(o.Hash[:], hashes.Hash256(cb0))
+	if e, ok := loaded.Get(o); loaded.Height != 0 || loaded.Len() != 1 || !ok || !e.Coinbase || e.Value != 5000000000 {
+		t.Fatalf("after rollback: height %v coins %v", loaded.Height, loaded.Len())
+	}
+	if err := loaded.RollbackSaved(b1); err == nil {
+		t.Error("rolled back block 1 twice")
+	}
+}
+
+//Coins come out of the snapshot in outpoint order whatever order the map holds them in
+func TestSnapshotSorted(t *testing.T) {
+	s := New()
+	for i := 0; i < 50; i++ {
+		o := index.OutPoint{Index: uint32(i % 3)}
+		o.Hash[0] = byte(i * 37)
+		s.coins[o] = Entry{Value: uint64(i), Script: []byte{0x51}}
+	}
+	path := filepath.Join(t.TempDir(), "utxo.snap")
+	if err := s.Snapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	first, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bytes.NewReader(first[20:])
+	var prev index.OutPoint
+	for n := 0; n < s.Len(); n++ {
+		o, _, err := readCoin(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n > 0 {
+			if c := bytes.Compare(prev.Hash[:], o.Hash[:]); c > 0 || c == 0 && prev.Index >= o.Index {
+				t.Fatalf("coin %v out of order", n)
+			}
+		}
+		prev = o
+	}
+
+	if err := s.Snapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	if second, _ := os.ReadFile(path); !bytes.Equal(first, second) {
+		t.Error("snapshots of the same set differ")
+	}
+}
+
+func TestLoadSnapshotHugeScript(t *testing.T) {
+	s := New()
+	s.coins[index.OutPoint{}] = Entry{Value: 1, Script: []byte{0x51}}
+	path := filepath.Join(t.TempDir(), "utxo.snap")
+	if err := s.Snapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Replace the one byte script length with a 0xff prefixed one claiming 2^56 bytes
+	l := len(data) - 2
+	data = append(append(data[:l:l], 0xff, 0, 0, 0, 0, 0, 0, 0, 1), 0x51)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSnapshot(path); !errors.Is(err, ErrBadCoin) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestPruneUndo(t *testing.T) {
+	dir := t.TempDir()
+	for h := 0; h < 5; h++ {
+		if err := (&Undo{Height: h}).Save(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(other, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := PruneUndo(dir, 3); err != nil {
+		t.Fatal(err)
+	}
+	for h := 0; h < 5; h++ {
+		if _, err := LoadUndo(dir, h); (err == nil) != (h >= 3) {
+			t.Errorf("block %v: %v", h, err)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("removed a file that isn't undo data: %v", err)
+	}
+}